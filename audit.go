@@ -0,0 +1,264 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package uberalls
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Audited actions
+const (
+	AuditMetricCreate     = "metric.create"
+	AuditShardCreate      = "shard.create"
+	AuditTokenCreate      = "token.create"
+	AuditTokenDelete      = "token.delete"
+	AuditVisibilityUpdate = "visibility.update"
+	AuditGrantCreate      = "grant.create"
+	AuditGrantUpdate      = "grant.update"
+	AuditGrantDelete      = "grant.delete"
+	AuditGroupCreate      = "group.create"
+	AuditGroupDelete      = "group.delete"
+	AuditConfigReload     = "config.reload"
+)
+
+// auditSystemActor is the actor of changes uberalls makes by itself, such as
+// finalizing expired upload sessions
+const auditSystemActor = "system"
+
+// AuditEntry records one write or admin operation. Entries are only ever
+// appended.
+type AuditEntry struct {
+	ID         int64           `gorm:"primary_key:yes" json:"id"`
+	Timestamp  int64           `sql:"not null" json:"timestamp"`
+	Actor      string          `sql:"not null" json:"actor"`
+	SourceIP   string          `json:"sourceIP,omitempty"`
+	RequestID  string          `json:"requestId,omitempty"`
+	Action     string          `sql:"not null" json:"action"`
+	Repository string          `json:"repository,omitempty"`
+	Target     string          `json:"target,omitempty"`
+	BeforeJSON string          `sql:"type:text" json:"-"`
+	AfterJSON  string          `sql:"type:text" json:"-"`
+	Before     json.RawMessage `sql:"-" json:"before,omitempty"`
+	After      json.RawMessage `sql:"-" json:"after,omitempty"`
+}
+
+// expand fills in the entry's JSON-only fields from its stored columns
+func (e *AuditEntry) expand() {
+	e.Before, e.After = nil, nil
+	if e.BeforeJSON != "" {
+		e.Before = json.RawMessage(e.BeforeJSON)
+	}
+	if e.AfterJSON != "" {
+		e.After = json.RawMessage(e.AfterJSON)
+	}
+}
+
+// AuditPage is a page of audit entries
+type AuditPage struct {
+	Entries []AuditEntry `json:"entries"`
+	// Next is the 'after' parameter for the following page, if there is one
+	Next string `json:"next,omitempty"`
+}
+
+// Audit appends an entry for an operation on target, describing the state
+// before and after it; either may be nil. The actor, source IP and request ID
+// come from r, which is nil for changes uberalls makes by itself.
+func Audit(db *gorm.DB, r *http.Request, action, repository, target string, before, after interface{}) {
+	entry := AuditEntry{
+		Timestamp:  time.Now().Unix(),
+		Actor:      auditSystemActor,
+		Action:     action,
+		Repository: repository,
+		Target:     target,
+	}
+	if r != nil {
+		entry.Actor = requestActor(r)
+		entry.SourceIP = sourceIP(r)
+		entry.RequestID = r.Header.Get("X-Request-ID")
+	}
+	for _, state := range []struct {
+		value  interface{}
+		column *string
+	}{{before, &entry.BeforeJSON}, {after, &entry.AfterJSON}} {
+		if state.value == nil {
+			continue
+		}
+		encoded, err := json.Marshal(state.value)
+		if err != nil {
+			logger.Error("Unable to encode audit state", "target", target, "error", err)
+			continue
+		}
+		*state.column = string(encoded)
+	}
+
+	if err := db.Create(&entry).Error; err != nil {
+		logger.Error("Unable to record audit entry", "action", action, "target", target, "error", err)
+	}
+}
+
+// requestActor names the principal making a request
+func requestActor(r *http.Request) string {
+	info, ok := r.Context().Value(authContextKey{}).(*requestAuth)
+	if !ok || info.principal == nil {
+		return "anonymous"
+	}
+	return info.principal.Name
+}
+
+// auditTarget identifies a row of a kind, like "metric/42"
+func auditTarget(kind string, id int64) string {
+	return kind + "/" + strconv.FormatInt(id, 10)
+}
+
+func sourceIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// AuditHandler serves the audit log
+type AuditHandler struct {
+	db *gorm.DB
+}
+
+// NewAuditHandler creates a new AuditHandler
+func NewAuditHandler(db *gorm.DB) AuditHandler {
+	return AuditHandler{db: db}
+}
+
+// ServeHTTP handles GET /audit. The admin token can read every entry;
+// repository admins can read a repository's entries by filtering on it.
+func (ah AuditHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		writeError(w, r, "unsupported method", errors.New(r.Method))
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, r, "error parsing params", err)
+		return
+	}
+
+	repository := r.Form.Get("repository")
+	if _, err := isAdmin(r); err != nil && repository == "" {
+		w.WriteHeader(http.StatusForbidden)
+		writeError(w, r, "unauthorized", errors.New("the audit log of all repositories needs the admin token"))
+		return
+	} else if err != nil && !authorize(w, r, repository, PermissionManage) {
+		return
+	}
+
+	query, err := ah.filter(r.Form)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, r, "invalid filter", err)
+		return
+	}
+
+	if r.Form.Get("format") == "ndjson" {
+		ah.export(w, query)
+		return
+	}
+
+	limit, err := pageSize(r.Form)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, r, "invalid 'limit'", err)
+		return
+	}
+	page := AuditPage{Entries: []AuditEntry{}}
+	query.Order("id").Limit(limit).Find(&page.Entries)
+	for i := range page.Entries {
+		page.Entries[i].expand()
+	}
+	if len(page.Entries) == limit {
+		page.Next = strconv.FormatInt(page.Entries[limit-1].ID, 10)
+	}
+	respondWithJSON(w, r, page)
+}
+
+// filter narrows the audit log by actor, action, repository, target, request
+// ID and time range, starting after a given entry ID
+func (ah AuditHandler) filter(form map[string][]string) (*gorm.DB, error) {
+	query := ah.db
+	for _, column := range []struct{ param, name string }{
+		{"actor", "actor"},
+		{"action", "action"},
+		{"repository", "repository"},
+		{"target", "target"},
+		{"requestId", "request_id"},
+	} {
+		if values := form[column.param]; len(values) > 0 && values[0] != "" {
+			query = query.Where(column.name+" = ?", values[0])
+		}
+	}
+	for _, bound := range []struct{ param, condition string }{
+		{"since", "timestamp >= ?"},
+		{"until", "timestamp <= ?"},
+		{"after", "id > ?"},
+	} {
+		if values := form[bound.param]; len(values) > 0 && values[0] != "" {
+			value, err := strconv.ParseInt(values[0], 10, 64)
+			if err != nil {
+				return nil, errors.New("'" + bound.param + "' must be an integer")
+			}
+			query = query.Where(bound.condition, value)
+		}
+	}
+	return query, nil
+}
+
+// export streams every matching entry as newline-delimited JSON, reading
+// the log in pages so large exports don't need to fit in memory
+func (ah AuditHandler) export(w http.ResponseWriter, query *gorm.DB) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Content-Disposition", `attachment; filename="audit.ndjson"`)
+
+	encoder := json.NewEncoder(w)
+	after := int64(0)
+	for {
+		var entries []AuditEntry
+		if err := query.Where("id > ?", after).Order("id").Limit(maxPageSize).Find(&entries).Error; err != nil {
+			logger.Error("Unable to export audit log", "error", err)
+			return
+		}
+		for _, entry := range entries {
+			entry.expand()
+			encoder.Encode(entry)
+		}
+		if len(entries) < maxPageSize {
+			return
+		}
+		after = entries[len(entries)-1].ID
+	}
+}
@@ -0,0 +1,182 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package uberalls_test
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/uber/uberalls"
+)
+
+var _ = Describe("Audit log", func() {
+	var (
+		db         *gorm.DB
+		metrics    http.Handler
+		access     http.Handler
+		audit      http.Handler
+		repository string
+		uploader   string
+	)
+
+	getAuditPage := func(url, secret string) (int, AuditPage) {
+		response := getAuthenticatedResponse(audit, "GET", url, "", secret)
+		page := AuditPage{}
+		if response.Code == http.StatusOK {
+			Expect(json.NewDecoder(response.Body).Decode(&page)).To(Succeed())
+		}
+		return response.Code, page
+	}
+
+	BeforeEach(func() {
+		config := &Config{
+			DBType:     "sqlite3",
+			DBLocation: "test.sqlite",
+			AdminToken: "admin-secret",
+		}
+		db, _ = config.DB()
+		Expect(config.Automigrate()).To(Succeed())
+
+		repository = fmt.Sprintf("audited/%x", time.Now().UnixNano())
+		minted, err := NewTokensHandler(db).Mint(TokenRequest{
+			Name:         "ci-" + repository,
+			Repositories: []string{repository},
+			Permissions:  []string{PermissionUpload},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		uploader = minted.Secret
+
+		auth := NewAuth(db, config)
+		metrics = auth.Wrap(NewMetricsHandler(db, nil))
+		access = auth.Wrap(NewAccessHandler(db))
+		audit = auth.Wrap(NewAuditHandler(db))
+	})
+
+	It("Should record who uploaded a metric", func() {
+		body := fmt.Sprintf(`{"repository": %q, "sha": "deadbeef", "lineCoverage": 42}`, repository)
+		request, _ := http.NewRequest("POST", "/metrics", strings.NewReader(body))
+		request.Header.Set("Authorization", "Bearer "+uploader)
+		request.Header.Set("X-Request-ID", "req-1")
+		request.RemoteAddr = "10.1.2.3:4567"
+		response := httptest.NewRecorder()
+		metrics.ServeHTTP(response, request)
+		Expect(response.Code).To(Equal(http.StatusOK))
+
+		code, page := getAuditPage("/audit?repository="+repository, "admin-secret")
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(page.Entries).To(HaveLen(1))
+
+		entry := page.Entries[0]
+		Expect(entry.Action).To(Equal(AuditMetricCreate))
+		Expect(entry.Actor).To(Equal("ci-" + repository))
+		Expect(entry.SourceIP).To(Equal("10.1.2.3"))
+		Expect(entry.RequestID).To(Equal("req-1"))
+		Expect(entry.Target).To(HavePrefix("metric/"))
+		Expect(entry.Before).To(BeEmpty())
+
+		after := Metric{}
+		Expect(json.Unmarshal(entry.After, &after)).To(Succeed())
+		Expect(after.Sha).To(Equal("deadbeef"))
+		Expect(after.LineCoverage).To(BeEquivalentTo(42))
+	})
+
+	It("Should record policy changes with their previous state", func() {
+		for _, role := range []string{RoleViewer, RoleUploader} {
+			body := fmt.Sprintf(`{"repository": %q, "user": "alice", "role": %q}`, repository, role)
+			Expect(getAuthenticatedResponse(access, "POST", "/admin/grants", body, "admin-secret").Code).To(Equal(http.StatusOK))
+		}
+
+		code, page := getAuditPage("/audit?repository="+repository+"&action="+AuditGrantUpdate, "admin-secret")
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(page.Entries).To(HaveLen(1))
+		Expect(page.Entries[0].Actor).To(Equal("admin"))
+
+		before, after := Grant{}, Grant{}
+		Expect(json.Unmarshal(page.Entries[0].Before, &before)).To(Succeed())
+		Expect(json.Unmarshal(page.Entries[0].After, &after)).To(Succeed())
+		Expect(before.Role).To(Equal(RoleViewer))
+		Expect(after.Role).To(Equal(RoleUploader))
+	})
+
+	It("Should page through entries", func() {
+		for _, visibility := range []string{VisibilityPrivate, VisibilityPublic, VisibilityPrivate} {
+			body := fmt.Sprintf(`{"repository": %q, "visibility": %q}`, repository, visibility)
+			Expect(getAuthenticatedResponse(access, "POST", "/admin/visibility", body, "admin-secret").Code).To(Equal(http.StatusOK))
+		}
+
+		_, page := getAuditPage("/audit?limit=2&repository="+repository, "admin-secret")
+		Expect(page.Entries).To(HaveLen(2))
+		Expect(page.Next).ToNot(BeEmpty())
+
+		_, page = getAuditPage("/audit?limit=2&repository="+repository+"&after="+page.Next, "admin-secret")
+		Expect(page.Entries).To(HaveLen(1))
+		Expect(page.Next).To(BeEmpty())
+	})
+
+	It("Should export entries as NDJSON", func() {
+		for _, visibility := range []string{VisibilityPrivate, VisibilityPublic} {
+			body := fmt.Sprintf(`{"repository": %q, "visibility": %q}`, repository, visibility)
+			getAuthenticatedResponse(access, "POST", "/admin/visibility", body, "admin-secret")
+		}
+
+		response := getAuthenticatedResponse(audit, "GET", "/audit?format=ndjson&repository="+repository, "", "admin-secret")
+		Expect(response.Code).To(Equal(http.StatusOK))
+		Expect(response.Header().Get("Content-Type")).To(Equal("application/x-ndjson"))
+
+		var actions []string
+		scanner := bufio.NewScanner(response.Body)
+		for scanner.Scan() {
+			entry := AuditEntry{}
+			Expect(json.Unmarshal(scanner.Bytes(), &entry)).To(Succeed())
+			actions = append(actions, entry.Action)
+		}
+		Expect(actions).To(Equal([]string{AuditVisibilityUpdate, AuditVisibilityUpdate}))
+	})
+
+	It("Should reject invalid filters", func() {
+		code, _ := getAuditPage("/audit?since=yesterday", "admin-secret")
+		Expect(code).To(Equal(http.StatusBadRequest))
+	})
+
+	It("Should restrict the log to admins", func() {
+		code, _ := getAuditPage("/audit", uploader)
+		Expect(code).To(Equal(http.StatusForbidden))
+
+		code, _ = getAuditPage("/audit?repository="+repository, uploader)
+		Expect(code).To(Equal(http.StatusForbidden))
+
+		body := fmt.Sprintf(`{"repository": %q, "user": "alice", "role": "admin"}`, repository)
+		getAuthenticatedResponse(access, "POST", "/admin/grants", body, "admin-secret")
+		alice, _ := NewTokensHandler(db).Mint(TokenRequest{Name: "alice", User: "alice"})
+		code, page := getAuditPage("/audit?repository="+repository, alice.Secret)
+		Expect(code).To(Equal(http.StatusOK))
+		Expect(page.Entries).ToNot(BeEmpty())
+	})
+})
@@ -0,0 +1,338 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package uberalls
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Permissions on a repository. Tokens can be scoped to read and upload;
+// managing a repository's grants needs its admin role.
+const (
+	PermissionRead   = "read"
+	PermissionUpload = "upload"
+	PermissionManage = "manage"
+)
+
+// tokenPrefix marks secrets minted by uberalls
+const tokenPrefix = "uat_"
+
+// Token is an API token, scoped to repositories matching any of its globs,
+// and acting as a user when it belongs to one. Only a hash of the secret is
+// stored.
+type Token struct {
+	ID           int64    `gorm:"primary_key:yes" json:"id"`
+	Name         string   `sql:"not null" json:"name"`
+	UserName     string   `json:"user,omitempty"`
+	Hash         string   `sql:"not null" json:"-"`
+	Scopes       string   `sql:"type:text;not null" json:"-"`
+	CanRead      bool     `sql:"not null" json:"-"`
+	CanUpload    bool     `sql:"not null" json:"-"`
+	Timestamp    int64    `sql:"not null" json:"timestamp"`
+	Repositories []string `sql:"-" json:"repositories"`
+	Permissions  []string `sql:"-" json:"permissions"`
+}
+
+// Allows reports whether the token grants a permission on a repository
+func (t Token) Allows(repository, permission string) bool {
+	switch permission {
+	case PermissionRead:
+		if !t.CanRead {
+			return false
+		}
+	case PermissionUpload:
+		if !t.CanUpload {
+			return false
+		}
+	default:
+		return false
+	}
+	for _, glob := range strings.Split(t.Scopes, "\n") {
+		if glob != "" && MatchGlob(glob, repository) {
+			return true
+		}
+	}
+	return false
+}
+
+// expand fills in the token's JSON-only fields from its stored columns
+func (t *Token) expand() {
+	t.Repositories = nil
+	if t.Scopes != "" {
+		t.Repositories = strings.Split(t.Scopes, "\n")
+	}
+	t.Permissions = nil
+	if t.CanRead {
+		t.Permissions = append(t.Permissions, PermissionRead)
+	}
+	if t.CanUpload {
+		t.Permissions = append(t.Permissions, PermissionUpload)
+	}
+}
+
+// NewSecret generates a random token secret
+func NewSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return tokenPrefix + hex.EncodeToString(b), nil
+}
+
+// HashSecret returns the stored form of a token secret
+func HashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	Name     string
+	Admin    bool
+	Token    *Token
+	User     string
+	Groups   []string
+	Workload *WorkloadIdentity
+}
+
+// Allows reports whether the principal holds a permission on a repository
+// through its token's scopes or workload identity, or by being an admin
+func (p Principal) Allows(repository, permission string) bool {
+	return p.Admin ||
+		(p.Token != nil && p.Token.Allows(repository, permission)) ||
+		(p.Workload != nil && p.Workload.Allows(repository, permission))
+}
+
+type authContextKey struct{}
+
+// requestAuth is attached to requests that went through Auth
+type requestAuth struct {
+	auth      Auth
+	principal *Principal
+}
+
+// Auth authenticates requests with API tokens or OIDC tokens. Handlers check the
+// permissions of the authenticated principal with authorize.
+type Auth struct {
+	db     *gorm.DB
+	lock   *sync.RWMutex
+	policy *authPolicy
+}
+
+// authPolicy holds the settings of an Auth that can be reloaded
+type authPolicy struct {
+	required      bool
+	anonymousRead bool
+	adminToken    string
+	oidcConfig    OIDCConfig
+	oidc          *OIDCVerifier
+}
+
+// NewAuth creates an Auth from configuration
+func NewAuth(db *gorm.DB, config *Config) Auth {
+	auth := Auth{db: db, lock: new(sync.RWMutex), policy: new(authPolicy)}
+	auth.Update(config)
+	return auth
+}
+
+// Update replaces the policy settings of the Auth. The OIDC verifier, and its
+// cached keys, are kept unless its configuration changed.
+func (a Auth) Update(config *Config) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	oidc := a.policy.oidc
+	if !reflect.DeepEqual(config.OIDC, a.policy.oidcConfig) {
+		oidc = nil
+		if config.OIDC.Enabled() {
+			oidc = NewOIDCVerifier(config.OIDC)
+		}
+	}
+	*a.policy = authPolicy{
+		required:      config.AuthRequired,
+		anonymousRead: config.AnonymousRead,
+		adminToken:    config.AdminToken,
+		oidcConfig:    config.OIDC,
+		oidc:          oidc,
+	}
+}
+
+// current returns a copy of the policy settings
+func (a Auth) current() authPolicy {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+	return *a.policy
+}
+
+// Wrap authenticates requests before passing them to next, rejecting those
+// presenting an unknown token, with a 429 once their client IP is over its
+// rate limit
+func (a Auth) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		info := &requestAuth{auth: a}
+		if secret := requestSecret(r); secret != "" {
+			principal, err := a.Authenticate(secret)
+			if err != nil {
+				if wait := takeUnauthenticated(r); wait > 0 {
+					tooManyRequests(w, r, wait)
+					return
+				}
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusUnauthorized)
+				writeError(w, r, "invalid credentials", err)
+				return
+			}
+			info.principal = principal
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), authContextKey{}, info)))
+	})
+}
+
+// Authenticate resolves a secret to the principal it belongs to
+func (a Auth) Authenticate(secret string) (*Principal, error) {
+	policy := a.current()
+	if policy.adminToken != "" && subtle.ConstantTimeCompare([]byte(secret), []byte(policy.adminToken)) == 1 {
+		return &Principal{Name: "admin", Admin: true}, nil
+	}
+
+	if policy.oidc != nil && LooksLikeJWT(secret) {
+		workload, err := policy.oidc.Verify(secret)
+		if err != nil {
+			return nil, err
+		}
+		return &Principal{Name: workload.Subject, Workload: workload}, nil
+	}
+
+	token := new(Token)
+	a.db.Where("hash = ?", HashSecret(secret)).First(token)
+	if token.ID == 0 {
+		return nil, errors.New("unknown token")
+	}
+
+	principal := &Principal{Name: token.Name, Token: token}
+	if token.UserName != "" {
+		principal.Name = token.UserName
+		principal.User = token.UserName
+		principal.Groups = groupsOf(a.db, token.UserName)
+	}
+	return principal, nil
+}
+
+// Check decides whether a principal, nil when unauthenticated, holds a
+// permission on a repository, returning the HTTP status to reject it with.
+// Public repositories stay open when authentication isn't required, or to
+// readers when anonymous reads are allowed; private ones always need a token
+// scope or a role. OIDC workloads are confined to their claimed repository.
+func (a Auth) Check(p *Principal, repository, permission string) (int, error) {
+	if p != nil && (p.Allows(repository, permission) || hasRole(a.db, *p, repository, permission)) {
+		return http.StatusOK, nil
+	}
+
+	// A workload may only act on the repository its token claims, however
+	// open the others are
+	if p != nil && p.Workload != nil && p.Workload.Repository != repository {
+		return http.StatusForbidden, fmt.Errorf("token claims repository '%s', not '%s'", p.Workload.Repository, repository)
+	}
+
+	policy := a.current()
+	open := !policy.required || (permission == PermissionRead && policy.anonymousRead)
+	if open && permission != PermissionManage && !isPrivate(a.db, repository) {
+		return http.StatusOK, nil
+	}
+
+	if p == nil {
+		return http.StatusUnauthorized, errors.New("authentication required")
+	}
+	return http.StatusForbidden, errors.New(permission + " access to '" + repository + "' denied")
+}
+
+// requestSecret extracts a secret from a bearer token, or from the password
+// of basic authentication so browsers can prompt for it
+func requestSecret(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if strings.HasPrefix(header, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+	}
+	if _, password, ok := r.BasicAuth(); ok {
+		return password
+	}
+	return ""
+}
+
+// permitted checks a request's permission on a repository, returning the
+// HTTP status to reject it with. Requests that didn't go through Auth are
+// always permitted.
+func permitted(r *http.Request, repository, permission string) (int, error) {
+	noteRepository(r, repository)
+	info, ok := r.Context().Value(authContextKey{}).(*requestAuth)
+	if !ok {
+		return http.StatusOK, nil
+	}
+	return info.auth.Check(info.principal, repository, permission)
+}
+
+// authorize checks a request's permission on a repository, responding with a
+// JSON error if it is missing
+func authorize(w http.ResponseWriter, r *http.Request, repository, permission string) bool {
+	status, err := permitted(r, repository, permission)
+	if err != nil {
+		w.WriteHeader(status)
+		writeError(w, r, "unauthorized", err)
+		return false
+	}
+	return true
+}
+
+// requestPrincipal returns the principal a request authenticated as, or nil
+func requestPrincipal(r *http.Request) *Principal {
+	info, ok := r.Context().Value(authContextKey{}).(*requestAuth)
+	if !ok {
+		return nil
+	}
+	return info.principal
+}
+
+// isAdmin checks whether a request may use the admin API, which always needs
+// the admin token once requests go through Auth
+func isAdmin(r *http.Request) (int, error) {
+	info, ok := r.Context().Value(authContextKey{}).(*requestAuth)
+	switch {
+	case !ok:
+		return http.StatusOK, nil
+	case info.principal == nil:
+		return http.StatusUnauthorized, errors.New("authentication required")
+	case !info.principal.Admin:
+		return http.StatusForbidden, errors.New("admin access denied")
+	}
+	return http.StatusOK, nil
+}
@@ -0,0 +1,183 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package uberalls_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/uber/uberalls"
+)
+
+func getAuthenticatedResponse(handler http.Handler, method, url, body, secret string) *httptest.ResponseRecorder {
+	request, _ := http.NewRequest(method, url, strings.NewReader(body))
+	if secret != "" {
+		request.Header.Set("Authorization", "Bearer "+secret)
+	}
+	response := httptest.NewRecorder()
+	handler.ServeHTTP(response, request)
+	return response
+}
+
+var _ = Describe("Authentication", func() {
+	Context("Token scopes", func() {
+		token := Token{Scopes: "uber/*\nother", CanRead: true}
+
+		It("Should allow matching repositories", func() {
+			Expect(token.Allows("uber/uberalls", PermissionRead)).To(BeTrue())
+			Expect(token.Allows("other", PermissionRead)).To(BeTrue())
+		})
+
+		It("Should deny other repositories", func() {
+			Expect(token.Allows("uber/uberalls/fork", PermissionRead)).To(BeFalse())
+			Expect(token.Allows("another", PermissionRead)).To(BeFalse())
+		})
+
+		It("Should deny permissions it lacks", func() {
+			Expect(token.Allows("uber/uberalls", PermissionUpload)).To(BeFalse())
+		})
+	})
+
+	It("Should hash secrets", func() {
+		secret, err := NewSecret()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(HashSecret(secret)).ToNot(ContainSubstring(secret))
+		Expect(HashSecret(secret)).To(Equal(HashSecret(secret)))
+	})
+
+	Context("Protecting the metrics endpoint", func() {
+		var (
+			db       *gorm.DB
+			config   *Config
+			handler  http.Handler
+			uploader string
+			reader   string
+		)
+		record := `{"repository": "secure/repo", "sha": "deadbeef", "lineCoverage": 42}`
+
+		BeforeEach(func() {
+			config = &Config{
+				DBType:       "sqlite3",
+				DBLocation:   "test.sqlite",
+				AuthRequired: true,
+				AdminToken:   "admin-secret",
+			}
+			db, _ = config.DB()
+			Expect(config.Automigrate()).To(Succeed())
+
+			tokens := NewTokensHandler(db)
+			minted, err := tokens.Mint(TokenRequest{
+				Name:         "ci",
+				Repositories: []string{"secure/*"},
+				Permissions:  []string{PermissionUpload},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			uploader = minted.Secret
+
+			minted, err = tokens.Mint(TokenRequest{
+				Name:         "reader",
+				Repositories: []string{"secure/repo"},
+				Permissions:  []string{PermissionRead},
+			})
+			Expect(err).ToNot(HaveOccurred())
+			reader = minted.Secret
+		})
+
+		JustBeforeEach(func() {
+			handler = NewAuth(db, config).Wrap(NewMetricsHandler(db, nil))
+		})
+
+		It("Should reject anonymous uploads", func() {
+			response := getAuthenticatedResponse(handler, "POST", "/metrics", record, "")
+			Expect(response.Code).To(Equal(http.StatusUnauthorized))
+
+			body := map[string]string{}
+			Expect(json.NewDecoder(response.Body).Decode(&body)).To(Succeed())
+			Expect(body["error"]).To(ContainSubstring("authentication required"))
+		})
+
+		It("Should reject unknown tokens", func() {
+			response := getAuthenticatedResponse(handler, "GET", "/metrics?repository=secure/repo", "", "bogus")
+			Expect(response.Code).To(Equal(http.StatusUnauthorized))
+		})
+
+		It("Should accept uploads with an upload token", func() {
+			response := getAuthenticatedResponse(handler, "POST", "/metrics", record, uploader)
+			Expect(response.Code).To(Equal(http.StatusOK))
+		})
+
+		It("Should forbid uploads outside the token's scope", func() {
+			body := strings.Replace(record, "secure/repo", "public/repo", 1)
+			response := getAuthenticatedResponse(handler, "POST", "/metrics", body, uploader)
+			Expect(response.Code).To(Equal(http.StatusForbidden))
+		})
+
+		It("Should forbid uploads with a read token", func() {
+			response := getAuthenticatedResponse(handler, "POST", "/metrics", record, reader)
+			Expect(response.Code).To(Equal(http.StatusForbidden))
+		})
+
+		It("Should allow reads with a read token", func() {
+			getAuthenticatedResponse(handler, "POST", "/metrics", record, uploader)
+			response := getAuthenticatedResponse(handler, "GET", "/metrics?repository=secure/repo&sha=deadbeef", "", reader)
+			Expect(response.Code).To(Equal(http.StatusOK))
+		})
+
+		It("Should accept the admin token", func() {
+			response := getAuthenticatedResponse(handler, "POST", "/metrics", record, "admin-secret")
+			Expect(response.Code).To(Equal(http.StatusOK))
+		})
+
+		Context("With anonymous reads", func() {
+			BeforeEach(func() {
+				config.AnonymousRead = true
+			})
+
+			It("Should allow anonymous reads", func() {
+				getAuthenticatedResponse(handler, "POST", "/metrics", record, uploader)
+				response := getAuthenticatedResponse(handler, "GET", "/metrics?repository=secure/repo&sha=deadbeef", "", "")
+				Expect(response.Code).To(Equal(http.StatusOK))
+			})
+
+			It("Should still reject anonymous uploads", func() {
+				response := getAuthenticatedResponse(handler, "POST", "/metrics", record, "")
+				Expect(response.Code).To(Equal(http.StatusUnauthorized))
+			})
+		})
+
+		Context("When not required", func() {
+			BeforeEach(func() {
+				config.AuthRequired = false
+			})
+
+			It("Should allow anonymous uploads", func() {
+				response := getAuthenticatedResponse(handler, "POST", "/metrics", record, "")
+				Expect(response.Code).To(Equal(http.StatusOK))
+			})
+		})
+	})
+})
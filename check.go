@@ -0,0 +1,219 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package uberalls
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/uber/uberalls/client"
+	"github.com/uber/uberalls/coverage"
+)
+
+// Check results
+const (
+	CheckPassed  = "pass"
+	CheckFailed  = "FAIL"
+	CheckSkipped = "skipped"
+)
+
+// Thresholds are the coverage a check requires, in percent or percentage
+// points. Negative values disable a threshold.
+type Thresholds struct {
+	MinLine        float64
+	MinConditional float64
+	// MaxLineDrop is how far line coverage may fall below the base's
+	MaxLineDrop float64
+	// MaxConditionalDrop is how far conditional coverage may fall below the
+	// base's
+	MaxConditionalDrop float64
+	// RequireBase fails the drop thresholds when there is no base to compare
+	// with, instead of skipping them
+	RequireBase bool
+}
+
+// CheckResult is the outcome of one threshold
+type CheckResult struct {
+	Name     string
+	Actual   string
+	Required string
+	Result   string
+}
+
+// CheckCoverage applies thresholds to a commit's coverage and, for the drop
+// thresholds, to its change from base, which is nil if there is none
+func CheckCoverage(head Metric, base *Metric, t Thresholds) []CheckResult {
+	var results []CheckResult
+	minimum := func(name string, actual, limit float64) {
+		if limit < 0 {
+			return
+		}
+		results = append(results, CheckResult{
+			Name:     name,
+			Actual:   fmt.Sprintf("%.2f%%", actual),
+			Required: fmt.Sprintf(">= %.2f%%", limit),
+			Result:   passed(actual >= limit),
+		})
+	}
+	drop := func(name string, actual func(Metric) float64, limit float64) {
+		if limit < 0 {
+			return
+		}
+		result := CheckResult{Name: name, Required: fmt.Sprintf(">= %+.2f", 0-limit)}
+		switch {
+		case base != nil:
+			change := actual(head) - actual(*base)
+			result.Actual = fmt.Sprintf("%+.2f", change)
+			result.Result = passed(change >= -limit)
+		case t.RequireBase:
+			result.Actual = "no base"
+			result.Result = CheckFailed
+		default:
+			result.Actual = "no base"
+			result.Result = CheckSkipped
+		}
+		results = append(results, result)
+	}
+
+	minimum("line coverage", head.LineCoverage, t.MinLine)
+	minimum("conditional coverage", head.ConditionalCoverage, t.MinConditional)
+	drop("line coverage change", func(m Metric) float64 { return m.LineCoverage }, t.MaxLineDrop)
+	drop("conditional coverage change", func(m Metric) float64 { return m.ConditionalCoverage }, t.MaxConditionalDrop)
+	return results
+}
+
+func passed(ok bool) string {
+	if ok {
+		return CheckPassed
+	}
+	return CheckFailed
+}
+
+// PrintChecks prints the outcome of every threshold, returning how many
+// failed
+func PrintChecks(w io.Writer, results []CheckResult) int {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "check\tactual\trequired\tresult")
+	failed := 0
+	for _, r := range results {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", r.Name, r.Actual, r.Required, r.Result)
+		if r.Result == CheckFailed {
+			failed++
+		}
+	}
+	tw.Flush()
+	return failed
+}
+
+// PolicyThresholds converts a server's coverage policy to thresholds
+func PolicyThresholds(p coverage.Policy) Thresholds {
+	threshold := func(value *float64) float64 {
+		if value == nil {
+			return -1
+		}
+		return *value
+	}
+	return Thresholds{
+		MinLine:            threshold(p.MinLine),
+		MinConditional:     threshold(p.MinConditional),
+		MaxLineDrop:        threshold(p.MaxLineDrop),
+		MaxConditionalDrop: threshold(p.MaxConditionalDrop),
+		RequireBase:        p.RequireBase,
+	}
+}
+
+func runCheck(args []string, stdout, stderr io.Writer) error {
+	flags := newCommandFlags("check", stderr)
+	api := addClientFlags(flags)
+	build := addBuildFlags(flags)
+	component := flags.String("component", "", "component rollup to check")
+	var overrides Thresholds
+	flags.Float64Var(&overrides.MinLine, "min-line", -1, "minimum line coverage, in percent (default: the server's policy)")
+	flags.Float64Var(&overrides.MinConditional, "min-conditional", -1, "minimum conditional coverage, in percent (default: the server's policy)")
+	flags.Float64Var(&overrides.MaxLineDrop, "max-line-drop", -1, "largest fall in line coverage from the base, in percentage points (default: the server's policy)")
+	flags.Float64Var(&overrides.MaxConditionalDrop, "max-conditional-drop", -1, "largest fall in conditional coverage from the base, in percentage points (default: the server's policy)")
+	flags.BoolVar(&overrides.RequireBase, "require-base", false, "fail when there is no base coverage to compare with (default: the server's policy)")
+	if err := flags.Parse(args); err != nil {
+		return usageError{err}
+	}
+	if flags.NArg() > 0 {
+		return fmt.Errorf("unexpected argument '%s'", flags.Arg(0))
+	}
+
+	b, err := build.build()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	c := api.client()
+	policy, err := c.Policy(ctx, b.Repository)
+	if err != nil {
+		return fmt.Errorf("querying policy: %v", err)
+	}
+	t := Thresholds{MinLine: -1, MinConditional: -1, MaxLineDrop: -1, MaxConditionalDrop: -1}
+	if policy != nil {
+		t = PolicyThresholds(*policy)
+	}
+	flags.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "min-line":
+			t.MinLine = overrides.MinLine
+		case "min-conditional":
+			t.MinConditional = overrides.MinConditional
+		case "max-line-drop":
+			t.MaxLineDrop = overrides.MaxLineDrop
+		case "max-conditional-drop":
+			t.MaxConditionalDrop = overrides.MaxConditionalDrop
+		case "require-base":
+			t.RequireBase = overrides.RequireBase
+		}
+	})
+
+	headQuery := client.Query{Repository: b.Repository, Sha: b.Sha, Suite: *build.suite, Component: *component}
+	head, err := c.Latest(ctx, headQuery)
+	if err != nil {
+		return fmt.Errorf("querying %s: %v", b.Sha, err)
+	}
+	if head == nil {
+		return fmt.Errorf("no coverage recorded for %s@%s", b.Repository, b.Sha)
+	}
+	base, baseLabel, err := build.findBase(ctx, c, b, *head)
+	if err != nil {
+		return fmt.Errorf("querying base: %v", err)
+	}
+
+	fmt.Fprintf(stdout, "Coverage of %s@%s (%s)\n", head.Repository, head.Sha, head.Branch)
+	PrintComparison(stdout, *head, base, baseLabel)
+	fmt.Fprintln(stdout)
+	results := CheckCoverage(*head, base, t)
+	if len(results) == 0 {
+		fmt.Fprintln(stdout, "No thresholds set; pass --min-line and friends, or configure a policy on the server")
+		return nil
+	}
+	if failed := PrintChecks(stdout, results); failed > 0 {
+		return fmt.Errorf("%d check(s) failed", failed)
+	}
+	return nil
+}
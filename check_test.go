@@ -0,0 +1,140 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package uberalls_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/jinzhu/gorm"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/uber/uberalls"
+)
+
+var _ = Describe("Checking coverage", func() {
+	head := Metric{Sha: "head", LineCoverage: 70, ConditionalCoverage: 40}
+	base := &Metric{Sha: "base", LineCoverage: 75, ConditionalCoverage: 40}
+
+	Describe("CheckCoverage", func() {
+		It("Should apply the minimums", func() {
+			results := CheckCoverage(head, nil, Thresholds{MinLine: 60, MinConditional: 50, MaxLineDrop: -1, MaxConditionalDrop: -1})
+			Expect(results).To(HaveLen(2))
+			Expect(results[0]).To(Equal(CheckResult{"line coverage", "70.00%", ">= 60.00%", CheckPassed}))
+			Expect(results[1]).To(Equal(CheckResult{"conditional coverage", "40.00%", ">= 50.00%", CheckFailed}))
+		})
+
+		It("Should compare with the base", func() {
+			results := CheckCoverage(head, base, Thresholds{MinLine: -1, MinConditional: -1, MaxLineDrop: 2, MaxConditionalDrop: 0})
+			Expect(results).To(HaveLen(2))
+			Expect(results[0]).To(Equal(CheckResult{"line coverage change", "-5.00", ">= -2.00", CheckFailed}))
+			Expect(results[1].Result).To(Equal(CheckPassed))
+		})
+
+		It("Should skip drops without a base unless one is required", func() {
+			t := Thresholds{MinLine: -1, MinConditional: -1, MaxLineDrop: 0, MaxConditionalDrop: -1}
+			Expect(CheckCoverage(head, nil, t)[0].Result).To(Equal(CheckSkipped))
+			t.RequireBase = true
+			Expect(CheckCoverage(head, nil, t)[0].Result).To(Equal(CheckFailed))
+		})
+	})
+
+	Describe("The check command", func() {
+		var (
+			db             *gorm.DB
+			server         *httptest.Server
+			policy         PolicyHandler
+			stdout, stderr bytes.Buffer
+		)
+
+		check := func(args ...string) int {
+			stdout.Reset()
+			stderr.Reset()
+			base := []string{"check", "--server", server.URL, "--repo", "check/repo", "--retries", "0"}
+			return Run(append(base, args...), &stdout, &stderr)
+		}
+
+		BeforeEach(func() {
+			config := &Config{DBType: "sqlite3", DBLocation: "test.sqlite"}
+			db, _ = config.DB()
+			Expect(config.Automigrate()).To(Succeed())
+			mh := NewMetricsHandler(db, nil)
+			policy = NewPolicyHandler(nil)
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", mh)
+			mux.Handle("/policy", policy)
+			server = httptest.NewServer(mux)
+
+			db.Where("repository = ?", "check/repo").Delete(Metric{})
+			Expect(mh.RecordMetric(&Metric{Repository: "check/repo", Sha: "base", Branch: "master", LineCoverage: 75, LinesCovered: 3, LinesTested: 4, Timestamp: 1000})).To(Succeed())
+			Expect(mh.RecordMetric(&Metric{Repository: "check/repo", Sha: "head", Branch: "feature", LineCoverage: 50, LinesCovered: 2, LinesTested: 4, Timestamp: 2000})).To(Succeed())
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		It("Should fail on a drop from the base branch", func() {
+			Expect(check("--sha", "head", "--base-branch", "master", "--max-line-drop", "0")).To(Equal(1))
+			Expect(stdout.String()).To(MatchRegexp(`line coverage\s+50.00%\s+75.00%\s+-25.00`))
+			Expect(stdout.String()).To(MatchRegexp(`line coverage change\s+-25.00\s+>= \+0.00\s+FAIL`))
+			Expect(stderr.String()).To(ContainSubstring("1 check(s) failed"))
+		})
+
+		It("Should pass within the thresholds", func() {
+			Expect(check("--sha", "head", "--base-branch", "master", "--max-line-drop", "30", "--min-line", "50")).To(Equal(0))
+			Expect(stdout.String()).To(MatchRegexp(`line coverage\s+50.00%\s+>= 50.00%\s+pass`))
+		})
+
+		It("Should compare a branch push with the previous commit", func() {
+			Expect(check("--sha", "base", "--branch", "master", "--max-line-drop", "0", "--require-base")).To(Equal(1))
+			Expect(stdout.String()).To(MatchRegexp(`line coverage change\s+no base\s+>= \+0.00\s+FAIL`))
+
+			db.Create(&Metric{Repository: "check/repo", Sha: "next", Branch: "master", LineCoverage: 74, Timestamp: 3000})
+			db.Create(&Metric{Repository: "check/repo", Sha: "next", Branch: "master", Suite: "integration", LineCoverage: 74, Timestamp: 2500})
+			Expect(check("--sha", "next", "--branch", "master", "--max-line-drop", "0")).To(Equal(1))
+			Expect(stdout.String()).To(MatchRegexp(`line coverage change\s+-1.00\s+>= \+0.00\s+FAIL`))
+		})
+
+		It("Should apply the server's policy unless overridden", func() {
+			minLine, maxLineDrop := 60., 10.
+			policy.SetPolicy(PolicyConfig{"*": {MinLine: &minLine, MaxLineDrop: &maxLineDrop}})
+			Expect(check("--sha", "head", "--base-branch", "master")).To(Equal(1))
+			Expect(stdout.String()).To(MatchRegexp(`line coverage\s+50.00%\s+>= 60.00%\s+FAIL`))
+			Expect(stdout.String()).To(MatchRegexp(`line coverage change\s+-25.00\s+>= -10.00\s+FAIL`))
+
+			Expect(check("--sha", "head", "--base-branch", "master", "--min-line", "50", "--max-line-drop", "-1")).To(Equal(0))
+			Expect(stdout.String()).ToNot(ContainSubstring("line coverage change"))
+		})
+
+		It("Should apply no thresholds by default", func() {
+			Expect(check("--sha", "head", "--base-branch", "master")).To(Equal(0))
+			Expect(stdout.String()).To(ContainSubstring("No thresholds set"))
+		})
+
+		It("Should fail when the commit has no coverage", func() {
+			Expect(check("--sha", "missing")).To(Equal(1))
+			Expect(stderr.String()).To(ContainSubstring("no coverage recorded for check/repo@missing"))
+		})
+	})
+})
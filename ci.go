@@ -0,0 +1,147 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package uberalls
+
+import (
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// CI systems detected from their environment variables
+const (
+	CIJenkins   = "jenkins"
+	CIGitHub    = "github-actions"
+	CIGitLab    = "gitlab-ci"
+	CIBuildkite = "buildkite"
+)
+
+// Build describes the commit a build is testing
+type Build struct {
+	// CI is the CI system detected, or empty outside of CI
+	CI         string
+	Repository string
+	Sha        string
+	Branch     string
+	// BaseBranch is the branch a pull request targets
+	BaseBranch string
+	// BaseSha is the commit a pull request is compared to, when the CI
+	// system knows it
+	BaseSha string
+}
+
+// ciVariables names the environment variables a CI system describes builds
+// with; pull request variables are only set for pull request builds
+type ciVariables struct {
+	name, marker                             string
+	repository, repositoryURL, sha           string
+	branch, pullRequestBranch, base, baseSha string
+}
+
+var ciSystems = []ciVariables{
+	{
+		name: CIGitHub, marker: "GITHUB_ACTIONS",
+		repository: "GITHUB_REPOSITORY", sha: "GITHUB_SHA",
+		branch: "GITHUB_REF_NAME", pullRequestBranch: "GITHUB_HEAD_REF", base: "GITHUB_BASE_REF",
+	},
+	{
+		name: CIGitLab, marker: "GITLAB_CI",
+		repository: "CI_PROJECT_PATH", sha: "CI_COMMIT_SHA",
+		branch: "CI_COMMIT_REF_NAME", pullRequestBranch: "CI_MERGE_REQUEST_SOURCE_BRANCH_NAME",
+		base: "CI_MERGE_REQUEST_TARGET_BRANCH_NAME", baseSha: "CI_MERGE_REQUEST_DIFF_BASE_SHA",
+	},
+	{
+		name: CIBuildkite, marker: "BUILDKITE",
+		repositoryURL: "BUILDKITE_REPO", sha: "BUILDKITE_COMMIT",
+		branch: "BUILDKITE_BRANCH", base: "BUILDKITE_PULL_REQUEST_BASE_BRANCH",
+	},
+	{
+		name: CIJenkins, marker: "JENKINS_URL",
+		repositoryURL: "GIT_URL", sha: "GIT_COMMIT",
+		branch: "GIT_BRANCH", pullRequestBranch: "CHANGE_BRANCH", base: "CHANGE_TARGET",
+	},
+}
+
+// DetectBuild describes the build from the environment variables of a CI
+// system, filling in whatever they leave out from the git checkout in dir
+func DetectBuild(getenv func(string) string, dir string) Build {
+	var build Build
+	for _, ci := range ciSystems {
+		if getenv(ci.marker) == "" {
+			continue
+		}
+		build.CI = ci.name
+		lookup := func(name string) string {
+			if name == "" {
+				return ""
+			}
+			return getenv(name)
+		}
+		build.Repository = lookup(ci.repository)
+		if url := lookup(ci.repositoryURL); url != "" {
+			build.Repository = RepositoryFromURL(url)
+		}
+		build.Sha = lookup(ci.sha)
+		build.Branch = lookup(ci.pullRequestBranch)
+		if build.Branch == "" {
+			build.Branch = lookup(ci.branch)
+		}
+		build.BaseBranch = lookup(ci.base)
+		build.BaseSha = lookup(ci.baseSha)
+		break
+	}
+
+	if build.Repository == "" {
+		if url := git(dir, "config", "--get", "remote.origin.url"); url != "" {
+			build.Repository = RepositoryFromURL(url)
+		}
+	}
+	if build.Sha == "" {
+		build.Sha = git(dir, "rev-parse", "HEAD")
+	}
+	if build.Branch == "" {
+		if branch := git(dir, "rev-parse", "--abbrev-ref", "HEAD"); branch != "HEAD" {
+			build.Branch = branch
+		}
+	}
+	return build
+}
+
+// git runs a git command in dir, returning its trimmed output or nothing if
+// it fails
+func git(dir string, args ...string) string {
+	output, err := exec.Command("git", append([]string{"-C", dir}, args...)...).Output()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(output))
+}
+
+var repositoryURL = regexp.MustCompile(`^(?:[a-z+]+://)?(?:[^@/]+@)?[^:/]+(?::\d+)?[:/](.+?)(?:\.git)?/?$`)
+
+// RepositoryFromURL names a repository after the path of its git remote, like
+// "uber/uberalls" for "git@github.com:uber/uberalls.git"
+func RepositoryFromURL(url string) string {
+	if match := repositoryURL.FindStringSubmatch(strings.TrimSpace(url)); match != nil {
+		return strings.TrimPrefix(match[1], "/")
+	}
+	return url
+}
@@ -0,0 +1,126 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package uberalls_test
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/uber/uberalls"
+)
+
+var _ = Describe("Detecting builds", func() {
+	var dir string
+
+	env := func(vars map[string]string) func(string) string {
+		return func(name string) string { return vars[name] }
+	}
+
+	BeforeEach(func() {
+		dir, _ = ioutil.TempDir("", "uberalls-ci")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("Should name repositories after their remote", func() {
+		Expect(RepositoryFromURL("git@github.com:uber/uberalls.git")).To(Equal("uber/uberalls"))
+		Expect(RepositoryFromURL("https://github.com/uber/uberalls.git")).To(Equal("uber/uberalls"))
+		Expect(RepositoryFromURL("ssh://git@gitlab.example.com:2222/group/sub/project")).To(Equal("group/sub/project"))
+	})
+
+	It("Should detect GitHub Actions pull requests", func() {
+		build := DetectBuild(env(map[string]string{
+			"GITHUB_ACTIONS":    "true",
+			"GITHUB_REPOSITORY": "uber/uberalls",
+			"GITHUB_SHA":        "deadbeef",
+			"GITHUB_REF_NAME":   "12/merge",
+			"GITHUB_HEAD_REF":   "feature",
+			"GITHUB_BASE_REF":   "master",
+		}), dir)
+		Expect(build).To(Equal(Build{
+			CI:         CIGitHub,
+			Repository: "uber/uberalls",
+			Sha:        "deadbeef",
+			Branch:     "feature",
+			BaseBranch: "master",
+		}))
+	})
+
+	It("Should detect GitLab CI merge requests", func() {
+		build := DetectBuild(env(map[string]string{
+			"GITLAB_CI":                           "true",
+			"CI_PROJECT_PATH":                     "group/project",
+			"CI_COMMIT_SHA":                       "deadbeef",
+			"CI_COMMIT_REF_NAME":                  "feature",
+			"CI_MERGE_REQUEST_TARGET_BRANCH_NAME": "main",
+			"CI_MERGE_REQUEST_DIFF_BASE_SHA":      "cafebabe",
+		}), dir)
+		Expect(build.CI).To(Equal(CIGitLab))
+		Expect(build.Branch).To(Equal("feature"))
+		Expect(build.BaseSha).To(Equal("cafebabe"))
+	})
+
+	It("Should detect Jenkins and Buildkite from their remotes", func() {
+		build := DetectBuild(env(map[string]string{
+			"JENKINS_URL": "https://ci.example.com/",
+			"GIT_URL":     "git@github.com:uber/uberalls.git",
+			"GIT_COMMIT":  "deadbeef",
+			"GIT_BRANCH":  "origin/master",
+		}), dir)
+		Expect(build.CI).To(Equal(CIJenkins))
+		Expect(build.Repository).To(Equal("uber/uberalls"))
+		Expect(build.Branch).To(Equal("origin/master"))
+
+		build = DetectBuild(env(map[string]string{
+			"BUILDKITE":        "true",
+			"BUILDKITE_REPO":   "https://github.com/uber/uberalls.git",
+			"BUILDKITE_COMMIT": "deadbeef",
+			"BUILDKITE_BRANCH": "master",
+		}), dir)
+		Expect(build.CI).To(Equal(CIBuildkite))
+		Expect(build.Repository).To(Equal("uber/uberalls"))
+	})
+
+	It("Should fall back to git", func() {
+		if _, err := exec.LookPath("git"); err != nil {
+			Skip("git is not installed")
+		}
+		for _, args := range [][]string{
+			{"init", "-q"},
+			{"checkout", "-q", "-b", "main"},
+			{"remote", "add", "origin", "git@github.com:uber/example.git"},
+			{"-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "-q", "--allow-empty", "-m", "initial"},
+		} {
+			Expect(exec.Command("git", append([]string{"-C", dir}, args...)...).Run()).To(Succeed())
+		}
+
+		build := DetectBuild(env(nil), dir)
+		Expect(build.CI).To(BeEmpty())
+		Expect(build.Repository).To(Equal("uber/example"))
+		Expect(build.Sha).To(HaveLen(40))
+		Expect(build.Branch).To(Equal("main"))
+	})
+})
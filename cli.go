@@ -0,0 +1,266 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package uberalls
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/uber/uberalls/coverage"
+)
+
+// command is a subcommand of the uberalls binary
+type command struct {
+	name    string
+	summary string
+	run     func(args []string, stdout, stderr io.Writer) error
+}
+
+var commands = []command{
+	{"serve", "serve the API and dashboard (the default)", runServe},
+	{"migrate", "create or update the database tables", runMigrate},
+	{"query", "print the metric a /metrics query selects", runQuery},
+	{"export", "write metrics as newline-delimited JSON", runExport},
+	{"import", "record metrics written by export", runImport},
+	{"config", "print the effective configuration (config print)", runConfig},
+	{"upload", "upload coverage reports from a CI build", runUpload},
+	{"check", "fail when a commit's coverage misses its thresholds", runCheck},
+}
+
+// usageError reports command-line flags the flag package already complained
+// about
+type usageError struct {
+	error
+}
+
+// Run runs the subcommand named by the first of args, or serve without one,
+// returning the process's exit status
+func Run(args []string, stdout, stderr io.Writer) int {
+	name := "serve"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	if name == "help" {
+		printUsage(stdout)
+		return 0
+	}
+
+	for _, c := range commands {
+		if c.name != name {
+			continue
+		}
+		err := c.run(args, stdout, stderr)
+		switch err.(type) {
+		case nil:
+			return 0
+		case usageError:
+			if err.(usageError).error == flag.ErrHelp {
+				return 0
+			}
+			return 2
+		}
+		fmt.Fprintf(stderr, "uberalls %s: %v\n", name, err)
+		return 1
+	}
+
+	fmt.Fprintf(stderr, "uberalls: unknown command '%s'\n", name)
+	printUsage(stderr)
+	return 2
+}
+
+func printUsage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: uberalls <command> [flags]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands:")
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %-8s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Commands using the database accept --config and the configuration flags;")
+	fmt.Fprintln(w, "run 'uberalls <command> -h' to list a command's flags.")
+}
+
+// newCommandFlags creates the flag set of a subcommand, printing problems with
+// its flags to stderr
+func newCommandFlags(name string, stderr io.Writer) *flag.FlagSet {
+	flags := flag.NewFlagSet("uberalls "+name, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	return flags
+}
+
+// loadCommandConfig parses the flags of a subcommand, which also accepts
+// every configuration flag, and loads the validated configuration
+func loadCommandConfig(flags *flag.FlagSet, args []string) (*Config, Provenance, error) {
+	paths, flagValues, err := parseFlags(flags, args)
+	if err != nil {
+		return nil, nil, usageError{err}
+	}
+	return validated(loadLayers(configFilesOr(paths), flagValues))
+}
+
+func runMigrate(args []string, stdout, stderr io.Writer) error {
+	config, _, err := loadCommandConfig(newCommandFlags("migrate", stderr), args)
+	if err != nil {
+		return err
+	}
+	defer config.Close()
+
+	if err := config.Automigrate(); err != nil {
+		return err
+	}
+	fmt.Fprintln(stdout, "Database is up to date")
+	return nil
+}
+
+func runQuery(args []string, stdout, stderr io.Writer) error {
+	flags := newCommandFlags("query", stderr)
+	params := map[string]string{}
+	for _, param := range []struct{ flag, name, usage string }{
+		{"repo", "repository", "repository to query (required)"},
+		{"sha", "sha", "commit to query"},
+		{"branch", "branch", "branch whose latest commit to query, without --sha (default " + defaultBranch + ")"},
+		{"suite", "suite", "test suite, instead of the combination of every suite"},
+		{"component", "component", "component rollup to query"},
+		{"until", "until", "ignore metrics recorded after this Unix timestamp"},
+	} {
+		flags.Var(rawFlag{values: params, key: param.name}, param.flag, param.usage)
+	}
+	config, _, err := loadCommandConfig(flags, args)
+	if err != nil {
+		return err
+	}
+	defer config.Close()
+
+	form := url.Values{}
+	for name, value := range params {
+		form.Set(name, value)
+	}
+
+	if form.Get("repository") == "" {
+		return errors.New("--repo is required")
+	}
+	if until := form.Get("until"); until != "" {
+		if _, err := strconv.ParseInt(until, 10, 64); err != nil {
+			return fmt.Errorf("--until: '%s' is not a Unix timestamp", until)
+		}
+	}
+
+	db, err := config.DB()
+	if err != nil {
+		return err
+	}
+	m := NewMetricsHandler(db, config.Components).FindMetric(coverage.ExtractMetricQuery(form), form)
+	if m == nil {
+		return errors.New("no metric found")
+	}
+
+	encoded, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(stdout, string(encoded))
+	return nil
+}
+
+func runExport(args []string, stdout, stderr io.Writer) error {
+	flags := newCommandFlags("export", stderr)
+	repository := flags.String("repo", "", "only export metrics of this repository")
+	output := flags.String("output", "-", "file to write, or - for standard output")
+	config, _, err := loadCommandConfig(flags, args)
+	if err != nil {
+		return err
+	}
+	defer config.Close()
+
+	db, err := config.DB()
+	if err != nil {
+		return err
+	}
+
+	w := stdout
+	if *output != "-" {
+		f, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+
+	count, err := ExportMetrics(db, w, *repository)
+	if err != nil {
+		return err
+	}
+	logger.Info("Exported metrics", "count", count)
+	return nil
+}
+
+func runImport(args []string, stdout, stderr io.Writer) error {
+	flags := newCommandFlags("import", stderr)
+	input := flags.String("input", "-", "file written by export, or - for standard input")
+	config, _, err := loadCommandConfig(flags, args)
+	if err != nil {
+		return err
+	}
+	defer config.Close()
+
+	var r io.Reader = os.Stdin
+	if *input != "-" {
+		f, err := os.Open(*input)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	if err := config.Automigrate(); err != nil {
+		return err
+	}
+	db, err := config.DB()
+	if err != nil {
+		return err
+	}
+
+	imported, skipped, err := ImportMetrics(NewMetricsHandler(db, config.Components), r)
+	fmt.Fprintf(stdout, "Imported %d metric(s), skipped %d already recorded\n", imported, skipped)
+	return err
+}
+
+func runConfig(args []string, stdout, stderr io.Writer) error {
+	if len(args) == 0 || args[0] != "print" {
+		return errors.New("usage: uberalls config print [flags]")
+	}
+
+	config, provenance, err := loadCommandConfig(newCommandFlags("config print", stderr), args[1:])
+	if config == nil {
+		return err
+	}
+	PrintConfig(stdout, config, provenance)
+	return err
+}
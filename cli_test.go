@@ -0,0 +1,124 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package uberalls_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/uber/uberalls"
+)
+
+var _ = Describe("Command line", func() {
+	var (
+		stdout, stderr bytes.Buffer
+		database       string
+		dbFlags        []string
+	)
+
+	run := func(args ...string) int {
+		stdout.Reset()
+		stderr.Reset()
+		return Run(args, &stdout, &stderr)
+	}
+
+	BeforeEach(func() {
+		f, _ := ioutil.TempFile("", "uberalls-cli")
+		f.Close()
+		database = f.Name()
+		dbFlags = []string{"--db-type", "sqlite3", "--db-location", database}
+	})
+
+	AfterEach(func() {
+		os.Remove(database)
+	})
+
+	It("Should list commands", func() {
+		Expect(run("help")).To(Equal(0))
+		Expect(stdout.String()).To(ContainSubstring("migrate"))
+		Expect(stdout.String()).To(ContainSubstring("export"))
+
+		Expect(run("frobnicate")).To(Equal(2))
+		Expect(stderr.String()).To(ContainSubstring("unknown command 'frobnicate'"))
+	})
+
+	It("Should reject unknown flags", func() {
+		Expect(run(append([]string{"query", "--bogus"}, dbFlags...)...)).To(Equal(2))
+		Expect(stderr.String()).To(ContainSubstring("bogus"))
+	})
+
+	It("Should report configuration problems", func() {
+		Expect(run("migrate", "--db-type", "oracle")).To(Equal(1))
+		Expect(stderr.String()).To(ContainSubstring("dbType"))
+	})
+
+	It("Should migrate the database", func() {
+		Expect(run(append([]string{"migrate"}, dbFlags...)...)).To(Equal(0))
+		Expect(stdout.String()).To(ContainSubstring("up to date"))
+	})
+
+	Context("With metrics", func() {
+		BeforeEach(func() {
+			config := &Config{DBType: "sqlite3", DBLocation: database}
+			Expect(config.Automigrate()).To(Succeed())
+			db, _ := config.DB()
+			mh := NewMetricsHandler(db, nil)
+			Expect(mh.RecordMetric(&Metric{Repository: "cli/repo", Sha: "deadbeef", Branch: "master", LineCoverage: 42, Timestamp: 1000})).To(Succeed())
+			config.Close()
+		})
+
+		It("Should query metrics", func() {
+			Expect(run(append([]string{"query", "--repo", "cli/repo", "--sha", "deadbeef"}, dbFlags...)...)).To(Equal(0))
+			var m Metric
+			Expect(json.Unmarshal(stdout.Bytes(), &m)).To(Succeed())
+			Expect(m.LineCoverage).To(Equal(42.0))
+
+			Expect(run(append([]string{"query", "--repo", "cli/repo", "--branch", "master"}, dbFlags...)...)).To(Equal(0))
+			Expect(run(append([]string{"query", "--repo", "cli/repo", "--sha", "deadbeef", "--until", "999"}, dbFlags...)...)).To(Equal(1))
+			Expect(stderr.String()).To(ContainSubstring("no metric found"))
+		})
+
+		It("Should require a repository to query", func() {
+			Expect(run(append([]string{"query"}, dbFlags...)...)).To(Equal(1))
+			Expect(stderr.String()).To(ContainSubstring("--repo is required"))
+		})
+
+		It("Should export and import metrics", func() {
+			exported, _ := ioutil.TempFile("", "uberalls-export")
+			exported.Close()
+			defer os.Remove(exported.Name())
+			Expect(run(append([]string{"export", "--output", exported.Name()}, dbFlags...)...)).To(Equal(0))
+			Expect(ioutil.ReadFile(exported.Name())).To(ContainSubstring(`"sha":"deadbeef"`))
+
+			Expect(run(append([]string{"import", "--input", exported.Name()}, dbFlags...)...)).To(Equal(0))
+			Expect(stdout.String()).To(ContainSubstring("Imported 0 metric(s), skipped 1"))
+		})
+
+		It("Should print the configuration", func() {
+			Expect(run(append([]string{"config", "print"}, dbFlags...)...)).To(Equal(0))
+			Expect(stdout.String()).To(ContainSubstring("flag:--db-location"))
+		})
+	})
+})
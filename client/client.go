@@ -0,0 +1,304 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package client calls the API of an uberalls server.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/uber/uberalls/coverage"
+)
+
+// DefaultURL is where clients find the server unless told otherwise
+const DefaultURL = "http://localhost:14740"
+
+// DefaultHistory is how many commits History returns unless told otherwise
+const DefaultHistory = 50
+
+// Client calls the API of an uberalls server
+type Client struct {
+	// URL is the server's base URL, like "http://localhost:14740"
+	URL string
+	// Token is sent as a bearer token when set
+	Token string
+	// Retries is how many times requests failing with a network error, or a
+	// 429, 502, 503 or 504, are retried. Uploads, which are not idempotent,
+	// are only retried when they never reached the server or were answered
+	// with a 429.
+	Retries int
+	// Backoff is how long to wait before the first retry, doubling for every
+	// retry, unless the server says how long with Retry-After
+	Backoff    time.Duration
+	HTTPClient *http.Client
+	// OnRetry, when set, is called before waiting to retry a failed request
+	OnRetry func(err error, wait time.Duration)
+}
+
+// Error is an error response from the server
+type Error struct {
+	StatusCode int
+	Message    string
+}
+
+func (e Error) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// Temporary reports whether the request may succeed if sent again
+func (e Error) Temporary() bool {
+	switch e.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// IsNotFound reports whether err is a 404 response
+func IsNotFound(err error) bool {
+	e, ok := err.(Error)
+	return ok && e.StatusCode == http.StatusNotFound
+}
+
+// Query selects metrics like the parameters of /metrics queries: a commit,
+// or else the latest commit of a branch, "origin/master" by default
+type Query struct {
+	Repository string
+	Sha        string
+	Branch     string
+	// Suite selects a test suite's uploads; without one, the latest upload
+	// of every suite is combined
+	Suite     string
+	Component string
+	// Until, when set, ignores uploads after this Unix timestamp
+	Until int64
+}
+
+// Values encodes a query as URL parameters
+func (q Query) Values() url.Values {
+	values := url.Values{"repository": {q.Repository}}
+	for key, value := range map[string]string{
+		"sha":       q.Sha,
+		"branch":    q.Branch,
+		"suite":     q.Suite,
+		"component": q.Component,
+	} {
+		if value != "" {
+			values.Set(key, value)
+		}
+	}
+	if q.Until != 0 {
+		values.Set("until", strconv.FormatInt(q.Until, 10))
+	}
+	return values
+}
+
+// New creates a Client for the server at a URL
+func New(serverURL, token string) *Client {
+	return &Client{
+		URL:        strings.TrimSuffix(serverURL, "/"),
+		Token:      token,
+		Retries:    3,
+		Backoff:    time.Second,
+		HTTPClient: &http.Client{Timeout: time.Minute},
+	}
+}
+
+// Record uploads a metric, returning it as the server recorded it
+func (c *Client) Record(ctx context.Context, m coverage.Metric) (*coverage.Metric, error) {
+	body, err := json.Marshal(m)
+	if err != nil {
+		return nil, err
+	}
+	recorded := new(coverage.Metric)
+	if err := c.do(ctx, "POST", "/metrics", nil, body, recorded); err != nil {
+		return nil, err
+	}
+	return recorded, nil
+}
+
+// Latest returns the metric a query selects, or nil if there is none
+func (c *Client) Latest(ctx context.Context, query Query) (*coverage.Metric, error) {
+	m := new(coverage.Metric)
+	err := c.do(ctx, "GET", "/metrics", query.Values(), nil, m)
+	if IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Compare compares the coverage of the head commit with that of the base
+// commit, in the repository, suite and component of query
+func (c *Client) Compare(ctx context.Context, query Query, base, head string) (*coverage.Comparison, error) {
+	query.Branch, query.Until = "", 0
+
+	metrics := make([]coverage.Metric, 0, 2)
+	for _, sha := range []string{base, head} {
+		query.Sha = sha
+		var m coverage.Metric
+		if err := c.do(ctx, "GET", "/metrics", query.Values(), nil, &m); err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, m)
+	}
+
+	comparison := coverage.Compare(metrics[0], metrics[1])
+	return &comparison, nil
+}
+
+// History returns up to limit of the commits a query selects, newest first,
+// or DefaultHistory of them if limit is 0. Each commit appears once, with its
+// suites combined unless the query names one.
+func (c *Client) History(ctx context.Context, query Query, limit int) ([]coverage.Metric, error) {
+	if limit <= 0 {
+		limit = DefaultHistory
+	}
+
+	values := query.Values()
+	values.Set("limit", strconv.Itoa(limit))
+	var metrics []coverage.Metric
+	if err := c.do(ctx, "GET", "/metrics/history", values, nil, &metrics); err != nil {
+		return nil, err
+	}
+	return metrics, nil
+}
+
+// Policy returns the coverage policy the server sets for a repository, or
+// nil if the server has none
+func (c *Client) Policy(ctx context.Context, repository string) (*coverage.Policy, error) {
+	policy := new(coverage.Policy)
+	err := c.do(ctx, "GET", "/policy", url.Values{"repository": {repository}}, nil, policy)
+	if IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return policy, nil
+}
+
+// do sends a request, retrying it when the failure may be temporary, and
+// decodes a successful response into out
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body []byte, out interface{}) error {
+	target := c.URL + path
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	wait := c.Backoff
+	for attempt := 0; ; attempt++ {
+		request, err := http.NewRequest(method, target, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		request = request.WithContext(ctx)
+		if body != nil {
+			request.Header.Set("Content-Type", "application/json")
+		}
+		if c.Token != "" {
+			request.Header.Set("Authorization", "Bearer "+c.Token)
+		}
+
+		response, err := c.HTTPClient.Do(request)
+		var content []byte
+		if err == nil {
+			content, err = ioutil.ReadAll(response.Body)
+			response.Body.Close()
+		}
+		if err == nil && response.StatusCode < 300 {
+			return json.Unmarshal(content, out)
+		}
+		if err == nil {
+			err = decodeError(response.StatusCode, content)
+		}
+
+		if attempt >= c.Retries || !retryable(ctx, method, err) {
+			return err
+		}
+		if seconds, parseErr := strconv.Atoi(retryAfter(response)); parseErr == nil {
+			wait = time.Duration(seconds) * time.Second
+		}
+		if c.OnRetry != nil {
+			c.OnRetry(err, wait)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(wait):
+		}
+		wait *= 2
+	}
+}
+
+// decodeError reads an error response, which the server encodes as a
+// coverage.ErrorResponse
+func decodeError(status int, content []byte) Error {
+	var response coverage.ErrorResponse
+	if json.Unmarshal(content, &response) == nil && response.Error != "" {
+		return Error{status, response.Error}
+	}
+	return Error{status, strings.TrimSpace(string(content))}
+}
+
+// retryable reports whether a request that failed with err may succeed if
+// sent again. A POST the server may have acted on is not sent again, lest the
+// metric be recorded twice.
+func retryable(ctx context.Context, method string, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if e, ok := err.(Error); ok {
+		if method != "GET" {
+			return e.StatusCode == http.StatusTooManyRequests
+		}
+		return e.Temporary()
+	}
+	return method == "GET" || !sent(err)
+}
+
+// sent reports whether a request failing with err may have reached the
+// server, which it can't have if no connection was made
+func sent(err error) bool {
+	if e, ok := err.(*url.Error); ok {
+		err = e.Err
+	}
+	e, ok := err.(*net.OpError)
+	return !ok || e.Op != "dial"
+}
+
+func retryAfter(response *http.Response) string {
+	if response == nil {
+		return ""
+	}
+	return response.Header.Get("Retry-After")
+}
@@ -0,0 +1,167 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package client_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	"github.com/uber/uberalls/coverage"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/uber/uberalls/client"
+)
+
+var _ = Describe("Client", func() {
+	var (
+		server   *httptest.Server
+		c        *Client
+		failures int
+		requests int
+		retried  int
+		queries  []url.Values
+	)
+
+	BeforeEach(func() {
+		failures, requests, retried, queries = 0, 0, 0, nil
+		server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			queries = append(queries, r.URL.Query())
+			switch {
+			case failures > 0:
+				failures--
+				w.WriteHeader(http.StatusServiceUnavailable)
+			case r.Header.Get("Authorization") != "Bearer secret":
+				w.WriteHeader(http.StatusUnauthorized)
+			case r.URL.Query().Get("sha") == "unknown":
+				w.WriteHeader(http.StatusNotFound)
+				json.NewEncoder(w).Encode(coverage.ErrorResponse{Error: "no rows found: -"})
+			case r.Method == "POST":
+				var m coverage.Metric
+				json.NewDecoder(r.Body).Decode(&m)
+				if m.Sha == "" {
+					w.WriteHeader(http.StatusBadRequest)
+					json.NewEncoder(w).Encode(coverage.ErrorResponse{Error: "error recording metric: missing required field"})
+					return
+				}
+				m.ID = 1
+				json.NewEncoder(w).Encode(m)
+			default:
+				json.NewEncoder(w).Encode(coverage.Metric{ID: 1, Repository: r.URL.Query().Get("repository")})
+			}
+		}))
+		c = New(server.URL+"/", "secret")
+		c.Backoff = time.Millisecond
+		c.OnRetry = func(err error, wait time.Duration) { retried++ }
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("Should record metrics", func() {
+		recorded, err := c.Record(context.Background(), coverage.Metric{Repository: "client/repo", Sha: "deadbeef"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(recorded.ID).To(BeEquivalentTo(1))
+	})
+
+	It("Should send queries as /metrics parameters", func() {
+		_, err := c.Latest(context.Background(), Query{Repository: "client/repo", Branch: "master", Suite: "unit", Until: 1000})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(queries[0]).To(Equal(url.Values{
+			"repository": {"client/repo"},
+			"branch":     {"master"},
+			"suite":      {"unit"},
+			"until":      {"1000"},
+		}))
+	})
+
+	It("Should return nothing for unknown commits", func() {
+		latest, err := c.Latest(context.Background(), Query{Repository: "client/repo", Sha: "unknown"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(latest).To(BeNil())
+	})
+
+	It("Should retry unavailable servers", func() {
+		failures = 2
+		_, err := c.Latest(context.Background(), Query{Repository: "client/repo"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(requests).To(Equal(3))
+		Expect(retried).To(Equal(2))
+
+		failures = 10
+		_, err = c.Latest(context.Background(), Query{Repository: "client/repo"})
+		Expect(err).To(MatchError(ContainSubstring("503")))
+		Expect(requests).To(Equal(7))
+	})
+
+	It("Should not upload twice when the server may have recorded the metric", func() {
+		failures = 2
+		_, err := c.Record(context.Background(), coverage.Metric{Repository: "client/repo", Sha: "cafe"})
+		Expect(err).To(MatchError(ContainSubstring("503")))
+		Expect(requests).To(Equal(1))
+		Expect(retried).To(BeZero())
+	})
+
+	It("Should retry uploads the server turned away", func() {
+		limited := true
+		server.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			requests++
+			if limited {
+				limited = false
+				w.WriteHeader(http.StatusTooManyRequests)
+				return
+			}
+			json.NewEncoder(w).Encode(coverage.Metric{ID: 1})
+		})
+		_, err := c.Record(context.Background(), coverage.Metric{Repository: "client/repo", Sha: "cafe"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(requests).To(Equal(2))
+	})
+
+	It("Should retry uploads to unreachable servers", func() {
+		server.Close()
+		_, err := c.Record(context.Background(), coverage.Metric{Repository: "client/repo", Sha: "cafe"})
+		Expect(err).To(HaveOccurred())
+		Expect(retried).To(Equal(c.Retries))
+	})
+
+	It("Should decode server errors without retrying", func() {
+		_, err := c.Record(context.Background(), coverage.Metric{Repository: "client/repo"})
+		Expect(err).To(Equal(Error{http.StatusBadRequest, "error recording metric: missing required field"}))
+		Expect(err.(Error).Temporary()).To(BeFalse())
+		Expect(requests).To(Equal(1))
+	})
+
+	It("Should stop retrying when the context is done", func() {
+		ctx, cancel := context.WithCancel(context.Background())
+		failures = 10
+		c.Backoff = time.Hour
+		c.OnRetry = func(err error, wait time.Duration) { cancel() }
+		_, err := c.Latest(ctx, Query{Repository: "client/repo"})
+		Expect(err).To(Equal(context.Canceled))
+		Expect(requests).To(Equal(1))
+	})
+})
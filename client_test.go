@@ -0,0 +1,137 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package uberalls_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/jinzhu/gorm"
+	"github.com/uber/uberalls/client"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/uber/uberalls"
+)
+
+var _ = Describe("Comparing and listing metrics with the client", func() {
+	var (
+		db     *gorm.DB
+		server *httptest.Server
+		c      *client.Client
+		ctx    = context.Background()
+		query  = client.Query{Repository: "compare/repo", Branch: "master"}
+	)
+
+	BeforeEach(func() {
+		config := &Config{DBType: "sqlite3", DBLocation: "test.sqlite"}
+		db, _ = config.DB()
+		Expect(config.Automigrate()).To(Succeed())
+		db.Where("repository = ?", "compare/repo").Delete(Metric{})
+
+		metrics := NewMetricsHandler(db, nil)
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", metrics)
+		mux.Handle("/metrics/history", NewHistoryHandler(metrics))
+		server = httptest.NewServer(mux)
+		c = client.New(server.URL, "")
+
+		for i, sha := range []string{"first", "second", "third"} {
+			_, err := c.Record(ctx, Metric{
+				Repository:   "compare/repo",
+				Sha:          sha,
+				Branch:       "master",
+				LineCoverage: float64(50 + 10*i),
+				Timestamp:    int64(1000 + i),
+			})
+			Expect(err).ToNot(HaveOccurred())
+		}
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("Should query the latest metric", func() {
+		latest, err := c.Latest(ctx, query)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(latest.Sha).To(Equal("third"))
+
+		query.Until = 1001
+		latest, err = c.Latest(ctx, query)
+		query.Until = 0
+		Expect(err).ToNot(HaveOccurred())
+		Expect(latest.Sha).To(Equal("second"))
+	})
+
+	It("Should compare two commits", func() {
+		comparison, err := c.Compare(ctx, query, "first", "third")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(comparison.Base.Sha).To(Equal("first"))
+		Expect(comparison.Head.Sha).To(Equal("third"))
+		Expect(comparison.Changes["lineCoverage"]).To(Equal(20.))
+	})
+
+	It("Should not compare unknown commits", func() {
+		_, err := c.Compare(ctx, query, "first", "unknown")
+		Expect(client.IsNotFound(err)).To(BeTrue())
+		Expect(err.Error()).To(ContainSubstring("no rows found"))
+	})
+
+	It("Should list a branch's history newest first", func() {
+		history, err := c.History(ctx, query, 2)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(history).To(HaveLen(2))
+		Expect(history[0].Sha).To(Equal("third"))
+		Expect(history[1].Sha).To(Equal("second"))
+
+		query.Until = 1000
+		history, err = c.History(ctx, query, 0)
+		query.Until = 0
+		Expect(err).ToNot(HaveOccurred())
+		Expect(history).To(HaveLen(1))
+		Expect(history[0].Sha).To(Equal("first"))
+	})
+
+	It("Should list each commit once, combining its suites", func() {
+		_, err := c.Record(ctx, Metric{
+			Repository:   "compare/repo",
+			Sha:          "third",
+			Branch:       "master",
+			Suite:        "e2e",
+			LineCoverage: 90,
+			Timestamp:    1003,
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		history, err := c.History(ctx, query, 0)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(history).To(HaveLen(3))
+		Expect(history[0].Sha).To(Equal("third"))
+		Expect(history[0].LineCoverage).To(Equal(90.))
+		Expect(history[0].Suites).To(ConsistOf("", "e2e"))
+	})
+
+	It("Should reject a limit above the page size", func() {
+		_, err := c.History(ctx, query, 100000)
+		Expect(err).To(HaveOccurred())
+	})
+})
@@ -0,0 +1,157 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package uberalls
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// ComponentConfig maps a repository to its components, each described by a
+// list of path globs. Globs use '*' and '?' within a path segment, and '**'
+// across segments, so a bare '*' never matches a path with a '/'.
+type ComponentConfig map[string]map[string][]string
+
+// Components returns the sorted component names configured for a repository
+func (cc ComponentConfig) Components(repository string) []string {
+	names := make([]string, 0, len(cc[repository]))
+	for name := range cc[repository] {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Match reports whether a file belongs to a repository's component
+func (cc ComponentConfig) Match(repository, component, path string) bool {
+	return compileGlobs(cc[repository][component]).match(path)
+}
+
+// Rollups computes a metric for every component of m's repository from its
+// line-level data, with the files it matched. Components without any matching
+// file are left out.
+func (cc ComponentConfig) Rollups(m Metric) []Metric {
+	var rollups []Metric
+	for _, name := range cc.Components(m.Repository) {
+		globs := compileGlobs(cc[m.Repository][name])
+		var files []SourceFile
+		for _, f := range m.SourceFiles {
+			if globs.match(f.Name) {
+				files = append(files, f)
+			}
+		}
+		if len(files) == 0 {
+			continue
+		}
+
+		rollup := Metric{
+			Repository:  m.Repository,
+			Sha:         m.Sha,
+			Branch:      m.Branch,
+			Suite:       m.Suite,
+			Component:   name,
+			Timestamp:   m.Timestamp,
+			SourceFiles: files,
+		}
+		rollup.ApplySourceFiles()
+		rollups = append(rollups, rollup)
+	}
+	return rollups
+}
+
+// globs are compiled path globs, matching a path if any of them does
+type globs []*regexp.Regexp
+
+// compileGlobs compiles patterns, leaving out those CheckGlob rejects, which
+// Validate reports when the configuration is loaded
+func compileGlobs(patterns []string) globs {
+	compiled := make(globs, 0, len(patterns))
+	for _, pattern := range patterns {
+		if expr, err := globToRegexp(pattern); err == nil {
+			compiled = append(compiled, expr)
+		}
+	}
+	return compiled
+}
+
+func (g globs) match(path string) bool {
+	path = strings.TrimPrefix(path, "./")
+	for _, expr := range g {
+		if expr.MatchString(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchGlob reports whether a slash-separated path matches a glob. A glob
+// CheckGlob rejects matches nothing.
+func MatchGlob(glob, path string) bool {
+	expr, err := globToRegexp(glob)
+	return err == nil && expr.MatchString(strings.TrimPrefix(path, "./"))
+}
+
+// CheckGlob reports why a glob can't match any path
+func CheckGlob(glob string) error {
+	_, err := globToRegexp(glob)
+	return err
+}
+
+func globToRegexp(glob string) (*regexp.Regexp, error) {
+	switch {
+	case glob == "":
+		return nil, errors.New("glob is empty")
+	case !utf8.ValidString(glob):
+		return nil, fmt.Errorf("glob '%s' is not valid UTF-8", glob)
+	case strings.HasPrefix(glob, "/"):
+		return nil, fmt.Errorf("glob '%s' can't match relative paths", glob)
+	}
+
+	var expr bytes.Buffer
+	expr.WriteString("^")
+	for i := 0; i < len(glob); {
+		switch {
+		case strings.HasPrefix(glob[i:], "**/"):
+			expr.WriteString("(.*/)?")
+			i += 3
+		case strings.HasPrefix(glob[i:], "**"):
+			expr.WriteString(".*")
+			i += 2
+		case glob[i] == '*':
+			expr.WriteString("[^/]*")
+			i++
+		case glob[i] == '?':
+			expr.WriteString("[^/]")
+			i++
+		default:
+			_, size := utf8.DecodeRuneInString(glob[i:])
+			expr.WriteString(regexp.QuoteMeta(glob[i : i+size]))
+			i += size
+		}
+	}
+	expr.WriteString("$")
+	return regexp.Compile(expr.String())
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package uberalls_test
+
+import (
+	"fmt"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/uber/uberalls"
+)
+
+var _ = Describe("Components", func() {
+	globs := []struct {
+		glob, path string
+		matches    bool
+	}{
+		{"main.go", "main.go", true},
+		{"api/*.go", "api/server.go", true},
+		{"api/*.go", "api/v1/server.go", false},
+		{"api/**", "api/v1/server.go", true},
+		{"**/*_test.go", "api/v1/server_test.go", true},
+		{"**/*.go", "main.go", true},
+		{"api/**", "web/api/index.js", false},
+		{"api/**", "./api/server.go", true},
+		{"web/?.js", "web/a.js", true},
+		{"docs/é?.md", "docs/éa.md", true},
+		{"*", "uber/uberalls", false},
+		{"**", "uber/uberalls", true},
+		{"/api/**", "api/server.go", false},
+	}
+
+	for _, g := range globs {
+		g := g
+		It(fmt.Sprintf("Should match %q against %q", g.glob, g.path), func() {
+			Expect(MatchGlob(g.glob, g.path)).To(Equal(g.matches))
+		})
+	}
+
+	It("Should reject globs that can't match", func() {
+		Expect(CheckGlob("api/**")).To(Succeed())
+		Expect(CheckGlob("")).ToNot(Succeed())
+		Expect(CheckGlob("/api/**")).ToNot(Succeed())
+		Expect(CheckGlob("api/\xff")).ToNot(Succeed())
+	})
+
+	It("Should list a repository's components in order", func() {
+		Expect(testComponents.Components("monorepo")).To(Equal([]string{"api", "web"}))
+		Expect(testComponents.Components("other")).To(BeEmpty())
+	})
+
+	Context("Computing rollups", func() {
+		rollups := testComponents.Rollups(Metric{
+			Repository: "monorepo",
+			Sha:        "abc",
+			Suite:      "unit",
+			Timestamp:  1480636700,
+			SourceFiles: []SourceFile{
+				{Name: "api/server.go", Coverage: hits(1, 0)},
+				{Name: "api/client.go", Coverage: hits(1, -1)},
+			},
+		})
+
+		It("Should skip components without files", func() {
+			Expect(rollups).To(HaveLen(1))
+		})
+
+		It("Should aggregate the component's files", func() {
+			Expect(rollups[0].Component).To(Equal("api"))
+			Expect(rollups[0].Suite).To(Equal("unit"))
+			Expect(rollups[0].Timestamp).To(Equal(int64(1480636700)))
+			Expect(rollups[0].LinesCovered).To(Equal(int64(2)))
+			Expect(rollups[0].LinesTested).To(Equal(int64(3)))
+		})
+	})
+})
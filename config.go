@@ -18,13 +18,11 @@
 // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
 // THE SOFTWARE.
 
-package main
+package uberalls
 
 import (
-	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"os"
 
 	"github.com/jinzhu/gorm"
@@ -44,10 +42,31 @@ var configLocations = [...]string{
 // Config holds application configuration
 type Config struct {
 	DBType        string
-	DBLocation    string
+	DBLocation    string `config:"dsn"`
 	ListenPort    int
 	ListenAddress string
-	db            *gorm.DB
+	// Server configures timeouts of the HTTP server
+	Server ServerConfig
+	// Log configures the format and level of logs
+	Log LogConfig
+	// TLS serves HTTPS, and mutual TLS when a client CA is configured
+	TLS        TLSConfig
+	Components ComponentConfig
+	// AuthRequired makes the metrics endpoints require an API token
+	AuthRequired bool
+	// AnonymousRead lets unauthenticated requests read metrics when
+	// AuthRequired is set
+	AnonymousRead bool
+	// AdminToken is the secret used to manage API tokens
+	AdminToken string `config:"secret"`
+	// OIDC accepts OIDC tokens from CI workloads in place of API tokens
+	OIDC OIDCConfig
+	// Limits bounds request body sizes and request rates
+	Limits LimitsConfig
+	// Policy sets the coverage thresholds `uberalls check` applies
+	Policy PolicyConfig
+	db     *gorm.DB
+	closed chan struct{}
 }
 
 // ConnectionString returns a TCP string for the HTTP server to bind to
@@ -63,12 +82,31 @@ func (c *Config) DB() (*gorm.DB, error) {
 			return nil, err
 		}
 		c.db = &newdb
+		c.closed = make(chan struct{})
 	}
 	return c.db, nil
 }
 
-// Automigrate runs migrations automatically
-func (c Config) Automigrate() error {
+// Closed returns a channel closed when the database connection is, or nil if
+// none was opened
+func (c *Config) Closed() <-chan struct{} {
+	return c.closed
+}
+
+// Close closes the database connection, if one was opened
+func (c *Config) Close() error {
+	if c.db == nil {
+		return nil
+	}
+	err := c.db.Close()
+	close(c.closed)
+	c.db, c.closed = nil, nil
+	return err
+}
+
+// Automigrate runs migrations automatically, on the connection DB opens and
+// keeps for c
+func (c *Config) Automigrate() error {
 	db, err := c.DB()
 	if err != nil {
 		return err
@@ -88,6 +126,95 @@ func (c Config) Automigrate() error {
 		"branch",
 		"timestamp",
 	)
+	db.Model(model).AddIndex(
+		"idx_metrics_repository_sha_suite_timestamp",
+		"repository",
+		"sha",
+		"suite",
+		"timestamp",
+	)
+	db.Model(model).AddIndex(
+		"idx_metrics_repository_component_branch_timestamp",
+		"repository",
+		"component",
+		"branch",
+		"timestamp",
+	)
+
+	db.AutoMigrate(new(FileCoverage), new(FileLines))
+	db.Model(new(FileCoverage)).AddIndex(
+		"idx_file_coverages_metric_id_path",
+		"metric_id",
+		"path",
+	)
+	db.Model(new(FileLines)).AddIndex(
+		"idx_file_lines_file_coverage_id",
+		"file_coverage_id",
+	)
+
+	db.AutoMigrate(new(Repository), new(Branch))
+	db.Model(new(Repository)).AddUniqueIndex(
+		"idx_repositories_name",
+		"name",
+	)
+	db.Model(new(Branch)).AddUniqueIndex(
+		"idx_branches_repository_name",
+		"repository",
+		"name",
+	)
+	if err := backfillRepositories(db); err != nil {
+		return err
+	}
+
+	db.AutoMigrate(new(Token), new(Grant), new(GroupMember))
+	db.Model(new(Token)).AddUniqueIndex(
+		"idx_tokens_hash",
+		"hash",
+	)
+	db.Model(new(Grant)).AddIndex(
+		"idx_grants_repository",
+		"repository",
+	)
+	db.Model(new(GroupMember)).AddUniqueIndex(
+		"idx_group_members_user_name_group_name",
+		"user_name",
+		"group_name",
+	)
+
+	db.AutoMigrate(new(UploadSession), new(UploadShard))
+	db.Model(new(UploadSession)).AddIndex(
+		"idx_upload_sessions_repository_sha_suite_timestamp",
+		"repository",
+		"sha",
+		"suite",
+		"timestamp",
+	)
+	db.Model(new(UploadSession)).AddIndex(
+		"idx_upload_sessions_status_expires_at",
+		"status",
+		"expires_at",
+	)
+	if db.Dialect().GetName() == "mysql" {
+		// shards used to be stored as text, which MySQL caps at 64KB
+		db.Model(new(UploadShard)).ModifyColumn("report", "longtext not null")
+	}
+	db.Model(new(UploadShard)).AddIndex(
+		"idx_upload_shards_session_id_shard",
+		"session_id",
+		"shard",
+	)
+
+	db.AutoMigrate(new(AuditEntry))
+	db.Model(new(AuditEntry)).AddIndex(
+		"idx_audit_entries_repository_timestamp",
+		"repository",
+		"timestamp",
+	)
+	db.Model(new(AuditEntry)).AddIndex(
+		"idx_audit_entries_actor_timestamp",
+		"actor",
+		"timestamp",
+	)
 	return nil
 }
 
@@ -110,14 +237,14 @@ func LoadConfig(c *Config, configPath string) (*Config, error) {
 		configPath = DefaultConfig
 	}
 
-	log.Print("Loading configuration from '", configPath, "'")
+	logger.Info("Loading configuration", "path", configPath)
 	content, err := ioutil.ReadFile(configPath)
 	if err != nil {
 		return nil, err
 	}
 
-	if err = json.Unmarshal(content, c); err != nil {
-		return nil, err
+	if errs := decodeFile(c, configPath, content, nil); len(errs) > 0 {
+		return nil, errs
 	}
 
 	return c, nil
@@ -134,11 +261,44 @@ func GetLocationsFromEnvironment() []string {
 	return configPaths
 }
 
-// Configure sets up the app
+// Configure sets up the app from config files and the environment
 func Configure() (*Config, error) {
-	log.Println("Configuring...")
-	config := Config{}
+	return ConfigureArgs(nil)
+}
+
+// ConfigureArgs sets up the app from config files, the environment and
+// command-line flags, reporting every problem with them at once
+func ConfigureArgs(args []string) (*Config, error) {
+	logger.Debug("Configuring")
+	config, _, err := LoadValidated(args)
+	if err != nil {
+		return nil, err
+	}
+	return config, nil
+}
 
-	paths := GetLocationsFromEnvironment()
-	return LoadConfigs(&config, paths)
+// LoadValidated loads the configuration layers and validates the result,
+// skipping keys whose values couldn't be loaded. When the only problems are
+// ConfigErrors, the configuration is returned alongside them.
+func LoadValidated(args []string) (*Config, Provenance, error) {
+	return validated(LoadLayers(args))
+}
+
+// validated validates a configuration loaded by LoadLayers
+func validated(config *Config, provenance Provenance, err error) (*Config, Provenance, error) {
+	errs, _ := err.(ConfigErrors)
+	if err != nil && errs == nil {
+		return nil, nil, err
+	}
+
+	failed := map[string]bool{}
+	for _, e := range errs {
+		failed[e.Key] = true
+	}
+	for _, e := range Validate(config, provenance) {
+		if !failed[e.Key] {
+			errs = append(errs, e)
+		}
+	}
+	return config, provenance, errs.orNil()
 }
@@ -18,7 +18,7 @@
 // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
 // THE SOFTWARE.
 
-package main_test
+package uberalls_test
 
 import (
 	"os"
@@ -92,6 +92,16 @@ var _ = Describe("Database connections", func() {
 		Expect(c.ConnectionString()).To(Equal("somehost:1"))
 	})
 
+	It("Should migrate the database it keeps", func() {
+		c := &Config{DBType: "sqlite3", DBLocation: ":memory:"}
+		Expect(c.Automigrate()).To(Succeed())
+		defer c.Close()
+
+		db, err := c.DB()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(db.HasTable(&Metric{})).To(BeTrue())
+	})
+
 	Context("With an invalid connection", func() {
 		var c *Config
 
@@ -0,0 +1,351 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package uberalls
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/uber/uberalls/coverage"
+)
+
+// Coverage report formats
+const (
+	ReportCobertura = "cobertura"
+	ReportLCOV      = "lcov"
+	ReportGo        = "go"
+)
+
+// reportNames are the file names FindReports looks for
+var reportNames = map[string]bool{
+	"coverage.xml":           true,
+	"cobertura.xml":          true,
+	"cobertura-coverage.xml": true,
+	"lcov.info":              true,
+	"coverage.out":           true,
+	"cover.out":              true,
+}
+
+// reportExtensions are the file extensions FindReports looks for
+var reportExtensions = map[string]bool{
+	".lcov":         true,
+	".coverprofile": true,
+}
+
+// skippedDirs are never searched for reports
+var skippedDirs = map[string]bool{
+	".git":         true,
+	"node_modules": true,
+	"vendor":       true,
+}
+
+// FindReports finds coverage reports under dir by their conventional names
+func FindReports(dir string) ([]string, error) {
+	var reports []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			if path != dir && skippedDirs[info.Name()] {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if reportNames[info.Name()] || reportExtensions[filepath.Ext(path)] {
+			reports = append(reports, path)
+		}
+		return nil
+	})
+	return reports, err
+}
+
+// ReportFormat identifies the format of a coverage report from its content
+func ReportFormat(content []byte) (string, error) {
+	trimmed := bytes.TrimSpace(content)
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("mode:")):
+		return ReportGo, nil
+	case bytes.Contains(trimmed, []byte("<coverage")):
+		return ReportCobertura, nil
+	case bytes.HasPrefix(trimmed, []byte("TN:")) || bytes.HasPrefix(trimmed, []byte("SF:")):
+		return ReportLCOV, nil
+	}
+	return "", fmt.Errorf("unknown coverage report format")
+}
+
+// ParseReport reads a coverage report into line-level data, with file names
+// relative to root when they are under it
+func ParseReport(path, root string) ([]SourceFile, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	format, err := ReportFormat(content)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	var files coverageLines
+	switch format {
+	case ReportCobertura:
+		files, err = parseCobertura(content, root)
+	case ReportLCOV:
+		files, err = parseLCOV(content, root)
+	case ReportGo:
+		files, err = parseGoProfile(content, root)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return files.sourceFiles(), nil
+}
+
+// coverageLines accumulates the hit counts of lines, and the hits of
+// branches as [line, block, branch, hits] groups, per file
+type coverageLines map[string]*fileLines
+
+type fileLines struct {
+	hits     map[int64]int64
+	branches []int64
+}
+
+func (cl coverageLines) file(name string) *fileLines {
+	f, ok := cl[name]
+	if !ok {
+		f = &fileLines{hits: map[int64]int64{}}
+		cl[name] = f
+	}
+	return f
+}
+
+// hit records hits on a line, keeping the highest count reported for it
+func (f *fileLines) hit(line, hits int64) {
+	if current, ok := f.hits[line]; !ok || hits > current {
+		f.hits[line] = hits
+	}
+}
+
+func (cl coverageLines) sourceFiles() []SourceFile {
+	files := make([]SourceFile, 0, len(cl))
+	for name, f := range cl {
+		var last int64
+		for line := range f.hits {
+			if line > last {
+				last = line
+			}
+		}
+		coverage := make([]*int64, last)
+		for line, hits := range f.hits {
+			if line > 0 {
+				hits := hits
+				coverage[line-1] = &hits
+			}
+		}
+		files = append(files, SourceFile{Name: name, Coverage: coverage, Branches: f.branches})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name < files[j].Name })
+	return files
+}
+
+// relativeName makes a file name from a report relative to root, trying each
+// source directory the report names for relative names
+func relativeName(name, root string, sources []string) string {
+	name = filepath.FromSlash(name)
+	if !filepath.IsAbs(name) {
+		for _, source := range sources {
+			candidate := filepath.Join(source, name)
+			if !filepath.IsAbs(candidate) {
+				candidate = filepath.Join(root, candidate)
+			}
+			if _, err := os.Stat(candidate); err == nil {
+				name = candidate
+				break
+			}
+		}
+	}
+	if filepath.IsAbs(name) {
+		if rel, err := filepath.Rel(root, name); err == nil && !strings.HasPrefix(rel, "..") {
+			name = rel
+		}
+	}
+	return coverage.CleanPath(filepath.ToSlash(name))
+}
+
+type coberturaReport struct {
+	Sources []string         `xml:"sources>source"`
+	Classes []coberturaClass `xml:"packages>package>classes>class"`
+}
+
+type coberturaClass struct {
+	Filename string          `xml:"filename,attr"`
+	Lines    []coberturaLine `xml:"lines>line"`
+}
+
+type coberturaLine struct {
+	Number            int64  `xml:"number,attr"`
+	Hits              int64  `xml:"hits,attr"`
+	Branch            bool   `xml:"branch,attr"`
+	ConditionCoverage string `xml:"condition-coverage,attr"`
+}
+
+// parseCobertura reads a Cobertura XML report. Cobertura only counts the
+// branches of a line that were taken, so they are numbered in order with the
+// taken ones first.
+func parseCobertura(content []byte, root string) (coverageLines, error) {
+	var report coberturaReport
+	if err := xml.Unmarshal(content, &report); err != nil {
+		return nil, err
+	}
+
+	files := coverageLines{}
+	for _, class := range report.Classes {
+		f := files.file(relativeName(class.Filename, root, report.Sources))
+		for _, line := range class.Lines {
+			f.hit(line.Number, line.Hits)
+			if !line.Branch || line.ConditionCoverage == "" {
+				continue
+			}
+			var percent, covered, total int64
+			if _, err := fmt.Sscanf(line.ConditionCoverage, "%d%% (%d/%d)", &percent, &covered, &total); err != nil {
+				return nil, fmt.Errorf("line %d: bad condition-coverage '%s'", line.Number, line.ConditionCoverage)
+			}
+			for branch := int64(0); branch < total; branch++ {
+				var hits int64
+				if branch < covered {
+					hits = 1
+				}
+				f.branches = append(f.branches, line.Number, 0, branch, hits)
+			}
+		}
+	}
+	return files, nil
+}
+
+// parseLCOV reads an LCOV tracefile
+func parseLCOV(content []byte, root string) (coverageLines, error) {
+	files := coverageLines{}
+	var f *fileLines
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(nil, 1<<20)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		record := strings.SplitN(line, ":", 2)
+		if len(record) < 2 {
+			if line == "end_of_record" {
+				f = nil
+			}
+			continue
+		}
+
+		fields := strings.Split(record[1], ",")
+		switch record[0] {
+		case "SF":
+			f = files.file(relativeName(record[1], root, nil))
+		case "DA":
+			if f == nil || len(fields) < 2 {
+				return nil, fmt.Errorf("line %d: DA outside of a file", number)
+			}
+			lineNumber, err1 := strconv.ParseInt(fields[0], 10, 64)
+			hits, err2 := strconv.ParseInt(fields[1], 10, 64)
+			if err1 != nil || err2 != nil {
+				return nil, fmt.Errorf("line %d: bad DA record '%s'", number, line)
+			}
+			f.hit(lineNumber, hits)
+		case "BRDA":
+			if f == nil || len(fields) < 4 {
+				return nil, fmt.Errorf("line %d: BRDA outside of a file", number)
+			}
+			group := make([]int64, 4)
+			for i, field := range fields[:4] {
+				if i == 3 && field == "-" {
+					continue
+				}
+				value, err := strconv.ParseInt(field, 10, 64)
+				if err != nil {
+					return nil, fmt.Errorf("line %d: bad BRDA record '%s'", number, line)
+				}
+				group[i] = value
+			}
+			f.branches = append(f.branches, group...)
+		}
+	}
+	return files, scanner.Err()
+}
+
+// parseGoProfile reads a Go cover profile. Its file names are import paths,
+// which are made relative to root using the module path in root's go.mod.
+func parseGoProfile(content []byte, root string) (coverageLines, error) {
+	module := goModulePath(root)
+	files := coverageLines{}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	scanner.Buffer(nil, 1<<20)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "mode:") {
+			continue
+		}
+
+		// name.go:startLine.startCol,endLine.endCol statements count
+		colon := strings.LastIndex(line, ":")
+		var startLine, startCol, endLine, endCol, statements, count int64
+		if colon < 0 {
+			return nil, fmt.Errorf("line %d: bad block '%s'", number, line)
+		}
+		if _, err := fmt.Sscanf(line[colon+1:], "%d.%d,%d.%d %d %d",
+			&startLine, &startCol, &endLine, &endCol, &statements, &count); err != nil {
+			return nil, fmt.Errorf("line %d: bad block '%s'", number, line)
+		}
+
+		name := line[:colon]
+		if module != "" && strings.HasPrefix(name, module+"/") {
+			name = strings.TrimPrefix(name, module+"/")
+		}
+		f := files.file(relativeName(name, root, nil))
+		for l := startLine; l <= endLine; l++ {
+			f.hit(l, count)
+		}
+	}
+	return files, scanner.Err()
+}
+
+// goModulePath returns the module path declared in root's go.mod, if any
+func goModulePath(root string) string {
+	content, err := ioutil.ReadFile(filepath.Join(root, "go.mod"))
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "module" {
+			return strings.Trim(fields[1], `"`)
+		}
+	}
+	return ""
+}
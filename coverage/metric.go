@@ -0,0 +1,176 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package coverage holds the coverage metrics uberalls records and serves,
+// shared by the server and its clients.
+package coverage
+
+import (
+	"math"
+	"net/url"
+)
+
+// DefaultBranch is the branch queried when neither a commit nor a branch is
+// given
+const DefaultBranch = "origin/master"
+
+// Metric represents code coverage
+type Metric struct {
+	ID                  int64   `gorm:"primary_key:yes" json:"id"`
+	Repository          string  `sql:"not null" json:"repository"`
+	Sha                 string  `sql:"not null" json:"sha"`
+	Branch              string  `json:"branch"`
+	Suite               string  `json:"suite"`
+	Component           string  `sql:"not null;default:''" json:"component,omitempty"`
+	PackageCoverage     float64 `sql:"not null" json:"packageCoverage"`
+	FilesCoverage       float64 `sql:"not null" json:"filesCoverage"`
+	ClassesCoverage     float64 `sql:"not null" json:"classesCoverage"`
+	MethodCoverage      float64 `sql:"not null" json:"methodCoverage"`
+	LineCoverage        float64 `sql:"not null" json:"lineCoverage"`
+	ConditionalCoverage float64 `sql:"not null" json:"conditionalCoverage"`
+	Timestamp           int64   `sql:"not null" json:"timestamp"`
+	LinesCovered        int64   `sql:"not null" json:"linesCovered"`
+	LinesTested         int64   `sql:"not null" json:"linesTested"`
+
+	// Suites lists the test suites merged into a combined metric
+	Suites []string `sql:"-" json:"suites,omitempty"`
+	// SourceFiles optionally carries line-level data for the report
+	SourceFiles []SourceFile `sql:"-" json:"sourceFiles,omitempty"`
+}
+
+// ErrorResponse is the body of the API's error responses
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// ExtractMetricQuery extracts a query from the request
+func ExtractMetricQuery(form url.Values) Metric {
+	repository := form["repository"][0]
+	query := Metric{
+		Repository: repository,
+	}
+
+	if len(form["sha"]) < 1 {
+		if len(form["branch"]) < 1 {
+			query.Branch = DefaultBranch
+		} else {
+			query.Branch = form["branch"][0]
+		}
+	} else {
+		query.Sha = form["sha"][0]
+	}
+
+	if len(form["suite"]) > 0 {
+		query.Suite = form["suite"][0]
+	}
+	if len(form["component"]) > 0 {
+		query.Component = form["component"][0]
+	}
+	return query
+}
+
+// MergeMetrics combines the latest metric of each suite for a commit into a
+// single view. Without line-level data the union of covered lines cannot be
+// computed, so each field takes the best value reported by any suite, which is
+// a lower bound for the combined coverage. Lines covered and tested are those
+// of the suite with the best line coverage, so they agree with it.
+func MergeMetrics(metrics []Metric) Metric {
+	if len(metrics) == 1 {
+		return metrics[0]
+	}
+
+	merged := Metric{}
+	for _, m := range metrics {
+		if merged.Repository == "" {
+			merged.Repository = m.Repository
+			merged.Sha = m.Sha
+			merged.Branch = m.Branch
+			merged.Component = m.Component
+		}
+		merged.PackageCoverage = math.Max(merged.PackageCoverage, m.PackageCoverage)
+		merged.FilesCoverage = math.Max(merged.FilesCoverage, m.FilesCoverage)
+		merged.ClassesCoverage = math.Max(merged.ClassesCoverage, m.ClassesCoverage)
+		merged.MethodCoverage = math.Max(merged.MethodCoverage, m.MethodCoverage)
+		if m.LineCoverage >= merged.LineCoverage {
+			merged.LineCoverage = m.LineCoverage
+			merged.LinesCovered = m.LinesCovered
+			merged.LinesTested = m.LinesTested
+		}
+		merged.ConditionalCoverage = math.Max(merged.ConditionalCoverage, m.ConditionalCoverage)
+		if m.Timestamp > merged.Timestamp {
+			merged.Timestamp = m.Timestamp
+		}
+		merged.Suites = append(merged.Suites, m.Suite)
+	}
+	return merged
+}
+
+// MetricField names a coverage percentage of Metric
+type MetricField struct {
+	Key   string
+	Label string
+}
+
+// MetricFields lists the coverage percentages charted by the dashboard
+var MetricFields = []MetricField{
+	{"lineCoverage", "Lines"},
+	{"conditionalCoverage", "Conditionals"},
+	{"methodCoverage", "Methods"},
+	{"classesCoverage", "Classes"},
+	{"filesCoverage", "Files"},
+	{"packageCoverage", "Packages"},
+}
+
+// Field returns the coverage percentage named by its JSON key
+func (m Metric) Field(key string) float64 {
+	switch key {
+	case "packageCoverage":
+		return m.PackageCoverage
+	case "filesCoverage":
+		return m.FilesCoverage
+	case "classesCoverage":
+		return m.ClassesCoverage
+	case "methodCoverage":
+		return m.MethodCoverage
+	case "lineCoverage":
+		return m.LineCoverage
+	case "conditionalCoverage":
+		return m.ConditionalCoverage
+	}
+	return 0
+}
+
+// Comparison is the coverage of a head commit against a base commit
+type Comparison struct {
+	Base Metric `json:"base"`
+	Head Metric `json:"head"`
+	// Changes maps the JSON key of every field in MetricFields to head's
+	// value minus base's
+	Changes map[string]float64 `json:"changes"`
+}
+
+// Compare compares the coverage of head with that of base
+func Compare(base, head Metric) Comparison {
+	changes := make(map[string]float64, len(MetricFields))
+	for _, field := range MetricFields {
+		changes[field.Key] = head.Field(field.Key) - base.Field(field.Key)
+	}
+	return Comparison{Base: base, Head: head, Changes: changes}
+}
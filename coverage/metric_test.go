@@ -0,0 +1,103 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package coverage_test
+
+import (
+	"net/url"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/uber/uberalls/coverage"
+)
+
+var _ = Describe("ExtractMetricsQuery", func() {
+	Context("When branch is specified in the query", func() {
+		values := url.Values{
+			"repository": []string{"foo"},
+			"branch":     []string{"master"},
+		}
+		query := ExtractMetricQuery(values)
+
+		It("Should extract the master branch", func() {
+			Expect(query.Branch).To(Equal("master"))
+		})
+	})
+
+	Context("When a suite is specified", func() {
+		values := url.Values{
+			"repository": []string{"foo"},
+			"sha":        []string{"deadbeef"},
+			"suite":      []string{"unit"},
+		}
+		query := ExtractMetricQuery(values)
+
+		It("Should extract the suite", func() {
+			Expect(query.Suite).To(Equal("unit"))
+		})
+	})
+
+	Context("When no branch is specified", func() {
+		values := url.Values{
+			"repository": []string{"foo"},
+		}
+		query := ExtractMetricQuery(values)
+
+		It("Should extract the default branch", func() {
+			Expect(query.Branch).To(Equal("origin/master"))
+		})
+	})
+})
+
+var _ = Describe("MergeMetrics", func() {
+	merged := MergeMetrics([]Metric{
+		{Suite: "unit", LineCoverage: 90, LinesCovered: 90, LinesTested: 100, MethodCoverage: 20},
+		{Suite: "e2e", LineCoverage: 1, LinesCovered: 10, LinesTested: 1000, MethodCoverage: 80},
+	})
+
+	It("Should keep the best value of every field", func() {
+		Expect(merged.Suites).To(Equal([]string{"unit", "e2e"}))
+		Expect(merged.LineCoverage).To(Equal(90.))
+		Expect(merged.MethodCoverage).To(Equal(80.))
+	})
+
+	It("Should keep the line counts of the best line coverage", func() {
+		Expect(merged.LinesCovered).To(BeEquivalentTo(90))
+		Expect(merged.LinesTested).To(BeEquivalentTo(100))
+	})
+})
+
+var _ = Describe("Compare", func() {
+	base := Metric{Sha: "base", LineCoverage: 80, ConditionalCoverage: 50}
+	head := Metric{Sha: "head", LineCoverage: 75, ConditionalCoverage: 60}
+	comparison := Compare(base, head)
+
+	It("Should keep both metrics", func() {
+		Expect(comparison.Base.Sha).To(Equal("base"))
+		Expect(comparison.Head.Sha).To(Equal("head"))
+	})
+
+	It("Should compute the change of every field", func() {
+		Expect(comparison.Changes).To(HaveLen(len(MetricFields)))
+		Expect(comparison.Changes["lineCoverage"]).To(Equal(-5.))
+		Expect(comparison.Changes["conditionalCoverage"]).To(Equal(10.))
+		Expect(comparison.Changes["methodCoverage"]).To(BeZero())
+	})
+})
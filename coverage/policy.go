@@ -0,0 +1,36 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package coverage
+
+// Policy holds the coverage a repository's builds must reach, in percent or
+// percentage points. Unset thresholds don't apply.
+type Policy struct {
+	MinLine        *float64 `json:"minLine,omitempty"`
+	MinConditional *float64 `json:"minConditional,omitempty"`
+	// MaxLineDrop is how far line coverage may fall below the base's
+	MaxLineDrop *float64 `json:"maxLineDrop,omitempty"`
+	// MaxConditionalDrop is how far conditional coverage may fall below the
+	// base's
+	MaxConditionalDrop *float64 `json:"maxConditionalDrop,omitempty"`
+	// RequireBase fails the drop thresholds when there is no base to compare
+	// with, instead of skipping them
+	RequireBase bool `json:"requireBase,omitempty"`
+}
@@ -0,0 +1,204 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package coverage
+
+import (
+	"path"
+	"sort"
+	"strings"
+)
+
+// SourceFile holds line-level coverage for a single file, in the same shape
+// Coveralls accepts
+type SourceFile struct {
+	Name string `json:"name"`
+	// Coverage has one entry per source line: the hit count, or null for
+	// lines that are not executable
+	Coverage []*int64 `json:"coverage"`
+	// Branches is a flat list of [line, block, branch, hits] groups
+	Branches []int64 `json:"branches,omitempty"`
+	// Source optionally holds the file's contents, for annotated views
+	Source string `json:"source,omitempty"`
+}
+
+type branchKey struct {
+	line, block, branch int64
+}
+
+type byBranchKey []branchKey
+
+func (k byBranchKey) Len() int      { return len(k) }
+func (k byBranchKey) Swap(i, j int) { k[i], k[j] = k[j], k[i] }
+func (k byBranchKey) Less(i, j int) bool {
+	if k[i].line != k[j].line {
+		return k[i].line < k[j].line
+	}
+	if k[i].block != k[j].block {
+		return k[i].block < k[j].block
+	}
+	return k[i].branch < k[j].branch
+}
+
+// LineCounts returns the number of covered and executable lines
+func (f SourceFile) LineCounts() (covered, tested int64) {
+	for _, hits := range f.Coverage {
+		if hits == nil {
+			continue
+		}
+		tested++
+		if *hits > 0 {
+			covered++
+		}
+	}
+	return covered, tested
+}
+
+// BranchCounts returns the number of covered and total branches
+func (f SourceFile) BranchCounts() (covered, total int64) {
+	for i := 0; i+3 < len(f.Branches); i += 4 {
+		total++
+		if f.Branches[i+3] > 0 {
+			covered++
+		}
+	}
+	return covered, total
+}
+
+// MergeSourceFiles returns the union of several reports' line-level data,
+// summing hit counts for lines and branches found in more than one report.
+// Files are matched, and named, by their CleanPath.
+func MergeSourceFiles(reports ...[]SourceFile) []SourceFile {
+	byName := make(map[string]*SourceFile)
+	branches := make(map[string]map[branchKey]int64)
+	var names []string
+
+	for _, files := range reports {
+		for _, f := range files {
+			name := CleanPath(f.Name)
+			merged, ok := byName[name]
+			if !ok {
+				merged = &SourceFile{Name: name}
+				byName[name] = merged
+				branches[name] = make(map[branchKey]int64)
+				names = append(names, name)
+			}
+			merged.Coverage = mergeLineHits(merged.Coverage, f.Coverage)
+			if merged.Source == "" {
+				merged.Source = f.Source
+			}
+			for i := 0; i+3 < len(f.Branches); i += 4 {
+				key := branchKey{f.Branches[i], f.Branches[i+1], f.Branches[i+2]}
+				branches[name][key] += f.Branches[i+3]
+			}
+		}
+	}
+
+	sort.Strings(names)
+	merged := make([]SourceFile, 0, len(names))
+	for _, name := range names {
+		f := byName[name]
+		f.Branches = flattenBranches(branches[name])
+		merged = append(merged, *f)
+	}
+	return merged
+}
+
+func mergeLineHits(a, b []*int64) []*int64 {
+	if len(b) > len(a) {
+		a, b = b, a
+	}
+	merged := make([]*int64, len(a))
+	for i := range a {
+		var other *int64
+		if i < len(b) {
+			other = b[i]
+		}
+		switch {
+		case a[i] == nil && other == nil:
+		case a[i] == nil:
+			hits := *other
+			merged[i] = &hits
+		case other == nil:
+			hits := *a[i]
+			merged[i] = &hits
+		default:
+			hits := *a[i] + *other
+			merged[i] = &hits
+		}
+	}
+	return merged
+}
+
+func flattenBranches(hits map[branchKey]int64) []int64 {
+	if len(hits) == 0 {
+		return nil
+	}
+	keys := make([]branchKey, 0, len(hits))
+	for key := range hits {
+		keys = append(keys, key)
+	}
+	sort.Sort(byBranchKey(keys))
+
+	flat := make([]int64, 0, 4*len(keys))
+	for _, key := range keys {
+		flat = append(flat, key.line, key.block, key.branch, hits[key])
+	}
+	return flat
+}
+
+// ApplySourceFiles derives a metric's line and conditional coverage from its
+// line-level data, when it has any
+func (m *Metric) ApplySourceFiles() {
+	if len(m.SourceFiles) == 0 {
+		return
+	}
+
+	var linesCovered, linesTested, branchesCovered, branchesTotal int64
+	for _, f := range m.SourceFiles {
+		covered, tested := f.LineCounts()
+		linesCovered += covered
+		linesTested += tested
+		covered, total := f.BranchCounts()
+		branchesCovered += covered
+		branchesTotal += total
+	}
+
+	m.LinesCovered = linesCovered
+	m.LinesTested = linesTested
+	m.LineCoverage = Percentage(linesCovered, linesTested)
+	if branchesTotal > 0 {
+		m.ConditionalCoverage = Percentage(branchesCovered, branchesTotal)
+	}
+}
+
+// Percentage returns covered as a percentage of total, or 0 if total is 0
+func Percentage(covered, total int64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(covered) / float64(total)
+}
+
+// CleanPath normalizes a repository-relative, slash-separated path
+func CleanPath(p string) string {
+	p = path.Clean("/" + strings.TrimSpace(p))
+	return strings.TrimPrefix(p, "/")
+}
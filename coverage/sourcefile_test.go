@@ -0,0 +1,110 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package coverage_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/uber/uberalls/coverage"
+)
+
+func hits(counts ...int64) []*int64 {
+	lines := make([]*int64, len(counts))
+	for i, count := range counts {
+		if count >= 0 {
+			c := count
+			lines[i] = &c
+		}
+	}
+	return lines
+}
+
+var _ = Describe("Source files", func() {
+	file := SourceFile{
+		Name:     "main.go",
+		Coverage: hits(-1, 1, 0, 3),
+		Branches: []int64{2, 0, 0, 1, 2, 0, 1, 0},
+	}
+
+	It("Should count lines", func() {
+		covered, tested := file.LineCounts()
+		Expect(covered).To(Equal(int64(2)))
+		Expect(tested).To(Equal(int64(3)))
+	})
+
+	It("Should count branches", func() {
+		covered, total := file.BranchCounts()
+		Expect(covered).To(Equal(int64(1)))
+		Expect(total).To(Equal(int64(2)))
+	})
+
+	Context("Merging reports", func() {
+		other := SourceFile{
+			Name:     "main.go",
+			Coverage: hits(-1, 0, 2, 0, 1),
+			Branches: []int64{2, 0, 1, 4},
+		}
+		merged := MergeSourceFiles(
+			[]SourceFile{file},
+			[]SourceFile{other, {Name: "util.go", Coverage: hits(0)}},
+		)
+
+		It("Should keep every file", func() {
+			Expect(merged).To(HaveLen(2))
+			Expect(merged[0].Name).To(Equal("main.go"))
+			Expect(merged[1].Name).To(Equal("util.go"))
+		})
+
+		It("Should take the union of covered lines", func() {
+			Expect(merged[0].Coverage).To(Equal(hits(-1, 1, 2, 3, 1)))
+		})
+
+		It("Should sum branch hits", func() {
+			Expect(merged[0].Branches).To(Equal([]int64{2, 0, 0, 1, 2, 0, 1, 4}))
+		})
+
+		It("Should match files by their clean path", func() {
+			merged := MergeSourceFiles(
+				[]SourceFile{{Name: "./pkg/a.go", Coverage: hits(1, 0)}},
+				[]SourceFile{{Name: "pkg/a.go", Coverage: hits(0, 1)}},
+			)
+			Expect(merged).To(HaveLen(1))
+			Expect(merged[0].Name).To(Equal("pkg/a.go"))
+			Expect(merged[0].Coverage).To(Equal(hits(1, 1)))
+		})
+	})
+
+	It("Should derive a metric's line coverage", func() {
+		m := Metric{SourceFiles: []SourceFile{file}}
+		m.ApplySourceFiles()
+		Expect(m.LinesCovered).To(Equal(int64(2)))
+		Expect(m.LinesTested).To(Equal(int64(3)))
+		Expect(m.LineCoverage).To(BeNumerically("~", 66.67, 0.01))
+		Expect(m.ConditionalCoverage).To(Equal(50.))
+	})
+})
+
+var _ = Describe("CleanPath", func() {
+	It("Should make paths relative to the repository root", func() {
+		Expect(CleanPath(" /src/../main.go")).To(Equal("main.go"))
+		Expect(CleanPath("./a//b/")).To(Equal("a/b"))
+	})
+})
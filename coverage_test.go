@@ -0,0 +1,128 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package uberalls_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/uber/uberalls"
+)
+
+var _ = Describe("Coverage reports", func() {
+	var root string
+
+	writeReport := func(name, content string) string {
+		path := filepath.Join(root, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		Expect(ioutil.WriteFile(path, []byte(content), 0644)).To(Succeed())
+		return path
+	}
+
+	BeforeEach(func() {
+		root, _ = ioutil.TempDir("", "uberalls-reports")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(root)
+	})
+
+	It("Should find reports by their conventional names", func() {
+		writeReport("coverage.xml", "<coverage/>")
+		writeReport("web/lcov.info", "SF:a.js\n")
+		writeReport("pkg/unit.coverprofile", "mode: set\n")
+		writeReport("node_modules/dep/lcov.info", "SF:b.js\n")
+		writeReport("README.md", "")
+
+		reports, err := FindReports(root)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(reports).To(ConsistOf(
+			filepath.Join(root, "coverage.xml"),
+			filepath.Join(root, "web/lcov.info"),
+			filepath.Join(root, "pkg/unit.coverprofile"),
+		))
+	})
+
+	It("Should read Cobertura reports", func() {
+		writeReport("src/app/main.py", "")
+		path := writeReport("coverage.xml", `<?xml version="1.0" ?>
+<coverage line-rate="0.5">
+	<sources><source>`+filepath.Join(root, "src")+`</source></sources>
+	<packages><package name="app"><classes>
+		<class filename="app/main.py">
+			<lines>
+				<line number="1" hits="3"/>
+				<line number="2" hits="0"/>
+				<line number="4" hits="1" branch="true" condition-coverage="50% (1/2)"/>
+			</lines>
+		</class>
+	</classes></package></packages>
+</coverage>`)
+
+		files, err := ParseReport(path, root)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(files).To(HaveLen(1))
+		Expect(files[0].Name).To(Equal("src/app/main.py"))
+		Expect(files[0].Coverage).To(Equal(hits(3, 0, -1, 1)))
+		Expect(files[0].Branches).To(Equal([]int64{4, 0, 0, 1, 4, 0, 1, 0}))
+	})
+
+	It("Should read LCOV reports", func() {
+		path := writeReport("lcov.info", `TN:
+SF:`+filepath.Join(root, "web/app.js")+`
+DA:1,1
+DA:2,0
+BRDA:2,0,0,-
+BRDA:2,0,1,4
+end_of_record
+`)
+
+		files, err := ParseReport(path, root)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(files).To(HaveLen(1))
+		Expect(files[0].Name).To(Equal("web/app.js"))
+		Expect(files[0].Coverage).To(Equal(hits(1, 0)))
+		Expect(files[0].Branches).To(Equal([]int64{2, 0, 0, 0, 2, 0, 1, 4}))
+	})
+
+	It("Should read Go cover profiles relative to the module", func() {
+		writeReport("go.mod", "module github.com/uber/example\n")
+		path := writeReport("coverage.out", `mode: count
+github.com/uber/example/pkg/a.go:3.10,5.2 2 1
+github.com/uber/example/pkg/a.go:6.2,6.20 1 0
+`)
+
+		files, err := ParseReport(path, root)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(files).To(HaveLen(1))
+		Expect(files[0].Name).To(Equal("pkg/a.go"))
+		Expect(files[0].Coverage).To(Equal(hits(-1, -1, 1, 1, 1, 0)))
+	})
+
+	It("Should reject unknown formats", func() {
+		path := writeReport("coverage.out", "hello")
+		_, err := ParseReport(path, root)
+		Expect(err).To(MatchError(ContainSubstring("unknown coverage report format")))
+	})
+})
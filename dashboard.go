@@ -0,0 +1,321 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package uberalls
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/uber/uberalls/coverage"
+)
+
+// dashboardHistory is the number of commits charted per branch
+const dashboardHistory = 100
+
+// DashboardHandler serves an HTML dashboard of the metrics store
+type DashboardHandler struct {
+	db           *gorm.DB
+	metrics      MetricsHandler
+	repositories RepositoriesHandler
+}
+
+// NewDashboardHandler creates a new DashboardHandler, combining suites
+// through metrics
+func NewDashboardHandler(db *gorm.DB, metrics MetricsHandler) DashboardHandler {
+	return DashboardHandler{
+		db:           db,
+		metrics:      metrics,
+		repositories: NewRepositoriesHandler(db),
+	}
+}
+
+// ServeHTTP handles an HTTP request for a dashboard page
+func (dh DashboardHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	switch strings.TrimSuffix(r.URL.Path, "/") {
+	case "", "/dashboard":
+		dh.renderRepositories(w, r)
+	case "/dashboard/repository":
+		dh.renderRepository(w, r)
+	case "/dashboard/compare":
+		dh.renderCompare(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (dh DashboardHandler) renderRepositories(w http.ResponseWriter, r *http.Request) {
+	page := dh.repositories.Repositories("", r.Form.Get("after"), maxPageSize, readable(r))
+	dh.render(w, "repositories", page)
+}
+
+// authorize checks read access to a repository, prompting browsers for a
+// token when the request is unauthenticated
+func (dh DashboardHandler) authorize(w http.ResponseWriter, r *http.Request, repository string) bool {
+	status, err := permitted(r, repository, PermissionRead)
+	if err == nil {
+		return true
+	}
+	if status == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Basic realm="uberalls"`)
+	}
+	http.Error(w, err.Error(), status)
+	return false
+}
+
+func (dh DashboardHandler) renderRepository(w http.ResponseWriter, r *http.Request) {
+	repository := r.Form.Get("repository")
+	if repository == "" {
+		http.Error(w, "missing 'repository'", http.StatusBadRequest)
+		return
+	}
+	if !dh.authorize(w, r, repository) {
+		return
+	}
+
+	branches := dh.repositories.Branches(repository, "", "", maxPageSize).Branches
+	if len(branches) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	for i, b := range branches {
+		if b.Latest != nil {
+			combined := dh.metrics.combinedMetric(*b.Latest, nil)
+			branches[i].Latest = &combined
+		}
+	}
+
+	branch := r.Form.Get("branch")
+	if branch == "" {
+		branch = defaultBranch
+	}
+	history := Metric{Repository: repository, Branch: branch, Suite: r.Form.Get("suite")}
+	metrics, err := dh.metrics.History(history, nil, dashboardHistory)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	charts := make([]Chart, 0, len(coverage.MetricFields))
+	for _, field := range coverage.MetricFields {
+		charts = append(charts, NewChart(field, metrics))
+	}
+
+	dh.render(w, "repository", struct {
+		Repository string
+		Branch     string
+		Branches   []Branch
+		Charts     []Chart
+		History    []Metric
+	}{repository, branch, branches, charts, metrics})
+}
+
+func (dh DashboardHandler) renderCompare(w http.ResponseWriter, r *http.Request) {
+	repository := r.Form.Get("repository")
+	base, head := r.Form.Get("base"), r.Form.Get("head")
+	if repository == "" || base == "" || head == "" {
+		http.Error(w, "missing 'repository', 'base' or 'head'", http.StatusBadRequest)
+		return
+	}
+	if !dh.authorize(w, r, repository) {
+		return
+	}
+
+	baseMetric := latestMetric(dh.db, Metric{Repository: repository, Sha: base}, nil)
+	headMetric := latestMetric(dh.db, Metric{Repository: repository, Sha: head}, nil)
+	if baseMetric.ID == 0 || headMetric.ID == 0 {
+		http.NotFound(w, r)
+		return
+	}
+
+	dh.render(w, "compare", struct {
+		Repository string
+		Base       Metric
+		Head       Metric
+		Fields     []coverage.MetricField
+	}{
+		repository,
+		dh.metrics.combinedMetric(*baseMetric, nil),
+		dh.metrics.combinedMetric(*headMetric, nil),
+		coverage.MetricFields,
+	})
+}
+
+func (dh DashboardHandler) render(w http.ResponseWriter, page string, data interface{}) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := dashboardTemplates.ExecuteTemplate(w, page, data); err != nil {
+		logger.Error("Unable to render dashboard page", "page", page, "error", err)
+	}
+}
+
+// Chart is an SVG line chart of one coverage field over time, oldest first
+type Chart struct {
+	Field  coverage.MetricField
+	Points string
+	Latest float64
+}
+
+// Chart dimensions, in SVG user units
+const (
+	chartWidth  = 600
+	chartHeight = 100
+)
+
+// NewChart plots a field of metrics, given newest first
+func NewChart(field coverage.MetricField, metrics []Metric) Chart {
+	chart := Chart{Field: field}
+	if len(metrics) == 0 {
+		return chart
+	}
+	chart.Latest = metrics[0].Field(field.Key)
+
+	points := make([]string, 0, len(metrics))
+	step := 0.
+	if len(metrics) > 1 {
+		step = float64(chartWidth) / float64(len(metrics)-1)
+	}
+	for i := range metrics {
+		m := metrics[len(metrics)-1-i]
+		y := chartHeight - m.Field(field.Key)*chartHeight/100
+		points = append(points, fmt.Sprintf("%.1f,%.1f", float64(i)*step, y))
+	}
+	chart.Points = strings.Join(points, " ")
+	return chart
+}
+
+var dashboardFuncs = template.FuncMap{
+	"percent": func(value float64) string {
+		return fmt.Sprintf("%.2f%%", value)
+	},
+	"delta": func(base, head Metric, key string) string {
+		return fmt.Sprintf("%+.2f%%", head.Field(key)-base.Field(key))
+	},
+	"field": func(m Metric, key string) float64 {
+		return m.Field(key)
+	},
+	"time": func(timestamp int64) string {
+		return time.Unix(timestamp, 0).UTC().Format("2006-01-02 15:04")
+	},
+	"short": func(sha string) string {
+		if len(sha) > 10 {
+			return sha[:10]
+		}
+		return sha
+	},
+}
+
+var dashboardTemplates = template.Must(template.New("dashboard").Funcs(dashboardFuncs).Parse(`
+{{define "header"}}<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>uberalls</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+a { color: #0366d6; text-decoration: none; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { padding: 0.3em 0.8em; border-bottom: 1px solid #ddd; text-align: left; }
+td.number { text-align: right; font-family: monospace; }
+.chart { display: inline-block; margin: 0 2em 2em 0; }
+.chart svg { background: #f6f8fa; }
+.chart polyline { fill: none; stroke: #28a745; stroke-width: 2; }
+</style>
+</head>
+<body>
+<h1><a href="/dashboard/">uberalls</a></h1>
+{{end}}
+
+{{define "footer"}}</body>
+</html>
+{{end}}
+
+{{define "repositories"}}{{template "header"}}
+<h2>Repositories</h2>
+<table>
+<tr><th>Repository</th><th>Branch</th><th>Commit</th><th>Lines</th><th>Last upload</th></tr>
+{{range .Repositories}}<tr>
+<td><a href="/dashboard/repository?repository={{.Name}}">{{.Name}}</a></td>
+{{with .Latest}}<td>{{.Branch}}</td>
+<td>{{short .Sha}}</td>
+<td class="number">{{percent .LineCoverage}}</td>
+{{else}}<td></td><td></td><td></td>
+{{end}}<td>{{time .LastUpload}}</td>
+</tr>
+{{else}}<tr><td colspan="5">No coverage recorded yet</td></tr>
+{{end}}</table>
+{{with .Next}}<p><a href="/dashboard/?after={{.}}">More repositories</a></p>{{end}}
+{{template "footer"}}{{end}}
+
+{{define "repository"}}{{template "header"}}
+<h2>{{.Repository}}</h2>
+<h3>Branches</h3>
+<table>
+<tr><th>Branch</th><th>Commit</th><th>Lines</th><th>Conditionals</th><th>Last upload</th></tr>
+{{range .Branches}}<tr>
+<td><a href="/dashboard/repository?repository={{$.Repository}}&branch={{.Name}}">{{.Name}}</a></td>
+{{with .Latest}}<td>{{short .Sha}}</td>
+<td class="number">{{percent .LineCoverage}}</td>
+<td class="number">{{percent .ConditionalCoverage}}</td>
+{{else}}<td></td><td></td><td></td>
+{{end}}<td>{{time .LastUpload}}</td>
+</tr>
+{{end}}</table>
+<h3>History of {{.Branch}}</h3>
+{{range .Charts}}<div class="chart">
+<div>{{.Field.Label}}: {{percent .Latest}}</div>
+<svg width="600" height="100" viewBox="0 0 600 100" preserveAspectRatio="none"><polyline points="{{.Points}}"/></svg>
+</div>
+{{end}}
+<table>
+<tr><th>Commit</th><th>Suite</th><th>Lines</th><th>Conditionals</th><th>Uploaded</th><th></th></tr>
+{{range $i, $m := .History}}<tr>
+<td>{{short $m.Sha}}</td>
+<td>{{range $j, $s := $m.Suites}}{{if $j}}, {{end}}{{$s}}{{else}}{{$m.Suite}}{{end}}</td>
+<td class="number">{{percent $m.LineCoverage}}</td>
+<td class="number">{{percent $m.ConditionalCoverage}}</td>
+<td>{{time $m.Timestamp}}</td>
+<td>{{if $i}}<a href="/dashboard/compare?repository={{$.Repository}}&base={{$m.Sha}}&head={{(index $.History 0).Sha}}">compare with latest</a>{{end}}</td>
+</tr>
+{{else}}<tr><td colspan="6">No coverage recorded for {{.Branch}}</td></tr>
+{{end}}</table>
+{{template "footer"}}{{end}}
+
+{{define "compare"}}{{template "header"}}
+<h2>{{.Repository}}</h2>
+<table>
+<tr><th></th><th>Base {{short .Base.Sha}}</th><th>Head {{short .Head.Sha}}</th><th>Delta</th></tr>
+{{range .Fields}}<tr>
+<td>{{.Label}}</td>
+<td class="number">{{field $.Base .Key | percent}}</td>
+<td class="number">{{field $.Head .Key | percent}}</td>
+<td class="number">{{delta $.Base $.Head .Key}}</td>
+</tr>
+{{end}}</table>
+{{template "footer"}}{{end}}
+`))
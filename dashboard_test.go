@@ -0,0 +1,128 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package uberalls_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	"github.com/uber/uberalls/coverage"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/uber/uberalls"
+)
+
+func getDashboardResponse(url string, db *gorm.DB) *httptest.ResponseRecorder {
+	request, _ := http.NewRequest("GET", url, nil)
+	response := httptest.NewRecorder()
+	NewDashboardHandler(db, NewMetricsHandler(db, nil)).ServeHTTP(response, request)
+	return response
+}
+
+var _ = Describe("Dashboard", func() {
+	var db *gorm.DB
+
+	BeforeEach(func() {
+		c := &Config{
+			DBType:     "sqlite3",
+			DBLocation: "test.sqlite",
+		}
+		db, _ = c.DB()
+		Expect(c.Automigrate()).To(Succeed())
+
+		for _, record := range []string{
+			`{"repository": "dash/board", "sha": "aaaa", "branch": "origin/master", "lineCoverage": 40, "timestamp": 1480636700}`,
+			`{"repository": "dash/board", "sha": "bbbb", "branch": "origin/master", "lineCoverage": 45, "timestamp": 1480636760}`,
+			`{"repository": "dash/board", "sha": "cccc", "branch": "feature", "lineCoverage": 50, "timestamp": 1480636820}`,
+		} {
+			response := getMetricsResponse("POST", strings.NewReader(record), "", db)
+			Expect(response.Code).To(Equal(http.StatusOK))
+		}
+	})
+
+	It("Should list repositories", func() {
+		response := getDashboardResponse("/", db)
+		Expect(response.Code).To(Equal(http.StatusOK))
+		Expect(response.Header().Get("Content-Type")).To(HavePrefix("text/html"))
+		Expect(response.Body.String()).To(ContainSubstring("/dashboard/repository?repository=dash%2fboard"))
+	})
+
+	It("Should show a repository's branches and history", func() {
+		response := getDashboardResponse("/dashboard/repository?repository=dash/board", db)
+		Expect(response.Code).To(Equal(http.StatusOK))
+		body := response.Body.String()
+		Expect(body).To(ContainSubstring("feature"))
+		Expect(body).To(ContainSubstring("<polyline"))
+		Expect(body).To(ContainSubstring("base=aaaa&head=bbbb"))
+	})
+
+	It("Should combine the suites of every commit in the history", func() {
+		for _, record := range []string{
+			`{"repository": "dash/suites", "sha": "dddd", "branch": "origin/master", "suite": "unit", "linesCovered": 90, "linesTested": 100, "lineCoverage": 90, "timestamp": 1480636700}`,
+			`{"repository": "dash/suites", "sha": "dddd", "branch": "origin/master", "suite": "e2e", "linesCovered": 10, "linesTested": 1000, "lineCoverage": 1, "timestamp": 1480636760}`,
+		} {
+			response := getMetricsResponse("POST", strings.NewReader(record), "", db)
+			Expect(response.Code).To(Equal(http.StatusOK))
+		}
+
+		response := getDashboardResponse("/dashboard/repository?repository=dash/suites", db)
+		Expect(response.Code).To(Equal(http.StatusOK))
+		body := response.Body.String()
+		Expect(body).To(ContainSubstring("e2e, unit"))
+		Expect(body).To(ContainSubstring("90.00%"))
+		Expect(body).ToNot(ContainSubstring("1.00%"))
+
+		response = getDashboardResponse("/dashboard/repository?repository=dash/suites&suite=e2e", db)
+		Expect(response.Body.String()).To(ContainSubstring("1.00%"))
+	})
+
+	It("Should 404 for unknown repositories", func() {
+		response := getDashboardResponse("/dashboard/repository?repository=unknown", db)
+		Expect(response.Code).To(Equal(http.StatusNotFound))
+	})
+
+	It("Should compare commits", func() {
+		response := getDashboardResponse("/dashboard/compare?repository=dash/board&base=aaaa&head=cccc", db)
+		Expect(response.Code).To(Equal(http.StatusOK))
+		Expect(response.Body.String()).To(ContainSubstring("&#43;10.00%"))
+	})
+
+	It("Should 404 for unknown pages", func() {
+		response := getDashboardResponse("/nothing", db)
+		Expect(response.Code).To(Equal(http.StatusNotFound))
+	})
+
+	Context("Charting", func() {
+		chart := NewChart(coverage.MetricFields[0], []Metric{
+			{LineCoverage: 100},
+			{LineCoverage: 50},
+			{LineCoverage: 0},
+		})
+
+		It("Should plot oldest first", func() {
+			Expect(chart.Points).To(Equal("0.0,100.0 300.0,50.0 600.0,0.0"))
+			Expect(chart.Latest).To(Equal(100.))
+		})
+	})
+})
@@ -0,0 +1,98 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package uberalls
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/jinzhu/gorm"
+)
+
+// ExportMetrics writes the metrics uploaded for every repository, or for one,
+// as newline-delimited JSON in the order they were recorded, with their
+// line-level data. Component rollups are left out since importing recomputes
+// them. It returns how many metrics were written.
+func ExportMetrics(db *gorm.DB, w io.Writer, repository string) (int, error) {
+	encoder := json.NewEncoder(w)
+	count := 0
+	var after int64
+	for {
+		var metrics []Metric
+		query := db.Where("component = ?", "")
+		if repository != "" {
+			query = query.Where("repository = ?", repository)
+		}
+		if err := query.Where("id > ?", after).Order("id").Limit(maxPageSize).Find(&metrics).Error; err != nil {
+			return count, err
+		}
+
+		for _, m := range metrics {
+			files, err := loadSourceFiles(db, m.ID)
+			if err != nil {
+				return count, fmt.Errorf("metric %d: %v", m.ID, err)
+			}
+			m.SourceFiles = files
+			if err := encoder.Encode(m); err != nil {
+				return count, err
+			}
+			count++
+			after = m.ID
+		}
+		if len(metrics) < maxPageSize {
+			return count, nil
+		}
+	}
+}
+
+// ImportMetrics records metrics written by ExportMetrics, skipping those
+// already recorded for the same repository, commit, suite, component and
+// time, so an export can be imported again safely. It returns how many
+// metrics were imported and skipped.
+func ImportMetrics(mh MetricsHandler, r io.Reader) (imported, skipped int, err error) {
+	decoder := json.NewDecoder(r)
+	for {
+		m := new(Metric)
+		if err := decoder.Decode(m); err == io.EOF {
+			return imported, skipped, nil
+		} else if err != nil {
+			return imported, skipped, fmt.Errorf("metric %d: %v", imported+skipped+1, err)
+		}
+		m.ID = 0
+		m.Suites = nil
+
+		existing := new(Metric)
+		mh.db.Where("repository = ? AND sha = ? AND COALESCE(suite, '') = ? AND component = ? AND timestamp = ?",
+			m.Repository, m.Sha, m.Suite, m.Component, m.Timestamp).First(existing)
+		if existing.ID != 0 {
+			skipped++
+			continue
+		}
+
+		if err := mh.RecordMetric(m); err != nil {
+			return imported, skipped, fmt.Errorf("metric %d: %v", imported+skipped+1, err)
+		}
+		m.SourceFiles = nil
+		Audit(mh.db, nil, AuditMetricCreate, m.Repository, auditTarget("metric", m.ID), nil, m)
+		imported++
+	}
+}
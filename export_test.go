@@ -0,0 +1,151 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package uberalls_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/uber/uberalls"
+)
+
+var _ = Describe("Exporting and importing metrics", func() {
+	var (
+		source, target   *Config
+		sourceDB         *gorm.DB
+		targetDB         *gorm.DB
+		exported         bytes.Buffer
+		repository       string
+		targetComponents ComponentConfig
+	)
+
+	newDatabase := func() *Config {
+		f, _ := ioutil.TempFile("", "uberalls-export")
+		f.Close()
+		config := &Config{DBType: "sqlite3", DBLocation: f.Name()}
+		Expect(config.Automigrate()).To(Succeed())
+		return config
+	}
+
+	BeforeEach(func() {
+		repository = "export/repo"
+		source, target = newDatabase(), newDatabase()
+		sourceDB, _ = source.DB()
+		targetDB, _ = target.DB()
+		targetComponents = ComponentConfig{repository: {"api": {"api/**"}}}
+
+		mh := NewMetricsHandler(sourceDB, ComponentConfig{repository: {"all": {"**"}}})
+		Expect(mh.RecordMetric(&Metric{
+			Repository: repository,
+			Sha:        "deadbeef",
+			Branch:     "master",
+			Suite:      "unit",
+			Timestamp:  1000,
+			SourceFiles: []SourceFile{
+				{Name: "api/main.go", Coverage: hits(1, 0)},
+				{Name: "web/app.go", Coverage: hits(1, 1)},
+			},
+		})).To(Succeed())
+		Expect(mh.RecordMetric(&Metric{Repository: "other/repo", Sha: "cafe", LineCoverage: 50, Timestamp: 2000})).To(Succeed())
+
+		exported.Reset()
+		count, err := ExportMetrics(sourceDB, &exported, "")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(count).To(Equal(2))
+	})
+
+	AfterEach(func() {
+		for _, config := range []*Config{source, target} {
+			config.Close()
+			os.Remove(config.DBLocation)
+		}
+	})
+
+	It("Should write one metric per line with its line-level data", func() {
+		lines := strings.Split(strings.TrimSpace(exported.String()), "\n")
+		Expect(lines).To(HaveLen(2))
+
+		var m Metric
+		Expect(json.Unmarshal([]byte(lines[0]), &m)).To(Succeed())
+		Expect(m.Repository).To(Equal(repository))
+		Expect(m.Component).To(BeEmpty())
+		Expect(m.SourceFiles).To(HaveLen(2))
+	})
+
+	It("Should export a single repository", func() {
+		var b bytes.Buffer
+		count, err := ExportMetrics(sourceDB, &b, "other/repo")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(count).To(Equal(1))
+		Expect(b.String()).To(ContainSubstring(`"sha":"cafe"`))
+	})
+
+	It("Should import metrics with the target's components", func() {
+		mh := NewMetricsHandler(targetDB, targetComponents)
+		imported, skipped, err := ImportMetrics(mh, bytes.NewReader(exported.Bytes()))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(imported).To(Equal(2))
+		Expect(skipped).To(Equal(0))
+
+		m := mh.FindMetric(Metric{Repository: repository, Sha: "deadbeef"}, nil)
+		Expect(m).ToNot(BeNil())
+		Expect(m.Timestamp).To(BeEquivalentTo(1000))
+		Expect(m.LinesCovered).To(BeEquivalentTo(3))
+
+		rollup := mh.FindMetric(Metric{Repository: repository, Sha: "deadbeef", Component: "api"}, nil)
+		Expect(rollup).ToNot(BeNil())
+		Expect(rollup.LinesTested).To(BeEquivalentTo(2))
+		Expect(mh.FindMetric(Metric{Repository: repository, Sha: "deadbeef", Component: "all"}, nil)).To(BeNil())
+	})
+
+	It("Should skip metrics already imported", func() {
+		mh := NewMetricsHandler(targetDB, targetComponents)
+		ImportMetrics(mh, bytes.NewReader(exported.Bytes()))
+		imported, skipped, err := ImportMetrics(mh, bytes.NewReader(exported.Bytes()))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(imported).To(Equal(0))
+		Expect(skipped).To(Equal(2))
+	})
+
+	It("Should skip metrics recorded before suites existed", func() {
+		mh := NewMetricsHandler(targetDB, targetComponents)
+		ImportMetrics(mh, bytes.NewReader(exported.Bytes()))
+		Expect(targetDB.Exec("UPDATE metrics SET suite = NULL WHERE repository = ?", "other/repo").Error).ToNot(HaveOccurred())
+
+		imported, skipped, err := ImportMetrics(mh, bytes.NewReader(exported.Bytes()))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(imported).To(Equal(0))
+		Expect(skipped).To(Equal(2))
+	})
+
+	It("Should report malformed lines", func() {
+		mh := NewMetricsHandler(targetDB, targetComponents)
+		_, _, err := ImportMetrics(mh, strings.NewReader(`{"repository": "a", "sha": "b"}`+"\n{"))
+		Expect(err).To(MatchError(ContainSubstring("metric 2")))
+	})
+})
@@ -0,0 +1,393 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package uberalls
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	"github.com/uber/uberalls/coverage"
+)
+
+// FileLines holds the line-level data of a stored file, gzipped
+type FileLines struct {
+	ID             int64  `gorm:"primary_key:yes"`
+	FileCoverageID int64  `sql:"not null"`
+	Hits           []byte `sql:"not null"`
+	Source         []byte
+}
+
+// lineHits is the compressed representation of FileLines.Hits
+type lineHits struct {
+	Coverage []*int64 `json:"coverage"`
+	Branches []int64  `json:"branches,omitempty"`
+}
+
+// NewFileLines compresses a source file's line-level data for storage
+func NewFileLines(fileCoverageID int64, f SourceFile) (FileLines, error) {
+	encoded, err := json.Marshal(lineHits{Coverage: f.Coverage, Branches: f.Branches})
+	if err != nil {
+		return FileLines{}, err
+	}
+
+	fl := FileLines{FileCoverageID: fileCoverageID}
+	if fl.Hits, err = gzipBytes(encoded); err != nil {
+		return FileLines{}, err
+	}
+	if f.Source != "" {
+		if fl.Source, err = gzipBytes([]byte(f.Source)); err != nil {
+			return FileLines{}, err
+		}
+	}
+	return fl, nil
+}
+
+// SourceFile decompresses stored line-level data
+func (fl FileLines) SourceFile(path string) (SourceFile, error) {
+	encoded, err := gunzipBytes(fl.Hits)
+	if err != nil {
+		return SourceFile{}, err
+	}
+
+	hits := lineHits{}
+	if err := json.Unmarshal(encoded, &hits); err != nil {
+		return SourceFile{}, err
+	}
+
+	f := SourceFile{Name: path, Coverage: hits.Coverage, Branches: hits.Branches}
+	if len(fl.Source) > 0 {
+		source, err := gunzipBytes(fl.Source)
+		if err != nil {
+			return SourceFile{}, err
+		}
+		f.Source = string(source)
+	}
+	return f, nil
+}
+
+func gzipBytes(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	writer := gzip.NewWriter(&buf)
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func gunzipBytes(data []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+	return ioutil.ReadAll(reader)
+}
+
+// recordSourceFiles stores a summary and the line-level data of each of a
+// metric's source files
+func recordSourceFiles(db *gorm.DB, m Metric) error {
+	for _, f := range m.SourceFiles {
+		fc := NewFileCoverage(m.ID, f)
+		if err := db.Create(&fc).Error; err != nil {
+			return err
+		}
+
+		fl, err := NewFileLines(fc.ID, f)
+		if err != nil {
+			return err
+		}
+		if err := db.Create(&fl).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadSourceFiles retrieves the line-level data stored for a metric
+func loadSourceFiles(db *gorm.DB, metricID int64) ([]SourceFile, error) {
+	var files []FileCoverage
+	db.Where("metric_id = ?", metricID).Order("path").Find(&files)
+	if len(files) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]int64, 0, len(files))
+	for _, fc := range files {
+		ids = append(ids, fc.ID)
+	}
+	var lines []FileLines
+	db.Where("file_coverage_id IN (?)", ids).Find(&lines)
+
+	byFile := make(map[int64]FileLines, len(lines))
+	for _, fl := range lines {
+		byFile[fl.FileCoverageID] = fl
+	}
+
+	sourceFiles := make([]SourceFile, 0, len(files))
+	for _, fc := range files {
+		fl, ok := byFile[fc.ID]
+		if !ok {
+			return nil, fmt.Errorf("no line-level data for %s", fc.Path)
+		}
+		f, err := fl.SourceFile(fc.Path)
+		if err != nil {
+			return nil, err
+		}
+		sourceFiles = append(sourceFiles, f)
+	}
+	return sourceFiles, nil
+}
+
+// combinedSourceFiles merges the line-level data of several suites' uploads,
+// reporting false unless there are several and every one stored some
+func combinedSourceFiles(db *gorm.DB, suites []Metric) ([]SourceFile, bool, error) {
+	if len(suites) < 2 {
+		return nil, false, nil
+	}
+
+	reports := make([][]SourceFile, 0, len(suites))
+	for _, suite := range suites {
+		files, err := loadSourceFiles(db, suite.ID)
+		if err != nil || len(files) == 0 {
+			return nil, false, err
+		}
+		reports = append(reports, files)
+	}
+	return coverage.MergeSourceFiles(reports...), true, nil
+}
+
+// AnnotatedLine is a line of source with its coverage
+type AnnotatedLine struct {
+	Number          int
+	Text            string
+	Hits            string
+	Branches        string
+	Class           string
+	BranchesCovered int64
+	BranchesTotal   int64
+}
+
+// Line coverage classes used by annotated views
+const (
+	LineCovered   = "covered"
+	LinePartial   = "partial"
+	LineUncovered = "uncovered"
+)
+
+// Annotate pairs each line of a file's source with its hits, classifying it
+// as covered, partially covered (some branches never taken) or uncovered
+func Annotate(f SourceFile) []AnnotatedLine {
+	type branchTally struct{ covered, total int64 }
+	branches := make(map[int64]*branchTally)
+	for i := 0; i+3 < len(f.Branches); i += 4 {
+		tally, ok := branches[f.Branches[i]]
+		if !ok {
+			tally = new(branchTally)
+			branches[f.Branches[i]] = tally
+		}
+		tally.total++
+		if f.Branches[i+3] > 0 {
+			tally.covered++
+		}
+	}
+
+	text := strings.Split(strings.TrimSuffix(f.Source, "\n"), "\n")
+	lines := make([]AnnotatedLine, len(text))
+	for i, t := range text {
+		line := AnnotatedLine{Number: i + 1, Text: t}
+		if tally, ok := branches[int64(i+1)]; ok {
+			line.BranchesCovered = tally.covered
+			line.BranchesTotal = tally.total
+			line.Branches = fmt.Sprintf("%d/%d", tally.covered, tally.total)
+		}
+		if i < len(f.Coverage) && f.Coverage[i] != nil {
+			hits := *f.Coverage[i]
+			line.Hits = fmt.Sprint(hits)
+			switch {
+			case hits == 0:
+				line.Class = LineUncovered
+			case line.BranchesCovered < line.BranchesTotal:
+				line.Class = LinePartial
+			default:
+				line.Class = LineCovered
+			}
+		}
+		lines[i] = line
+	}
+	return lines
+}
+
+var annotatedTemplate = template.Must(template.New("annotated").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Path}} - {{.Repository}}@{{.Sha}}</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; font-family: monospace; }
+td { padding: 0 0.5em; white-space: pre; vertical-align: top; }
+td.number, td.hits, td.branches { color: #888; text-align: right; }
+tr.covered { background: #dfd; }
+tr.partial { background: #ffc; }
+tr.uncovered { background: #fdd; }
+</style>
+</head>
+<body>
+<h1>{{.Path}}</h1>
+<p>{{.Repository}} at {{.Sha}}: {{.LinesCovered}} of {{.LinesTested}} lines covered</p>
+<table>
+{{range .Lines}}<tr class="{{.Class}}"><td class="number">{{.Number}}</td><td class="hits">{{.Hits}}</td><td class="branches">{{.Branches}}</td><td>{{.Text}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
+
+// FileHandler serves the line-level coverage of a single file
+type FileHandler struct {
+	db *gorm.DB
+}
+
+// NewFileHandler creates a new FileHandler
+func NewFileHandler(db *gorm.DB) FileHandler {
+	return FileHandler{db: db}
+}
+
+// ServeHTTP handles an HTTP request for a file's line hits, as JSON or, with
+// format=html, as an annotated source view
+func (fh FileHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := r.ParseForm(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, r, "error parsing params", err)
+		return
+	}
+
+	if len(r.Form["repository"]) < 1 || len(r.Form["path"]) < 1 {
+		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, r, "missing 'repository' or 'path'", errors.New("need repository and path"))
+		return
+	}
+
+	query := coverage.ExtractMetricQuery(r.Form)
+	if !authorize(w, r, query.Repository, PermissionRead) {
+		return
+	}
+
+	m := latestMetric(fh.db, query, r.Form)
+	if m.ID == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		writeError(w, r, "no rows found", errors.New("-"))
+		return
+	}
+
+	path := coverage.CleanPath(r.Form["path"][0])
+	suites := []Metric{*m}
+	if query.Suite == "" {
+		suites = latestSuites(fh.db, *m, r.Form)
+	}
+	fc, f, err := findSourceFile(fh.db, *m, suites, path)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		writeError(w, r, "unable to decode line-level data", err)
+		return
+	}
+	if fc.Path == "" {
+		w.WriteHeader(http.StatusNotFound)
+		writeError(w, r, "no line-level data found", errors.New(path))
+		return
+	}
+
+	if len(r.Form["format"]) > 0 && r.Form["format"][0] == "html" {
+		fh.renderAnnotated(w, r, *m, fc, f)
+		return
+	}
+
+	bodyString, err := json.Marshal(f)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, r, "unable to encode response", err)
+		return
+	}
+	w.Write(bodyString)
+}
+
+// findSourceFile finds the line-level data of a file in m, or merged from
+// the uploads of several suites when /metrics combines them; the path of the
+// FileCoverage returned is empty if there is none
+func findSourceFile(db *gorm.DB, m Metric, suites []Metric, path string) (FileCoverage, SourceFile, error) {
+	merged, ok, err := combinedSourceFiles(db, suites)
+	if err != nil {
+		return FileCoverage{}, SourceFile{}, err
+	}
+	if ok {
+		for _, f := range merged {
+			if f.Name == path {
+				return NewFileCoverage(m.ID, f), f, nil
+			}
+		}
+		return FileCoverage{}, SourceFile{}, nil
+	}
+
+	fc := FileCoverage{}
+	db.Where("metric_id = ? AND path = ?", m.ID, path).First(&fc)
+	fl := FileLines{}
+	if fc.ID != 0 {
+		db.Where("file_coverage_id = ?", fc.ID).First(&fl)
+	}
+	if fl.ID == 0 {
+		return FileCoverage{}, SourceFile{}, nil
+	}
+	f, err := fl.SourceFile(path)
+	return fc, f, err
+}
+
+func (fh FileHandler) renderAnnotated(w http.ResponseWriter, r *http.Request, m Metric, fc FileCoverage, f SourceFile) {
+	if f.Source == "" {
+		w.WriteHeader(http.StatusNotFound)
+		writeError(w, r, "no source uploaded for file", errors.New(fc.Path))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	err := annotatedTemplate.Execute(w, struct {
+		Repository   string
+		Sha          string
+		Path         string
+		LinesCovered int64
+		LinesTested  int64
+		Lines        []AnnotatedLine
+	}{m.Repository, m.Sha, fc.Path, fc.LinesCovered, fc.LinesTested, Annotate(f)})
+	if err != nil {
+		logger.Error("Unable to render file", "path", fc.Path, "error", err)
+	}
+}
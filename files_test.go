@@ -0,0 +1,143 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package uberalls_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/uber/uberalls"
+)
+
+func getFileResponse(params string, db *gorm.DB) *httptest.ResponseRecorder {
+	request, _ := http.NewRequest("GET", "/metrics/file?"+params, nil)
+	response := httptest.NewRecorder()
+	NewFileHandler(db).ServeHTTP(response, request)
+	return response
+}
+
+var _ = Describe("Line-level data", func() {
+	file := SourceFile{
+		Name:     "main.go",
+		Coverage: hits(-1, 2, 0, 1),
+		Branches: []int64{2, 0, 0, 2, 2, 0, 1, 0},
+		Source:   "package main\nif x {\n\ty()\n}\n",
+	}
+
+	It("Should round-trip through storage", func() {
+		fl, err := NewFileLines(1, file)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(fl.Hits).ToNot(BeEmpty())
+
+		decoded, err := fl.SourceFile("main.go")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(decoded).To(Equal(file))
+	})
+
+	Context("Annotating source", func() {
+		lines := Annotate(file)
+
+		It("Should have one entry per line", func() {
+			Expect(lines).To(HaveLen(4))
+			Expect(lines[0].Number).To(Equal(1))
+			Expect(lines[0].Text).To(Equal("package main"))
+		})
+
+		It("Should classify lines", func() {
+			Expect(lines[0].Class).To(BeEmpty())
+			Expect(lines[1].Class).To(Equal(LinePartial))
+			Expect(lines[1].Branches).To(Equal("1/2"))
+			Expect(lines[2].Class).To(Equal(LineUncovered))
+			Expect(lines[3].Class).To(Equal(LineCovered))
+		})
+	})
+
+	Context("Served over HTTP", func() {
+		var db *gorm.DB
+
+		BeforeEach(func() {
+			c := &Config{
+				DBType:     "sqlite3",
+				DBLocation: "test.sqlite",
+			}
+			db, _ = c.DB()
+			Expect(c.Automigrate()).To(Succeed())
+
+			record := `{
+				"repository": "lines", "sha": "deadbeef",
+				"sourceFiles": [
+					{"name": "main.go", "coverage": [null, 1, 0], "source": "package main\nfunc main() {\n\t<b>\n"},
+					{"name": "util.go", "coverage": [1]}
+				]}`
+			response := getMetricsResponse("POST", strings.NewReader(record), "", db)
+			Expect(response.Code).To(Equal(http.StatusOK))
+		})
+
+		It("Should return a file's line hits", func() {
+			response := getFileResponse("repository=lines&sha=deadbeef&path=main.go", db)
+			Expect(response.Code).To(Equal(http.StatusOK))
+
+			f := SourceFile{}
+			Expect(json.NewDecoder(response.Body).Decode(&f)).To(Succeed())
+			Expect(f.Coverage).To(Equal(hits(-1, 1, 0)))
+		})
+
+		It("Should render annotated source", func() {
+			response := getFileResponse("repository=lines&sha=deadbeef&path=main.go&format=html", db)
+			Expect(response.Code).To(Equal(http.StatusOK))
+			Expect(response.Header().Get("Content-Type")).To(HavePrefix("text/html"))
+			Expect(response.Body.String()).To(ContainSubstring(`class="uncovered"`))
+			Expect(response.Body.String()).To(ContainSubstring("&lt;b&gt;"))
+		})
+
+		It("Should 404 without uploaded source", func() {
+			response := getFileResponse("repository=lines&sha=deadbeef&path=util.go&format=html", db)
+			Expect(response.Code).To(Equal(http.StatusNotFound))
+		})
+
+		It("Should merge the file's lines from every suite", func() {
+			for _, record := range []string{
+				`{"repository": "lines/suites", "sha": "deadbeef", "suite": "unit", "sourceFiles": [{"name": "main.go", "coverage": [1, 0]}]}`,
+				`{"repository": "lines/suites", "sha": "deadbeef", "suite": "e2e", "sourceFiles": [{"name": "main.go", "coverage": [0, 2]}]}`,
+			} {
+				response := getMetricsResponse("POST", strings.NewReader(record), "", db)
+				Expect(response.Code).To(Equal(http.StatusOK))
+			}
+
+			response := getFileResponse("repository=lines/suites&sha=deadbeef&path=main.go", db)
+			Expect(response.Code).To(Equal(http.StatusOK))
+			f := SourceFile{}
+			Expect(json.NewDecoder(response.Body).Decode(&f)).To(Succeed())
+			Expect(f.Coverage).To(Equal(hits(1, 2)))
+		})
+
+		It("Should 404 for unknown files", func() {
+			response := getFileResponse("repository=lines&sha=deadbeef&path=missing.go", db)
+			Expect(response.Code).To(Equal(http.StatusNotFound))
+		})
+	})
+})
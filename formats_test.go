@@ -0,0 +1,120 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package uberalls_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/uber/uberalls"
+)
+
+var _ = Describe("Config file formats", func() {
+	var dir string
+
+	writeFile := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		Expect(ioutil.WriteFile(path, []byte(content), 0600)).To(Succeed())
+		return path
+	}
+
+	BeforeEach(func() {
+		dir, _ = ioutil.TempDir("", "uberalls-formats")
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("Should detect formats by extension", func() {
+		Expect(ConfigFormat("config.yaml")).To(Equal(FormatYAML))
+		Expect(ConfigFormat("config.YML")).To(Equal(FormatYAML))
+		Expect(ConfigFormat("config.toml")).To(Equal(FormatTOML))
+		Expect(ConfigFormat("config.json")).To(Equal(FormatJSON))
+		Expect(ConfigFormat("secrets")).To(Equal(FormatJSON))
+	})
+
+	It("Should load YAML", func() {
+		path := writeFile("config.yaml", `
+dbType: sqlite3
+dbLocation: test.sqlite
+listenPort: 8080
+tls:
+  minVersion: "1.3"
+components:
+  monorepo:
+    api: ["api/**"]
+`)
+		c := &Config{}
+		_, err := LoadConfig(c, path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(c.ListenPort).To(Equal(8080))
+		Expect(c.TLS.MinVersion).To(Equal("1.3"))
+		Expect(c.Components["monorepo"]["api"]).To(Equal([]string{"api/**"}))
+	})
+
+	It("Should load TOML", func() {
+		path := writeFile("config.toml", `
+dbType = "sqlite3"
+listenPort = 8080
+
+[limits]
+ratePerSecond = 2.5
+burst = 10
+
+[oidc]
+refs = ["refs/heads/master"]
+`)
+		c := &Config{}
+		_, err := LoadConfig(c, path)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(c.ListenPort).To(Equal(8080))
+		Expect(c.Limits.RatePerSecond).To(Equal(2.5))
+		Expect(c.Limits.Burst).To(Equal(10))
+		Expect(c.OIDC.Refs).To(Equal([]string{"refs/heads/master"}))
+	})
+
+	It("Should validate YAML and TOML like JSON", func() {
+		_, err := LoadConfig(&Config{}, writeFile("bad.yaml", "listenPrt: 80\n"))
+		Expect(err).To(MatchError(ContainSubstring("listenPrt: unknown key")))
+
+		_, err = LoadConfig(&Config{}, writeFile("bad.toml", "listenPort = \"80\"\n"))
+		Expect(err).To(MatchError(ContainSubstring("listenPort: must be int, not string")))
+
+		_, err = LoadConfig(&Config{}, writeFile("broken.yaml", "tls: [\n"))
+		Expect(err).To(MatchError(ContainSubstring("broken.yaml")))
+	})
+
+	It("Should layer files of different formats", func() {
+		main := writeFile("config.yaml", "dbType: sqlite3\ndbLocation: test.sqlite\nlistenPort: 8080\n")
+		secrets := writeFile("secrets.json", `{"adminToken": "admin-secret"}`)
+
+		config, provenance, err := LoadValidated([]string{"--config", main, "--config", secrets})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(config.ListenPort).To(Equal(8080))
+		Expect(config.AdminToken).To(Equal("admin-secret"))
+		Expect(provenance["listenPort"]).To(Equal("file:" + main))
+		Expect(provenance["adminToken"]).To(Equal("file:" + secrets))
+	})
+})
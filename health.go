@@ -18,7 +18,7 @@
 // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
 // THE SOFTWARE.
 
-package main
+package uberalls
 
 import (
 	"net/http"
@@ -40,6 +40,7 @@ func NewHealthHandler(db *gorm.DB) HealthHandler {
 func (h HealthHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/plain")
 	if err := h.db.DB().Ping(); err != nil {
+		requestLogger(r).Error("Health check failed", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		w.Write([]byte(err.Error()))
 		return
@@ -0,0 +1,110 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package uberalls
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+
+	"github.com/uber/uberalls/coverage"
+)
+
+// HistoryHandler serves the coverage of the commits of a branch, newest first
+type HistoryHandler struct {
+	metrics MetricsHandler
+}
+
+// NewHistoryHandler creates a new HistoryHandler, combining suites through
+// metrics
+func NewHistoryHandler(metrics MetricsHandler) HistoryHandler {
+	return HistoryHandler{metrics: metrics}
+}
+
+// ServeHTTP handles an HTTP request for the commits a /metrics query selects,
+// newest first, up to 'limit' of them
+func (hh HistoryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := r.ParseForm(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, r, "error parsing params", err)
+		return
+	}
+
+	if len(r.Form["repository"]) < 1 {
+		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, r, "missing 'repository'", errors.New("need repository"))
+		return
+	}
+	limit, err := pageSize(r.Form)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, r, "invalid 'limit'", err)
+		return
+	}
+
+	query := coverage.ExtractMetricQuery(r.Form)
+	if !authorize(w, r, query.Repository, PermissionRead) {
+		return
+	}
+
+	history, err := hh.metrics.History(query, r.Form, limit)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		writeError(w, r, "error querying metrics", err)
+		return
+	}
+	respondWithJSON(w, r, history)
+}
+
+// historyCommit is a commit of a history and the time of its latest upload
+type historyCommit struct {
+	Sha       string
+	Timestamp int64
+}
+
+// History lists up to limit of the commits a query extracted by
+// ExtractMetricQuery selects, newest first, no later than the 'until'
+// parameter if one is given. Each commit appears once, with the metric
+// FindMetric selects for it, so its suites are combined unless the query
+// names one.
+func (mh MetricsHandler) History(query Metric, form url.Values, limit int) ([]Metric, error) {
+	var commits []historyCommit
+	dbQuery := mh.db.Model(Metric{}).Select("sha, MAX(timestamp) AS timestamp").
+		Where(&query).Where("component = ?", query.Component)
+	if len(form["until"]) > 0 {
+		dbQuery = dbQuery.Where("timestamp <= ? ", form["until"][0])
+	}
+	if err := dbQuery.Group("sha").Order("timestamp desc, sha").Limit(limit).Scan(&commits).Error; err != nil {
+		return nil, err
+	}
+
+	history := make([]Metric, 0, len(commits))
+	for _, commit := range commits {
+		commitQuery := query
+		commitQuery.Sha = commit.Sha
+		if m := mh.FindMetric(commitQuery, form); m != nil {
+			history = append(history, *m)
+		}
+	}
+	return history, nil
+}
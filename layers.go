@@ -0,0 +1,354 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package uberalls
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"unicode"
+)
+
+// Configuration layers, from lowest to highest precedence
+const (
+	LayerDefault = "default"
+	LayerFile    = "file"
+	LayerEnv     = "env"
+	LayerFlag    = "flag"
+)
+
+// envPrefix starts the name of every environment variable overriding a
+// Config field
+const envPrefix = "UBERALLS_"
+
+// redacted replaces secrets when printing configuration
+const redacted = "<redacted>"
+
+// Provenance maps each configuration key to the layer that last set it, such
+// as "file:config/default.json" or "env:UBERALLS_LISTEN_PORT"
+type Provenance map[string]string
+
+// Setting is a configurable Config field
+type Setting struct {
+	// Key is the field's dotted path in config files, like "tls.certFile"
+	Key string
+	// Env is the environment variable overriding the field
+	Env string
+	// Flag is the command-line flag overriding the field, without dashes
+	Flag string
+	// Secret settings are redacted when printed
+	Secret bool
+	// DSN settings have the password of their DSN redacted when printed
+	DSN bool
+	// Reloadable settings take effect when the configuration is reloaded;
+	// the others need a restart
+	Reloadable bool
+	path       []string
+	index      []int
+}
+
+// Settings lists every configurable field of Config
+func Settings() []Setting {
+	return appendSettings(nil, reflect.TypeOf(Config{}), nil, nil, nil)
+}
+
+func appendSettings(settings []Setting, t reflect.Type, path, words []string, index []int) []Setting {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		fieldWords := append(append([]string{}, words...), splitWords(field.Name)...)
+		name := lowerCamel(field.Name)
+		if tag := strings.Split(field.Tag.Get("json"), ",")[0]; tag != "" {
+			name = tag
+		}
+		fieldPath := append(append([]string{}, path...), name)
+		fieldIndex := append(append([]int{}, index...), i)
+
+		if field.Type.Kind() == reflect.Struct {
+			settings = appendSettings(settings, field.Type, fieldPath, fieldWords, fieldIndex)
+			continue
+		}
+		key := strings.Join(fieldPath, ".")
+		settings = append(settings, Setting{
+			Key:        key,
+			Env:        envPrefix + strings.ToUpper(strings.Join(fieldWords, "_")),
+			Flag:       strings.ToLower(strings.Join(fieldWords, "-")),
+			Secret:     field.Tag.Get("config") == "secret",
+			DSN:        field.Tag.Get("config") == "dsn",
+			Reloadable: isReloadable(fieldPath),
+			path:       fieldPath,
+			index:      fieldIndex,
+		})
+	}
+	return settings
+}
+
+// splitWords splits a Go identifier into words, keeping acronyms together:
+// "DBType" becomes "DB" and "Type"
+func splitWords(name string) []string {
+	runes := []rune(name)
+	var words []string
+	start := 0
+	for i := 1; i < len(runes); i++ {
+		lowerBefore := unicode.IsLower(runes[i-1])
+		acronymEnd := unicode.IsUpper(runes[i-1]) && i+1 < len(runes) && unicode.IsLower(runes[i+1])
+		if unicode.IsUpper(runes[i]) && (lowerBefore || acronymEnd) {
+			words = append(words, string(runes[start:i]))
+			start = i
+		}
+	}
+	return append(words, string(runes[start:]))
+}
+
+// lowerCamel converts a Go identifier to the key used in config files
+func lowerCamel(name string) string {
+	words := splitWords(name)
+	words[0] = strings.ToLower(words[0])
+	return strings.Join(words, "")
+}
+
+// field returns the setting's field of a Config
+func (s Setting) field(c *Config) reflect.Value {
+	return reflect.ValueOf(c).Elem().FieldByIndex(s.index)
+}
+
+// Set parses a value from the environment or a flag into the setting's field.
+// Lists are comma-separated; maps are given as JSON.
+func (s Setting) Set(c *Config, raw string) error {
+	v := s.field(c)
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return fmt.Errorf("'%s' is not an integer", raw)
+		}
+		v.SetInt(n)
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("'%s' is not a number", raw)
+		}
+		v.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("'%s' is not a boolean", raw)
+		}
+		v.SetBool(b)
+	case reflect.Slice:
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		v.Set(reflect.ValueOf(items))
+	default:
+		if err := json.Unmarshal([]byte(raw), v.Addr().Interface()); err != nil {
+			return errors.New(describeDecodeError(err))
+		}
+	}
+	return nil
+}
+
+// Display formats the setting's value in a Config for printing, redacting
+// secrets
+func (s Setting) Display(c *Config) string {
+	v := s.field(c)
+	if s.Secret && !v.IsZero() {
+		return redacted
+	}
+	value := v.Interface()
+	if s.DSN {
+		value = redactDSN(v.String())
+	}
+
+	var b strings.Builder
+	encoder := json.NewEncoder(&b)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(value); err != nil {
+		return fmt.Sprint(value)
+	}
+	return strings.TrimSpace(b.String())
+}
+
+var dsnPassword = regexp.MustCompile(`^([^:@/]*):[^@]*@`)
+
+// redactDSN hides the password of a "user:password@..." DSN
+func redactDSN(dsn string) string {
+	return dsnPassword.ReplaceAllString(dsn, "${1}:"+redacted+"@")
+}
+
+// stringsFlag collects a repeatable flag
+type stringsFlag []string
+
+func (sf *stringsFlag) String() string { return strings.Join(*sf, ",") }
+
+func (sf *stringsFlag) Set(value string) error {
+	*sf = append(*sf, value)
+	return nil
+}
+
+// rawFlag remembers the last value given to a setting's flag
+type rawFlag struct {
+	values map[string]string
+	key    string
+	bool   bool
+}
+
+func (rf rawFlag) String() string { return "" }
+
+// IsBoolFlag lets boolean settings be set by a bare flag
+func (rf rawFlag) IsBoolFlag() bool { return rf.bool }
+
+func (rf rawFlag) Set(value string) error {
+	rf.values[rf.key] = value
+	return nil
+}
+
+// parseArgs parses command-line flags into the config files they name and the
+// raw values of the settings they set
+func parseArgs(args []string) ([]string, map[string]string, error) {
+	flags := flag.NewFlagSet("uberalls", flag.ContinueOnError)
+	flags.SetOutput(ioutil.Discard)
+	return parseFlags(flags, args)
+}
+
+// parseFlags adds --config and a flag for every setting to flags, which may
+// define flags of its own, then parses args like parseArgs
+func parseFlags(flags *flag.FlagSet, args []string) ([]string, map[string]string, error) {
+	var configPaths stringsFlag
+	flags.Var(&configPaths, "config", "load a config file; may be repeated")
+	flagValues := map[string]string{}
+	for _, s := range Settings() {
+		isBool := s.field(&Config{}).Kind() == reflect.Bool
+		flags.Var(rawFlag{flagValues, s.Key, isBool}, s.Flag, "sets "+s.Key)
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	if flags.NArg() > 0 {
+		return nil, nil, fmt.Errorf("unexpected argument '%s'", flags.Arg(0))
+	}
+	return configPaths, flagValues, nil
+}
+
+// ConfigFiles returns the config files loaded with args: those named by
+// --config flags, or by UBERALLS_CONFIG and UBERALLS_SECRETS, or DefaultConfig
+func ConfigFiles(args []string) ([]string, error) {
+	paths, _, err := parseArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	return configFilesOr(paths), nil
+}
+
+// configFilesOr returns paths, or the config files to load without --config
+// flags
+func configFilesOr(paths []string) []string {
+	if len(paths) == 0 {
+		paths = GetLocationsFromEnvironment()
+	}
+	if len(paths) == 0 {
+		paths = []string{DefaultConfig}
+	}
+	return paths
+}
+
+// LoadLayers builds the effective configuration from defaults, then the
+// config files named by ConfigFiles, then UBERALLS_* environment variables,
+// then command-line flags. Problems with any layer are returned together as
+// ConfigErrors, alongside the configuration loaded despite them.
+func LoadLayers(args []string) (*Config, Provenance, error) {
+	paths, flagValues, err := parseArgs(args)
+	if err != nil {
+		return nil, nil, err
+	}
+	return loadLayers(configFilesOr(paths), flagValues)
+}
+
+// loadLayers loads the configuration from files and flag values parsed by
+// parseArgs
+func loadLayers(paths []string, flagValues map[string]string) (*Config, Provenance, error) {
+	settings := Settings()
+	config := &Config{}
+	provenance := Provenance{}
+	for _, s := range settings {
+		provenance[s.Key] = LayerDefault
+	}
+
+	var errs ConfigErrors
+	for _, path := range paths {
+		logger.Info("Loading configuration", "path", path)
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			errs = append(errs, ConfigError{LayerFile + ":" + path, "", err.Error()})
+			continue
+		}
+		errs = append(errs, decodeFile(config, path, content, provenance)...)
+	}
+
+	for _, s := range settings {
+		if raw, ok := os.LookupEnv(s.Env); ok {
+			source := LayerEnv + ":" + s.Env
+			if err := s.Set(config, raw); err != nil {
+				errs = append(errs, ConfigError{source, s.Key, err.Error()})
+				continue
+			}
+			provenance[s.Key] = source
+		}
+	}
+
+	for _, s := range settings {
+		if raw, ok := flagValues[s.Key]; ok {
+			source := LayerFlag + ":--" + s.Flag
+			if err := s.Set(config, raw); err != nil {
+				errs = append(errs, ConfigError{source, s.Key, err.Error()})
+				continue
+			}
+			provenance[s.Key] = source
+		}
+	}
+	return config, provenance, errs.orNil()
+}
+
+// PrintConfig writes every setting of the effective configuration with the
+// layer it came from, redacting secrets
+func PrintConfig(w io.Writer, c *Config, provenance Provenance) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, s := range Settings() {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", s.Key, s.Display(c), provenance[s.Key])
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,142 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package uberalls_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/uber/uberalls"
+)
+
+var _ = Describe("Configuration layers", func() {
+	var (
+		file    string
+		cleanup []string
+	)
+
+	setenv := func(key, value string) {
+		os.Setenv(key, value)
+		cleanup = append(cleanup, key)
+	}
+
+	BeforeEach(func() {
+		f, _ := ioutil.TempFile("", "uberalls-config")
+		f.WriteString(`{
+			"dbType": "mysql",
+			"dbLocation": "user:hunter2@/coverage",
+			"listenPort": 8080,
+			"adminToken": "admin-secret",
+			"tls": {"certFile": "/etc/server.pem"}
+		}`)
+		f.Close()
+		file = f.Name()
+		cleanup = nil
+	})
+
+	AfterEach(func() {
+		os.Remove(file)
+		for _, key := range cleanup {
+			os.Unsetenv(key)
+		}
+	})
+
+	It("Should name settings after their fields", func() {
+		keys := map[string]Setting{}
+		for _, s := range Settings() {
+			keys[s.Key] = s
+		}
+		Expect(keys).To(HaveKey("dbType"))
+		Expect(keys["dbType"].Env).To(Equal("UBERALLS_DB_TYPE"))
+		Expect(keys["dbType"].Flag).To(Equal("db-type"))
+		Expect(keys["tls.clientCAFile"].Env).To(Equal("UBERALLS_TLS_CLIENT_CA_FILE"))
+		Expect(keys["limits.ratePerSecond"].Flag).To(Equal("limits-rate-per-second"))
+		Expect(keys["adminToken"].Secret).To(BeTrue())
+	})
+
+	It("Should load config files", func() {
+		config, provenance, err := LoadLayers([]string{"--config", file})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(config.DBType).To(Equal("mysql"))
+		Expect(config.TLS.CertFile).To(Equal("/etc/server.pem"))
+		Expect(provenance["dbType"]).To(Equal("file:" + file))
+		Expect(provenance["tls.certFile"]).To(Equal("file:" + file))
+		Expect(provenance["tls.keyFile"]).To(Equal(LayerDefault))
+	})
+
+	It("Should fall back to the default config file", func() {
+		config, provenance, err := LoadLayers(nil)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(config.DBType).To(Equal("sqlite3"))
+		Expect(provenance["dbType"]).To(Equal("file:" + DefaultConfig))
+	})
+
+	It("Should override files with the environment", func() {
+		setenv("UBERALLS_LISTEN_PORT", "9090")
+		setenv("UBERALLS_OIDC_REFS", "refs/heads/master, refs/tags/*")
+		setenv("UBERALLS_COMPONENTS", `{"monorepo": {"api": ["api/**"]}}`)
+
+		config, provenance, err := LoadLayers([]string{"--config", file})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(config.ListenPort).To(Equal(9090))
+		Expect(config.OIDC.Refs).To(Equal([]string{"refs/heads/master", "refs/tags/*"}))
+		Expect(config.Components["monorepo"]["api"]).To(Equal([]string{"api/**"}))
+		Expect(provenance["listenPort"]).To(Equal("env:UBERALLS_LISTEN_PORT"))
+	})
+
+	It("Should override the environment with flags", func() {
+		setenv("UBERALLS_LISTEN_PORT", "9090")
+
+		config, provenance, err := LoadLayers([]string{"--config", file, "--listen-port", "7070", "--auth-required"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(config.ListenPort).To(Equal(7070))
+		Expect(config.AuthRequired).To(BeTrue())
+		Expect(provenance["listenPort"]).To(Equal("flag:--listen-port"))
+	})
+
+	It("Should reject invalid values", func() {
+		setenv("UBERALLS_LISTEN_PORT", "eighty")
+		_, _, err := LoadLayers([]string{"--config", file})
+		Expect(err).To(MatchError(ContainSubstring("UBERALLS_LISTEN_PORT")))
+	})
+
+	It("Should reject unknown flags", func() {
+		_, _, err := LoadLayers([]string{"--listen-prot", "80"})
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Should print the effective configuration without secrets", func() {
+		config, provenance, err := LoadLayers([]string{"--config", file, "--db-type", "mysql"})
+		Expect(err).ToNot(HaveOccurred())
+
+		out := new(bytes.Buffer)
+		Expect(PrintConfig(out, config, provenance)).To(Succeed())
+		Expect(out.String()).To(MatchRegexp(`dbType\s+"mysql"\s+flag:--db-type`))
+		Expect(out.String()).To(MatchRegexp(`listenPort\s+8080\s+file:`))
+		Expect(out.String()).To(MatchRegexp(`adminToken\s+<redacted>`))
+		Expect(out.String()).To(ContainSubstring(`"user:<redacted>@/coverage"`))
+		Expect(out.String()).ToNot(ContainSubstring("admin-secret"))
+		Expect(out.String()).ToNot(ContainSubstring("hunter2"))
+	})
+})
@@ -0,0 +1,266 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package uberalls
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// DefaultMaxBodySize limits request bodies of content types without a
+// configured limit
+const DefaultMaxBodySize = 32 << 20
+
+// maxBuckets is how many rate limiting buckets are kept. Refilled ones are
+// discarded first, then those of the clients seen least recently.
+const maxBuckets = 10000
+
+// LimitsConfig configures request body size limits and rate limiting
+type LimitsConfig struct {
+	// MaxBodySize maps content types, like "application/json", to the
+	// largest body accepted in bytes; "*" applies to all other types
+	MaxBodySize map[string]int64
+	// RatePerSecond is how many requests each verified token, or each
+	// client IP otherwise, may make per second on average; 0 disables rate
+	// limiting
+	RatePerSecond float64
+	// Burst is how many requests may be made at once, at least 1
+	Burst int
+}
+
+var errBodyTooLarge = errors.New("request body too large")
+
+// decodeStatus returns the HTTP status for an error decoding a request body
+func decodeStatus(err error) int {
+	if err == errBodyTooLarge {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// limitedBody fails reads past a limit with errBodyTooLarge
+type limitedBody struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (lb *limitedBody) Read(p []byte) (int, error) {
+	if lb.remaining < 0 {
+		return 0, errBodyTooLarge
+	}
+	if int64(len(p)) > lb.remaining+1 {
+		p = p[:lb.remaining+1]
+	}
+	n, err := lb.ReadCloser.Read(p)
+	lb.remaining -= int64(n)
+	if lb.remaining < 0 {
+		return n, errBodyTooLarge
+	}
+	return n, err
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter enforces body size limits and rate limits on requests
+type Limiter struct {
+	now func() time.Time
+
+	lock    *sync.Mutex
+	config  *LimitsConfig
+	buckets map[string]*bucket
+}
+
+// NewLimiter creates a Limiter from configuration
+func NewLimiter(config LimitsConfig) Limiter {
+	l := Limiter{
+		now:     time.Now,
+		lock:    new(sync.Mutex),
+		config:  new(LimitsConfig),
+		buckets: map[string]*bucket{},
+	}
+	l.Update(config)
+	return l
+}
+
+// Update replaces the limiter's configuration. Clients start again with full
+// buckets.
+func (l Limiter) Update(config LimitsConfig) {
+	if config.Burst < 1 {
+		config.Burst = 1
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	*l.config = config
+	for key := range l.buckets {
+		delete(l.buckets, key)
+	}
+}
+
+// Wrap limits requests before they are authenticated: requests without a
+// token spend from their client IP's bucket, and bodies are held to their
+// size limit. Requests with a token are charged once Auth.Wrap has checked
+// it: to the token's bucket by WrapPrincipal if it is valid, or to the client
+// IP's if not, so made-up tokens don't earn a client more requests.
+func (l Limiter) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requestSecret(r) == "" {
+			if wait := l.Take(ipKey(r)); wait > 0 {
+				tooManyRequests(w, r, wait)
+				return
+			}
+		}
+
+		if r.Body != nil {
+			limit := l.MaxBodySize(r.Header.Get("Content-Type"))
+			if r.ContentLength > limit {
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusRequestEntityTooLarge)
+				writeError(w, r, "request body too large", errors.New("limit is "+strconv.FormatInt(limit, 10)+" bytes"))
+				return
+			}
+			r.Body = &limitedBody{ReadCloser: r.Body, remaining: limit}
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), limiterContextKey{}, l)))
+	})
+}
+
+type limiterContextKey struct{}
+
+// takeUnauthenticated spends from the client IP's bucket of a request whose
+// token failed to verify, if it went through Wrap, returning how long to
+// wait before retrying if the bucket is empty
+func takeUnauthenticated(r *http.Request) time.Duration {
+	if l, ok := r.Context().Value(limiterContextKey{}).(Limiter); ok {
+		return l.Take(ipKey(r))
+	}
+	return 0
+}
+
+// WrapPrincipal limits authenticated requests by their token instead of
+// their client IP, so clients sharing an address don't share a bucket. It
+// goes between Auth.Wrap and the handler, inside Wrap.
+func (l Limiter) WrapPrincipal(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requestPrincipal(r) != nil {
+			if wait := l.Take("token:" + HashSecret(requestSecret(r))); wait > 0 {
+				tooManyRequests(w, r, wait)
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func tooManyRequests(w http.ResponseWriter, r *http.Request, wait time.Duration) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	w.WriteHeader(http.StatusTooManyRequests)
+	writeError(w, r, "rate limit exceeded", errors.New("retry after "+wait.String()))
+}
+
+// MaxBodySize returns the body size limit of a content type
+func (l Limiter) MaxBodySize(contentType string) int64 {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		if limit, ok := l.config.MaxBodySize[mediaType]; ok {
+			return limit
+		}
+	}
+	if limit, ok := l.config.MaxBodySize["*"]; ok {
+		return limit
+	}
+	return DefaultMaxBodySize
+}
+
+// Take spends a request from key's bucket, returning how long to wait before
+// retrying if the bucket is empty
+func (l Limiter) Take(key string) time.Duration {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if l.config.RatePerSecond <= 0 {
+		return 0
+	}
+
+	now := l.now()
+	burst := float64(l.config.Burst)
+	b, ok := l.buckets[key]
+	if !ok {
+		if len(l.buckets) >= maxBuckets {
+			l.discardFullBuckets(now)
+		}
+		if len(l.buckets) >= maxBuckets {
+			l.discardOldestBucket()
+		}
+		b = &bucket{tokens: burst, last: now}
+		l.buckets[key] = b
+	}
+
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*l.config.RatePerSecond)
+	b.last = now
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / l.config.RatePerSecond * float64(time.Second))
+	}
+	b.tokens--
+	return 0
+}
+
+// discardFullBuckets forgets clients whose buckets have refilled, which are
+// indistinguishable from new ones
+func (l Limiter) discardFullBuckets(now time.Time) {
+	refill := time.Duration(float64(l.config.Burst) / l.config.RatePerSecond * float64(time.Second))
+	for key, b := range l.buckets {
+		if now.Sub(b.last) >= refill {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+// discardOldestBucket forgets the client seen least recently, so that the
+// buckets stay bounded even when every client is active
+func (l Limiter) discardOldestBucket() {
+	var oldest string
+	var last time.Time
+	for key, b := range l.buckets {
+		if oldest == "" || b.last.Before(last) {
+			oldest, last = key, b.last
+		}
+	}
+	delete(l.buckets, oldest)
+}
+
+// ipKey identifies the client of a request by its IP address
+func ipKey(r *http.Request) string {
+	return "ip:" + sourceIP(r)
+}
@@ -0,0 +1,169 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package uberalls_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/uber/uberalls"
+)
+
+var _ = Describe("Limits", func() {
+	var (
+		config  LimitsConfig
+		handler http.Handler
+	)
+
+	send := func(body, contentType, secret, remoteAddr string) *httptest.ResponseRecorder {
+		request, _ := http.NewRequest("POST", "/metrics", strings.NewReader(body))
+		request.Header.Set("Content-Type", contentType)
+		if secret != "" {
+			request.Header.Set("Authorization", "Bearer "+secret)
+		}
+		request.RemoteAddr = remoteAddr
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		return response
+	}
+
+	BeforeEach(func() {
+		config = LimitsConfig{
+			MaxBodySize: map[string]int64{
+				"application/json": 64,
+				"*":                16,
+			},
+		}
+	})
+
+	JustBeforeEach(func() {
+		handler = NewLimiter(config).Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, err := ioutil.ReadAll(r.Body); err != nil {
+				w.WriteHeader(http.StatusRequestEntityTooLarge)
+			}
+		}))
+	})
+
+	It("Should look up limits by content type", func() {
+		limiter := NewLimiter(config)
+		Expect(limiter.MaxBodySize("application/json; charset=utf-8")).To(BeEquivalentTo(64))
+		Expect(limiter.MaxBodySize("text/plain")).To(BeEquivalentTo(16))
+		Expect(NewLimiter(LimitsConfig{}).MaxBodySize("application/json")).To(BeEquivalentTo(DefaultMaxBodySize))
+	})
+
+	It("Should accept bodies within the limit", func() {
+		response := send(strings.Repeat("x", 64), "application/json", "", "10.0.0.1:1")
+		Expect(response.Code).To(Equal(http.StatusOK))
+	})
+
+	It("Should reject declared bodies over the limit", func() {
+		response := send(strings.Repeat("x", 17), "text/plain", "", "10.0.0.1:1")
+		Expect(response.Code).To(Equal(http.StatusRequestEntityTooLarge))
+	})
+
+	It("Should stop reading undeclared bodies over the limit", func() {
+		request, _ := http.NewRequest("POST", "/metrics", ioutil.NopCloser(strings.NewReader(strings.Repeat("x", 65))))
+		request.Header.Set("Content-Type", "application/json")
+		request.ContentLength = -1
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		Expect(response.Code).To(Equal(http.StatusRequestEntityTooLarge))
+	})
+
+	It("Should not rate limit by default", func() {
+		for i := 0; i < 10; i++ {
+			Expect(send("{}", "application/json", "", "10.0.0.1:1").Code).To(Equal(http.StatusOK))
+		}
+	})
+
+	Context("With a rate limit", func() {
+		BeforeEach(func() {
+			config.RatePerSecond = 0.5
+			config.Burst = 2
+		})
+
+		It("Should allow bursts and then ask clients to retry", func() {
+			Expect(send("{}", "application/json", "", "10.0.0.1:1").Code).To(Equal(http.StatusOK))
+			Expect(send("{}", "application/json", "", "10.0.0.1:2").Code).To(Equal(http.StatusOK))
+
+			response := send("{}", "application/json", "", "10.0.0.1:3")
+			Expect(response.Code).To(Equal(http.StatusTooManyRequests))
+			Expect(response.Header().Get("Retry-After")).To(Equal("2"))
+		})
+
+		It("Should limit each client separately", func() {
+			send("{}", "application/json", "", "10.0.0.1:1")
+			send("{}", "application/json", "", "10.0.0.1:1")
+			Expect(send("{}", "application/json", "", "10.0.0.2:1").Code).To(Equal(http.StatusOK))
+		})
+
+		Context("Behind authentication", func() {
+			JustBeforeEach(func() {
+				db, _ := (&Config{DBType: "sqlite3", DBLocation: "test.sqlite"}).DB()
+				limiter := NewLimiter(config)
+				auth := NewAuth(db, &Config{AdminToken: "secret"})
+				handler = limiter.Wrap(auth.Wrap(limiter.WrapPrincipal(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))))
+			})
+
+			It("Should limit tokens rather than addresses", func() {
+				send("{}", "application/json", "secret", "10.0.0.1:1")
+				send("{}", "application/json", "secret", "10.0.0.2:1")
+				Expect(send("{}", "application/json", "secret", "10.0.0.3:1").Code).To(Equal(http.StatusTooManyRequests))
+				Expect(send("{}", "application/json", "", "10.0.0.1:1").Code).To(Equal(http.StatusOK))
+			})
+
+			It("Should not charge addresses for valid tokens", func() {
+				send("{}", "application/json", "", "10.0.0.1:1")
+				send("{}", "application/json", "", "10.0.0.1:1")
+				Expect(send("{}", "application/json", "", "10.0.0.1:1").Code).To(Equal(http.StatusTooManyRequests))
+				Expect(send("{}", "application/json", "secret", "10.0.0.1:1").Code).To(Equal(http.StatusOK))
+			})
+
+			It("Should limit invalid tokens by address", func() {
+				var codes []int
+				for i := 0; i < 50; i++ {
+					codes = append(codes, send("{}", "application/json", fmt.Sprintf("made-up-%d", i), "10.0.0.1:1").Code)
+				}
+				Expect(codes[:2]).To(Equal([]int{http.StatusUnauthorized, http.StatusUnauthorized}))
+				for _, code := range codes[2:] {
+					Expect(code).To(Equal(http.StatusTooManyRequests))
+				}
+				Expect(send("{}", "application/json", "secret", "10.0.0.2:1").Code).To(Equal(http.StatusOK))
+			})
+		})
+	})
+
+	It("Should reject oversized metrics with 413", func() {
+		db, _ := (&Config{DBType: "sqlite3", DBLocation: "test.sqlite"}).DB()
+		handler = NewLimiter(config).Wrap(NewMetricsHandler(db, nil))
+		request, _ := http.NewRequest("POST", "/metrics", ioutil.NopCloser(strings.NewReader(`{"repository": "`+strings.Repeat("x", 100)+`"}`)))
+		request.Header.Set("Content-Type", "application/json")
+		request.ContentLength = -1
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		Expect(response.Code).To(Equal(http.StatusRequestEntityTooLarge))
+	})
+})
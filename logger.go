@@ -0,0 +1,307 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package uberalls
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Log formats
+const (
+	LogFormatLogfmt = "logfmt"
+	LogFormatJSON   = "json"
+)
+
+// Log levels, from most to least verbose
+const (
+	LevelDebug = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+// LogConfig configures logging
+type LogConfig struct {
+	// Format is "logfmt" (the default) or "json"
+	Format string
+	// Level is the least severe level logged: "debug", "info" (the
+	// default), "warn" or "error"
+	Level string
+}
+
+// Logger writes structured log lines of a message and key-value pairs
+type Logger struct {
+	fields []interface{}
+	core   *loggerCore
+}
+
+// loggerCore is the output and settings shared by a Logger and those derived
+// from it with With, so reconfiguring one reconfigures them all
+type loggerCore struct {
+	lock  *sync.Mutex
+	out   io.Writer
+	json  bool
+	level int
+}
+
+// logger is the process-wide logger, reconfigured by ConfigureLogging
+var logger = &Logger{core: &loggerCore{lock: new(sync.Mutex), out: os.Stderr, level: LevelInfo}}
+
+// NewLogger creates a Logger writing to out
+func NewLogger(out io.Writer, config LogConfig) (*Logger, error) {
+	l := &Logger{core: &loggerCore{lock: new(sync.Mutex), out: out, level: LevelInfo}}
+	if err := l.Configure(config); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// Configure changes the format and level of the logger and of every logger
+// derived from it, leaving them unchanged if config is invalid
+func (l *Logger) Configure(config LogConfig) error {
+	var json bool
+	switch config.Format {
+	case "", LogFormatLogfmt:
+	case LogFormatJSON:
+		json = true
+	default:
+		return fmt.Errorf("unknown log format '%s'", config.Format)
+	}
+
+	level := LevelInfo
+	if config.Level != "" {
+		level = -1
+		for i, name := range levelNames {
+			if strings.EqualFold(config.Level, name) {
+				level = i
+			}
+		}
+		if level < 0 {
+			return fmt.Errorf("unknown log level '%s'", config.Level)
+		}
+	}
+
+	l.core.lock.Lock()
+	defer l.core.lock.Unlock()
+	l.core.json = json
+	l.core.level = level
+	return nil
+}
+
+// ConfigureLogging changes the format and level of the process-wide logger
+func ConfigureLogging(config LogConfig) error {
+	return logger.Configure(config)
+}
+
+// SetLogger replaces the process-wide logger, returning the previous one
+func SetLogger(l *Logger) *Logger {
+	previous := logger
+	logger = l
+	return previous
+}
+
+// With returns a Logger adding key-value pairs to every line
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(keyvals))
+	fields = append(append(fields, l.fields...), keyvals...)
+	return &Logger{fields: fields, core: l.core}
+}
+
+// Debug logs a message at debug level
+func (l *Logger) Debug(msg string, keyvals ...interface{}) { l.log(LevelDebug, msg, keyvals) }
+
+// Info logs a message at info level
+func (l *Logger) Info(msg string, keyvals ...interface{}) { l.log(LevelInfo, msg, keyvals) }
+
+// Warn logs a message at warn level
+func (l *Logger) Warn(msg string, keyvals ...interface{}) { l.log(LevelWarn, msg, keyvals) }
+
+// Error logs a message at error level
+func (l *Logger) Error(msg string, keyvals ...interface{}) { l.log(LevelError, msg, keyvals) }
+
+// Fatal logs a message at error level and exits
+func (l *Logger) Fatal(msg string, keyvals ...interface{}) {
+	l.log(LevelError, msg, keyvals)
+	os.Exit(1)
+}
+
+func (l *Logger) log(level int, msg string, keyvals []interface{}) {
+	l.core.lock.Lock()
+	defer l.core.lock.Unlock()
+	if level < l.core.level {
+		return
+	}
+
+	pairs := []interface{}{
+		"time", time.Now().UTC().Format(time.RFC3339Nano),
+		"level", levelNames[level],
+		"msg", msg,
+	}
+	pairs = append(append(pairs, l.fields...), keyvals...)
+	if len(pairs)%2 != 0 {
+		pairs = append(pairs, "(missing)")
+	}
+
+	var line string
+	if l.core.json {
+		line = formatJSON(pairs)
+	} else {
+		line = formatLogfmt(pairs)
+	}
+	io.WriteString(l.core.out, line+"\n")
+}
+
+// logValue converts a value to something that encodes well in a log line
+func logValue(v interface{}) interface{} {
+	switch value := v.(type) {
+	case error:
+		return value.Error()
+	case time.Duration:
+		return value.String()
+	case fmt.Stringer:
+		return value.String()
+	}
+	return v
+}
+
+func formatJSON(pairs []interface{}) string {
+	var b strings.Builder
+	b.WriteString("{")
+	for i := 0; i < len(pairs); i += 2 {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		key, _ := json.Marshal(fmt.Sprint(pairs[i]))
+		value, err := json.Marshal(logValue(pairs[i+1]))
+		if err != nil {
+			value, _ = json.Marshal(fmt.Sprint(pairs[i+1]))
+		}
+		b.Write(key)
+		b.WriteString(":")
+		b.Write(value)
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+func formatLogfmt(pairs []interface{}) string {
+	fields := make([]string, 0, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		value := fmt.Sprint(logValue(pairs[i+1]))
+		if value == "" || strings.ContainsAny(value, " =\"\t\n") {
+			value = strconv.Quote(value)
+		}
+		fields = append(fields, fmt.Sprint(pairs[i])+"="+value)
+	}
+	return strings.Join(fields, " ")
+}
+
+type requestLogKey struct{}
+
+// requestLog collects what is logged about a request once it is served
+type requestLog struct {
+	id         string
+	repository string
+}
+
+// requestLogger returns a Logger tagging lines with the request's ID
+func requestLogger(r *http.Request) *Logger {
+	if info, ok := r.Context().Value(requestLogKey{}).(*requestLog); ok {
+		return logger.With("request_id", info.id)
+	}
+	return logger
+}
+
+// noteRepository records the repository a request acts on for its log line
+func noteRepository(r *http.Request, repository string) {
+	if info, ok := r.Context().Value(requestLogKey{}).(*requestLog); ok && repository != "" {
+		info.repository = repository
+	}
+}
+
+// statusRecorder remembers the status code written to a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	sr.status = status
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+// Flush sends buffered data to the client, so streamed responses like NDJSON
+// aren't held back by logging
+func (sr *statusRecorder) Flush() {
+	if flusher, ok := sr.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// newRequestID generates a random request ID
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// LogRequests assigns each request an X-Request-ID, keeping one the client
+// sent, and logs every request once it is served
+func LogRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get("X-Request-ID")
+		if id == "" || len(id) > 128 {
+			id = newRequestID()
+			r.Header.Set("X-Request-ID", id)
+		}
+		w.Header().Set("X-Request-ID", id)
+
+		info := &requestLog{id: id}
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(recorder, r.WithContext(context.WithValue(r.Context(), requestLogKey{}, info)))
+
+		keyvals := []interface{}{
+			"request_id", id,
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", recorder.status,
+			"latency_ms", time.Since(start).Seconds() * 1000,
+		}
+		if info.repository != "" {
+			keyvals = append(keyvals, "repository", info.repository)
+		}
+		logger.Info("request", keyvals...)
+	})
+}
@@ -0,0 +1,155 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package uberalls_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/uber/uberalls"
+)
+
+var _ = Describe("Logging", func() {
+	var out *bytes.Buffer
+
+	BeforeEach(func() {
+		out = new(bytes.Buffer)
+	})
+
+	It("Should write logfmt by default", func() {
+		l, err := NewLogger(out, LogConfig{})
+		Expect(err).ToNot(HaveOccurred())
+		l.Info("Recording metric", "repository", "uber/uberalls", "error", errors.New("bad input"))
+		Expect(out.String()).To(ContainSubstring(`level=info msg="Recording metric" repository=uber/uberalls error="bad input"`))
+	})
+
+	It("Should write JSON", func() {
+		l, err := NewLogger(out, LogConfig{Format: LogFormatJSON})
+		Expect(err).ToNot(HaveOccurred())
+		l.With("request_id", "abc").Warn("Slow", "latency_ms", 12.5)
+
+		line := map[string]interface{}{}
+		Expect(json.Unmarshal(out.Bytes(), &line)).To(Succeed())
+		Expect(line).To(HaveKeyWithValue("level", "warn"))
+		Expect(line).To(HaveKeyWithValue("msg", "Slow"))
+		Expect(line).To(HaveKeyWithValue("request_id", "abc"))
+		Expect(line).To(HaveKeyWithValue("latency_ms", 12.5))
+	})
+
+	It("Should skip levels below the configured one", func() {
+		l, err := NewLogger(out, LogConfig{Level: "warn"})
+		Expect(err).ToNot(HaveOccurred())
+		l.Info("hidden")
+		l.Error("shown")
+		Expect(out.String()).ToNot(ContainSubstring("hidden"))
+		Expect(out.String()).To(ContainSubstring("shown"))
+	})
+
+	It("Should reject unknown formats and levels", func() {
+		_, err := NewLogger(out, LogConfig{Format: "xml"})
+		Expect(err).To(HaveOccurred())
+		_, err = NewLogger(out, LogConfig{Level: "loud"})
+		Expect(err).To(HaveOccurred())
+	})
+
+	Context("Logging requests", func() {
+		var (
+			previous *Logger
+			handler  http.Handler
+		)
+
+		BeforeEach(func() {
+			l, _ := NewLogger(out, LogConfig{Format: LogFormatJSON})
+			previous = SetLogger(l)
+			db, _ := (&Config{DBType: "sqlite3", DBLocation: "test.sqlite"}).DB()
+			handler = LogRequests(NewMetricsHandler(db, nil))
+		})
+
+		AfterEach(func() {
+			SetLogger(previous)
+		})
+
+		lastLine := func() map[string]interface{} {
+			lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+			line := map[string]interface{}{}
+			Expect(json.Unmarshal([]byte(lines[len(lines)-1]), &line)).To(Succeed())
+			return line
+		}
+
+		It("Should assign a request ID", func() {
+			request, _ := http.NewRequest("GET", "/metrics?repository=logged/repo", nil)
+			response := httptest.NewRecorder()
+			handler.ServeHTTP(response, request)
+
+			id := response.Header().Get("X-Request-ID")
+			Expect(id).ToNot(BeEmpty())
+
+			line := lastLine()
+			Expect(line).To(HaveKeyWithValue("request_id", id))
+			Expect(line).To(HaveKeyWithValue("method", "GET"))
+			Expect(line).To(HaveKeyWithValue("path", "/metrics"))
+			Expect(line).To(HaveKeyWithValue("status", BeNumerically("==", http.StatusNotFound)))
+			Expect(line).To(HaveKeyWithValue("repository", "logged/repo"))
+			Expect(line).To(HaveKey("latency_ms"))
+		})
+
+		It("Should log errors with the request ID", func() {
+			request, _ := http.NewRequest("GET", "/metrics?repository=logged/repo", nil)
+			request.Header.Set("X-Request-ID", "failing")
+			handler.ServeHTTP(httptest.NewRecorder(), request)
+
+			lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+			line := map[string]interface{}{}
+			Expect(json.Unmarshal([]byte(lines[len(lines)-2]), &line)).To(Succeed())
+			Expect(line).To(HaveKeyWithValue("msg", "no rows found: -"))
+			Expect(line).To(HaveKeyWithValue("request_id", "failing"))
+			Expect(line).ToNot(HaveKey("error"))
+		})
+
+		It("Should propagate a client's request ID", func() {
+			request, _ := http.NewRequest("GET", "/metrics?repository=logged/repo", nil)
+			request.Header.Set("X-Request-ID", "from-client")
+			response := httptest.NewRecorder()
+			handler.ServeHTTP(response, request)
+
+			Expect(response.Header().Get("X-Request-ID")).To(Equal("from-client"))
+			Expect(lastLine()).To(HaveKeyWithValue("request_id", "from-client"))
+		})
+
+		It("Should flush streamed responses", func() {
+			streaming := LogRequests(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				flusher, ok := w.(http.Flusher)
+				Expect(ok).To(BeTrue())
+				w.Write([]byte("{}\n"))
+				flusher.Flush()
+			}))
+			response := httptest.NewRecorder()
+			streaming.ServeHTTP(response, httptest.NewRequest("GET", "/stream", nil))
+			Expect(response.Flushed).To(BeTrue())
+		})
+	})
+})
@@ -18,53 +18,42 @@
 // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
 // THE SOFTWARE.
 
-package main
+package uberalls
 
 import (
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net/http"
 	"net/url"
+	"sync"
 	"time"
 
 	"github.com/jinzhu/gorm"
+	"github.com/uber/uberalls/coverage"
 )
 
 // Metric represents code coverage
-type Metric struct {
-	ID                  int64   `gorm:"primary_key:yes" json:"id"`
-	Repository          string  `sql:"not null" json:"repository"`
-	Sha                 string  `sql:"not null" json:"sha"`
-	Branch              string  `json:"branch"`
-	PackageCoverage     float64 `sql:"not null" json:"packageCoverage"`
-	FilesCoverage       float64 `sql:"not null" json:"filesCoverage"`
-	ClassesCoverage     float64 `sql:"not null" json:"classesCoverage"`
-	MethodCoverage      float64 `sql:"not null" json:"methodCoverage"`
-	LineCoverage        float64 `sql:"not null" json:"lineCoverage"`
-	ConditionalCoverage float64 `sql:"not null" json:"conditionalCoverage"`
-	Timestamp           int64   `sql:"not null" json:"timestamp"`
-	LinesCovered        int64   `sql:"not null" json:"linesCovered"`
-	LinesTested         int64   `sql:"not null" json:"linesTested"`
-}
-
-type errorResponse struct {
-	Error string `json:"error"`
-}
+type Metric = coverage.Metric
+
+type errorResponse = coverage.ErrorResponse
+
+const defaultBranch = coverage.DefaultBranch
 
 // MetricsHandler represents a metrics handler
 type MetricsHandler struct {
-	db *gorm.DB
+	db         *gorm.DB
+	lock       *sync.RWMutex
+	components *ComponentConfig
 }
 
-const defaultBranch = "origin/master"
-
-func writeError(w io.Writer, message string, err error) {
+// writeError writes an error response body and logs its message with the
+// request's ID
+func writeError(w io.Writer, r *http.Request, message string, err error) {
 	formattedMessage := fmt.Sprintf("%s: %v", message, err)
 
-	log.Println(formattedMessage)
+	requestLogger(r).Warn(formattedMessage)
 
 	errorMsg := errorResponse{
 		Error: formattedMessage,
@@ -72,100 +61,161 @@ func writeError(w io.Writer, message string, err error) {
 
 	errorString, encodingError := json.Marshal(errorMsg)
 	if encodingError != nil {
-		encodingErrorMessage := fmt.Sprintf("Unable to encode response message %v", encodingError)
-		log.Printf(encodingErrorMessage)
+		requestLogger(r).Error("Unable to encode response message", "error", encodingError)
 	}
 
 	w.Write(errorString)
 }
 
-func respondWithMetric(w http.ResponseWriter, m Metric) {
+func respondWithMetric(w http.ResponseWriter, r *http.Request, m Metric) {
 	bodyString, err := json.Marshal(m)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
-		writeError(w, "unable to encode response", err)
+		writeError(w, r, "unable to encode response", err)
 		return
 	}
 
 	w.Write([]byte(bodyString))
 }
 
-// ExtractMetricQuery extracts a query from the request
-func ExtractMetricQuery(form url.Values) Metric {
-	repository := form["repository"][0]
-	query := Metric{
-		Repository: repository,
+func respondWithJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	bodyString, err := json.Marshal(v)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, r, "unable to encode response", err)
+		return
 	}
 
-	if len(form["sha"]) < 1 {
-		if len(form["branch"]) < 1 {
-			query.Branch = defaultBranch
-		} else {
-			query.Branch = form["branch"][0]
+	w.Write(bodyString)
+}
+
+// latestPerSuite keeps the most recent metric for every suite, given metrics
+// ordered newest first
+func latestPerSuite(metrics []Metric) []Metric {
+	seen := make(map[string]bool)
+	latest := make([]Metric, 0, len(metrics))
+	for _, m := range metrics {
+		if seen[m.Suite] {
+			continue
 		}
-	} else {
-		query.Sha = form["sha"][0]
+		seen[m.Suite] = true
+		latest = append(latest, m)
 	}
-	return query
+	return latest
 }
 
 func (mh MetricsHandler) handleMetricsQuery(w http.ResponseWriter, r *http.Request) {
 	if err := r.ParseForm(); err != nil {
 		w.WriteHeader(http.StatusBadRequest)
-		writeError(w, "error parsing params", err)
+		writeError(w, r, "error parsing params", err)
 		return
 	}
-	log.Printf("Handling incoming request: %s", r.Form)
+	requestLogger(r).Debug("Handling incoming request", "query", r.Form.Encode())
 
 	if len(r.Form["repository"]) < 1 {
 		w.WriteHeader(http.StatusBadRequest)
-		writeError(w, "missing 'repository'", errors.New("need repository"))
+		writeError(w, r, "missing 'repository'", errors.New("need repository"))
 		return
 	}
 
-	query := ExtractMetricQuery(r.Form)
+	query := coverage.ExtractMetricQuery(r.Form)
+	if !authorize(w, r, query.Repository, PermissionRead) {
+		return
+	}
 
+	m := mh.FindMetric(query, r.Form)
+	if m == nil {
+		w.WriteHeader(http.StatusNotFound)
+		writeError(w, r, "no rows found", errors.New("-"))
+		return
+	}
+
+	respondWithMetric(w, r, *m)
+}
+
+// FindMetric finds the metric a query extracted by ExtractMetricQuery selects,
+// no later than the 'until' parameter if one is given, or nil if there is
+// none. Without a suite, the latest upload of every suite is combined.
+func (mh MetricsHandler) FindMetric(query Metric, form url.Values) *Metric {
+	m := latestMetric(mh.db, query, form)
+	if m.ID == 0 {
+		return nil
+	}
+	if query.Suite == "" {
+		*m = mh.combinedMetric(*m, form)
+	}
+	return m
+}
+
+// latestMetric finds the most recent metric matching a query, no later than
+// the 'until' parameter if one is given
+func latestMetric(db *gorm.DB, query Metric, form url.Values) *Metric {
 	m := new(Metric)
-	dbQuery := mh.db.Where(&query)
-	if len(r.Form["until"]) > 0 {
-		dbQuery = dbQuery.Where("timestamp <= ? ", r.Form["until"][0])
+	dbQuery := db.Where(&query).Where("component = ?", query.Component)
+	if len(form["until"]) > 0 {
+		dbQuery = dbQuery.Where("timestamp <= ? ", form["until"][0])
 	}
 	dbQuery.Order("timestamp desc").First(m)
+	return m
+}
 
-	if m.ID == 0 {
-		w.WriteHeader(http.StatusNotFound)
-		writeError(w, "no rows found", errors.New("-"))
-		return
+// latestSuites finds the latest upload of every suite recorded for the
+// commit of m, no later than the 'until' parameter if one is given
+func latestSuites(db *gorm.DB, m Metric, form url.Values) []Metric {
+	var metrics []Metric
+	dbQuery := db.Where(&Metric{Repository: m.Repository, Sha: m.Sha}).
+		Where("component = ?", m.Component)
+	if len(form["until"]) > 0 {
+		dbQuery = dbQuery.Where("timestamp <= ? ", form["until"][0])
+	}
+	dbQuery.Order("timestamp desc").Find(&metrics)
+	return latestPerSuite(metrics)
+}
+
+// combinedMetric merges the latest upload of every suite recorded for the
+// commit of m. When every suite stored line-level data, line and conditional
+// coverage are computed from the union of their covered lines.
+func (mh MetricsHandler) combinedMetric(m Metric, form url.Values) Metric {
+	suites := latestSuites(mh.db, m, form)
+	if len(suites) < 2 {
+		return m
+	}
+
+	merged := coverage.MergeMetrics(suites)
+	files, ok, err := combinedSourceFiles(mh.db, suites)
+	if err != nil || !ok {
+		return merged
 	}
 
-	respondWithMetric(w, *m)
+	merged.SourceFiles = files
+	merged.ApplySourceFiles()
+	merged.SourceFiles = nil
+	return merged
 }
 
 func (mh MetricsHandler) handleMetricsSave(w http.ResponseWriter, r *http.Request) {
-	if r.Body == nil {
-		w.WriteHeader(http.StatusBadRequest)
-		writeError(w, "no response body", errors.New("nil body"))
+	m := new(Metric)
+	if !decodeBody(w, r, m) {
 		return
 	}
-
-	decoder := json.NewDecoder(r.Body)
-	m := new(Metric)
-	if err := decoder.Decode(m); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		writeError(w, "unable to decode body", err)
+	if !authorize(w, r, m.Repository, PermissionUpload) {
 		return
 	}
-	log.Printf("Recording metric %v", m)
+	requestLogger(r).Info("Recording metric", "repository", m.Repository, "sha", m.Sha, "branch", m.Branch, "suite", m.Suite)
 
 	if err := mh.RecordMetric(m); err != nil {
 		w.WriteHeader(http.StatusBadRequest)
-		writeError(w, "error recording metric", err)
+		writeError(w, r, "error recording metric", err)
 	} else {
-		respondWithMetric(w, *m)
+		m.SourceFiles = nil
+		Audit(mh.db, r, AuditMetricCreate, m.Repository, auditTarget("metric", m.ID), nil, m)
+		respondWithMetric(w, r, *m)
 	}
 }
 
-// RecordMetric saves a Metric to the database
+// RecordMetric saves a Metric to the database. When it carries line-level
+// data, a summary of each file and a rollup for each configured component,
+// with the files it covers, are saved too.
 func (mh MetricsHandler) RecordMetric(m *Metric) error {
 	if m.Repository == "" || m.Sha == "" {
 		return errors.New("missing required field")
@@ -174,20 +224,64 @@ func (mh MetricsHandler) RecordMetric(m *Metric) error {
 	if m.Timestamp == 0 {
 		m.Timestamp = time.Now().Unix()
 	}
+	m.ApplySourceFiles()
 
-	mh.db.Create(m)
+	tx := mh.db.Begin()
+	if err := mh.record(tx, m); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// record saves a metric, its files and its component rollups in a
+// transaction
+func (mh MetricsHandler) record(tx *gorm.DB, m *Metric) error {
+	if err := tx.Create(m).Error; err != nil {
+		return err
+	}
+	if err := trackUpload(tx, *m); err != nil {
+		return err
+	}
+	if err := recordSourceFiles(tx, *m); err != nil {
+		return err
+	}
+	for _, rollup := range mh.Components().Rollups(*m) {
+		if err := tx.Create(&rollup).Error; err != nil {
+			return err
+		}
+		if err := recordSourceFiles(tx, rollup); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
 type handler func(w http.ResponseWriter, r *http.Request)
 
 // NewMetricsHandler creates a new MetricsHandler
-func NewMetricsHandler(db *gorm.DB) MetricsHandler {
+func NewMetricsHandler(db *gorm.DB, components ComponentConfig) MetricsHandler {
 	return MetricsHandler{
-		db: db,
+		db:         db,
+		lock:       new(sync.RWMutex),
+		components: &components,
 	}
 }
 
+// Components returns the components metrics are rolled up into
+func (mh MetricsHandler) Components() ComponentConfig {
+	mh.lock.RLock()
+	defer mh.lock.RUnlock()
+	return *mh.components
+}
+
+// SetComponents replaces the components metrics are rolled up into
+func (mh MetricsHandler) SetComponents(components ComponentConfig) {
+	mh.lock.Lock()
+	defer mh.lock.Unlock()
+	*mh.components = components
+}
+
 // ServeHTTP handles an HTTP request for metrics
 func (mh MetricsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
@@ -18,14 +18,13 @@
 // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
 // THE SOFTWARE.
 
-package main_test
+package uberalls_test
 
 import (
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
-	"net/url"
 	"strings"
 
 	"github.com/jinzhu/gorm"
@@ -35,6 +34,13 @@ import (
 	. "github.com/uber/uberalls"
 )
 
+var testComponents = ComponentConfig{
+	"monorepo": {
+		"api": []string{"api/**"},
+		"web": []string{"web/**/*.js"},
+	},
+}
+
 func getMetricsResponse(method string, body *strings.Reader, params string, db *gorm.DB) *httptest.ResponseRecorder {
 	var request *http.Request
 	url := "/metrics"
@@ -47,7 +53,7 @@ func getMetricsResponse(method string, body *strings.Reader, params string, db *
 		request, _ = http.NewRequest(method, url, body)
 	}
 	response := httptest.NewRecorder()
-	handler := NewMetricsHandler(db)
+	handler := NewMetricsHandler(db, testComponents)
 	handler.ServeHTTP(response, request)
 	return response
 }
@@ -193,6 +199,131 @@ var _ = Describe("/metrics handler", func() {
 		})
 	})
 
+	Context("With records from several suites", func() {
+		unit := `{
+			"repository": "test3", "suite": "unit", "lineCoverage": 60,
+			"conditionalCoverage": 30, "linesCovered": 60, "linesTested": 100,
+			"sha": "deadbeef", "timestamp": 1480636700}`
+		integration := `{
+			"repository": "test3", "suite": "integration", "lineCoverage": 40,
+			"conditionalCoverage": 50, "linesCovered": 40, "linesTested": 100,
+			"sha": "deadbeef", "timestamp": 1480636760}`
+
+		BeforeEach(func() {
+			response = getMetricsResponse("POST", strings.NewReader(unit), "", db)
+			Expect(response.Code).To(Equal(http.StatusOK))
+			response = getMetricsResponse("POST", strings.NewReader(integration), "", db)
+			Expect(response.Code).To(Equal(http.StatusOK))
+		})
+
+		It("Should retrieve a single suite", func() {
+			response = getMetricsResponse("GET", nil, "repository=test3&sha=deadbeef&suite=unit", db)
+			metric := new(Metric)
+			json.NewDecoder(response.Body).Decode(metric)
+
+			Expect(metric.Suite).To(Equal("unit"))
+			Expect(metric.LineCoverage).To(Equal(60.))
+		})
+
+		It("Should combine suites when none is requested", func() {
+			response = getMetricsResponse("GET", nil, "repository=test3&sha=deadbeef", db)
+			metric := new(Metric)
+			json.NewDecoder(response.Body).Decode(metric)
+
+			Expect(metric.Suites).To(ConsistOf("unit", "integration"))
+			Expect(metric.LinesCovered).To(BeEquivalentTo(60))
+			Expect(metric.LinesTested).To(BeEquivalentTo(100))
+			Expect(metric.LineCoverage).To(Equal(60.))
+			Expect(metric.ConditionalCoverage).To(Equal(50.))
+			Expect(metric.Timestamp).To(Equal(int64(1480636760)))
+		})
+
+		It("Should 404 for an unknown suite", func() {
+			response = getMetricsResponse("GET", nil, "repository=test3&sha=deadbeef&suite=e2e", db)
+			Expect(response.Code).To(Equal(http.StatusNotFound))
+		})
+	})
+
+	Context("With line-level data from several suites", func() {
+		unit := `{
+			"repository": "test4", "suite": "unit", "sha": "deadbeef",
+			"sourceFiles": [{"name": "main.go", "coverage": [1, 0, 0, null]}]}`
+		integration := `{
+			"repository": "test4", "suite": "integration", "sha": "deadbeef",
+			"sourceFiles": [{"name": "main.go", "coverage": [0, 1, 0, null]}]}`
+
+		BeforeEach(func() {
+			response = getMetricsResponse("POST", strings.NewReader(unit), "", db)
+			Expect(response.Code).To(Equal(http.StatusOK))
+			response = getMetricsResponse("POST", strings.NewReader(integration), "", db)
+			Expect(response.Code).To(Equal(http.StatusOK))
+		})
+
+		It("Should combine the union of covered lines", func() {
+			response = getMetricsResponse("GET", nil, "repository=test4&sha=deadbeef", db)
+			metric := new(Metric)
+			json.NewDecoder(response.Body).Decode(metric)
+
+			Expect(metric.Suites).To(ConsistOf("unit", "integration"))
+			Expect(metric.LinesCovered).To(Equal(int64(2)))
+			Expect(metric.LinesTested).To(Equal(int64(3)))
+		})
+	})
+
+	Context("With file-level data for a monorepo", func() {
+		record := `{
+			"repository": "monorepo", "sha": "deadbeef", "methodCoverage": 50,
+			"sourceFiles": [
+				{"name": "api/server.go", "coverage": [1, 1, 0, null]},
+				{"name": "web/app/index.js", "coverage": [0, 1]},
+				{"name": "README.md", "coverage": [null]}
+			]}`
+
+		BeforeEach(func() {
+			response = getMetricsResponse("POST", strings.NewReader(record), "", db)
+			Expect(response.Code).To(Equal(http.StatusOK))
+		})
+
+		It("Should keep the whole repository as the default view", func() {
+			response = getMetricsResponse("GET", nil, "repository=monorepo&sha=deadbeef", db)
+			metric := new(Metric)
+			json.NewDecoder(response.Body).Decode(metric)
+
+			Expect(metric.Component).To(BeEmpty())
+			Expect(metric.LinesCovered).To(Equal(int64(3)))
+			Expect(metric.LinesTested).To(Equal(int64(5)))
+		})
+
+		It("Should retrieve a component rollup", func() {
+			response = getMetricsResponse("GET", nil, "repository=monorepo&sha=deadbeef&component=api", db)
+			metric := new(Metric)
+			json.NewDecoder(response.Body).Decode(metric)
+
+			Expect(metric.Component).To(Equal("api"))
+			Expect(metric.LinesCovered).To(Equal(int64(2)))
+			Expect(metric.LinesTested).To(Equal(int64(3)))
+		})
+
+		It("Should serve a component's tree and files", func() {
+			response = getTreeResponse("repository=monorepo&sha=deadbeef&component=api", db)
+			Expect(response.Code).To(Equal(http.StatusOK))
+			tree := TreeResponse{}
+			Expect(json.NewDecoder(response.Body).Decode(&tree)).To(Succeed())
+			Expect(tree.Children).To(HaveLen(1))
+			Expect(tree.Children[0].Path).To(Equal("api"))
+
+			response = getFileResponse("repository=monorepo&sha=deadbeef&component=api&path=api/server.go", db)
+			Expect(response.Code).To(Equal(http.StatusOK))
+			response = getFileResponse("repository=monorepo&sha=deadbeef&component=api&path=README.md", db)
+			Expect(response.Code).To(Equal(http.StatusNotFound))
+		})
+
+		It("Should 404 for an unknown component", func() {
+			response = getMetricsResponse("GET", nil, "repository=monorepo&sha=deadbeef&component=docs", db)
+			Expect(response.Code).To(Equal(http.StatusNotFound))
+		})
+	})
+
 	Context("With invalid JSON", func() {
 		badJSON := `{}`
 
@@ -220,28 +351,3 @@ var _ = Describe("/metrics handler", func() {
 		})
 	})
 })
-
-var _ = Describe("ExtractMetricsQuery", func() {
-	Context("When branch is specified in the query", func() {
-		values := url.Values{
-			"repository": []string{"foo"},
-			"branch":     []string{"master"},
-		}
-		query := ExtractMetricQuery(values)
-
-		It("Should extract the master branch", func() {
-			Expect(query.Branch).To(Equal("master"))
-		})
-	})
-
-	Context("When no branch is specified", func() {
-		values := url.Values{
-			"repository": []string{"foo"},
-		}
-		query := ExtractMetricQuery(values)
-
-		It("Should extract the default branch", func() {
-			Expect(query.Branch).To(Equal("origin/master"))
-		})
-	})
-})
@@ -0,0 +1,377 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package uberalls
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Defaults for OIDC verification
+const (
+	defaultRepositoryClaim = "repository"
+	defaultRefClaim        = "ref"
+	jwksRefreshInterval    = 10 * time.Minute
+	jwksMinRefreshInterval = time.Minute
+	jwtLeeway              = 60
+)
+
+// OIDCConfig configures verification of OIDC tokens presented by CI
+// workloads. Tokens must come from Issuer and be intended for Audience, which
+// are both required. The repository claim of a verified token grants read access to
+// that repository, and upload access too if its ref claim matches one of Refs
+// (or Refs is empty).
+type OIDCConfig struct {
+	Issuer          string
+	Audience        string
+	JWKSFile        string
+	JWKSURL         string `json:"jwksURL"`
+	RepositoryClaim string
+	RefClaim        string
+	Refs            []string
+}
+
+// Enabled reports whether OIDC tokens are accepted
+func (c OIDCConfig) Enabled() bool {
+	return c.JWKSFile != "" || c.JWKSURL != ""
+}
+
+// WorkloadIdentity is the identity a CI workload proved with an OIDC token
+type WorkloadIdentity struct {
+	Subject    string
+	Repository string
+	Ref        string
+	CanUpload  bool
+}
+
+// Allows reports whether the workload holds a permission on a repository
+func (wi WorkloadIdentity) Allows(repository, permission string) bool {
+	if repository != wi.Repository {
+		return false
+	}
+	return permission == PermissionRead || (permission == PermissionUpload && wi.CanUpload)
+}
+
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+}
+
+// OIDCVerifier verifies JWTs against a JSON Web Key Set
+type OIDCVerifier struct {
+	config OIDCConfig
+	client *http.Client
+
+	lock    sync.Mutex
+	keys    map[string]crypto.PublicKey
+	fetched time.Time
+	// attempted is when the key set was last loaded, successfully or not,
+	// and loadErr why it failed
+	attempted time.Time
+	loadErr   error
+	// loading is closed when the load in progress finishes
+	loading chan struct{}
+}
+
+// NewOIDCVerifier creates a verifier, loading its keys lazily
+func NewOIDCVerifier(config OIDCConfig) *OIDCVerifier {
+	if config.RepositoryClaim == "" {
+		config.RepositoryClaim = defaultRepositoryClaim
+	}
+	if config.RefClaim == "" {
+		config.RefClaim = defaultRefClaim
+	}
+	return &OIDCVerifier{
+		config: config,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// LooksLikeJWT reports whether a secret has the shape of a JWT rather than
+// an API token
+func LooksLikeJWT(secret string) bool {
+	return strings.HasPrefix(secret, "eyJ") && strings.Count(secret, ".") == 2
+}
+
+// Verify checks a JWT's signature and claims, returning the workload identity
+// it asserts
+func (v *OIDCVerifier) Verify(raw string) (*WorkloadIdentity, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed JWT")
+	}
+
+	header := jwtHeader{}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := v.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	claims := map[string]interface{}{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	if err := v.checkClaims(claims, time.Now().Unix()); err != nil {
+		return nil, err
+	}
+
+	repository, _ := claims[v.config.RepositoryClaim].(string)
+	if repository == "" {
+		return nil, fmt.Errorf("missing '%s' claim", v.config.RepositoryClaim)
+	}
+	identity := &WorkloadIdentity{Repository: repository}
+	identity.Subject, _ = claims["sub"].(string)
+	identity.Ref, _ = claims[v.config.RefClaim].(string)
+	identity.CanUpload = len(v.config.Refs) == 0
+	for _, glob := range v.config.Refs {
+		if MatchGlob(glob, identity.Ref) {
+			identity.CanUpload = true
+		}
+	}
+	return identity, nil
+}
+
+func (v *OIDCVerifier) checkClaims(claims map[string]interface{}, now int64) error {
+	if v.config.Issuer == "" || v.config.Audience == "" {
+		return errors.New("OIDC issuer and audience are not configured")
+	}
+	if issuer, _ := claims["iss"].(string); issuer != v.config.Issuer {
+		return fmt.Errorf("unexpected issuer '%s'", issuer)
+	}
+
+	matched := false
+	switch aud := claims["aud"].(type) {
+	case string:
+		matched = aud == v.config.Audience
+	case []interface{}:
+		for _, a := range aud {
+			matched = matched || a == v.config.Audience
+		}
+	}
+	if !matched {
+		return errors.New("token is not intended for this audience")
+	}
+
+	exp, ok := claims["exp"].(float64)
+	if !ok || int64(exp)+jwtLeeway < now {
+		return errors.New("token is expired")
+	}
+	if nbf, ok := claims["nbf"].(float64); ok && int64(nbf)-jwtLeeway > now {
+		return errors.New("token is not valid yet")
+	}
+	return nil
+}
+
+// key finds a verification key, reloading the key set when the key is
+// unknown or the set is stale. Reloads happen at most once a minute, however
+// many made-up keys tokens name, and concurrent verifications share one
+// reload instead of waiting for the lock while it is fetched.
+func (v *OIDCVerifier) key(kid string) (crypto.PublicKey, error) {
+	v.lock.Lock()
+	key, ok := v.keys[kid]
+	if ok && time.Since(v.fetched) < jwksRefreshInterval {
+		v.lock.Unlock()
+		return key, nil
+	}
+	if v.loading == nil && time.Since(v.attempted) < jwksMinRefreshInterval {
+		v.lock.Unlock()
+		return v.lookup(kid)
+	}
+
+	loading := v.loading
+	if loading == nil {
+		loading = make(chan struct{})
+		v.loading = loading
+		v.attempted = time.Now()
+		v.lock.Unlock()
+
+		keys, err := v.loadKeys()
+		v.lock.Lock()
+		if err == nil {
+			v.keys = keys
+			v.fetched = time.Now()
+		}
+		v.loadErr = err
+		v.loading = nil
+		v.lock.Unlock()
+		close(loading)
+	} else {
+		v.lock.Unlock()
+		<-loading
+	}
+	return v.lookup(kid)
+}
+
+// lookup finds a key in the key set last loaded, keeping stale keys when a
+// reload failed
+func (v *OIDCVerifier) lookup(kid string) (crypto.PublicKey, error) {
+	v.lock.Lock()
+	defer v.lock.Unlock()
+
+	if key, ok := v.keys[kid]; ok {
+		return key, nil
+	}
+	if v.loadErr != nil {
+		return nil, fmt.Errorf("unable to load JWKS: %v", v.loadErr)
+	}
+	return nil, fmt.Errorf("unknown key '%s'", kid)
+}
+
+func (v *OIDCVerifier) loadKeys() (map[string]crypto.PublicKey, error) {
+	var content []byte
+	var err error
+	if v.config.JWKSFile != "" {
+		content, err = ioutil.ReadFile(v.config.JWKSFile)
+	} else {
+		content, err = v.fetchKeys()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ParseJWKS(content)
+}
+
+func (v *OIDCVerifier) fetchKeys() ([]byte, error) {
+	response, err := v.client.Get(v.config.JWKSURL)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s returned %s", v.config.JWKSURL, response.Status)
+	}
+	return ioutil.ReadAll(response.Body)
+}
+
+// ParseJWKS parses the RSA and P-256 keys of a JSON Web Key Set
+func ParseJWKS(content []byte) (map[string]crypto.PublicKey, error) {
+	set := struct {
+		Keys []jsonWebKey `json:"keys"`
+	}{}
+	if err := json.Unmarshal(content, &set); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, jwk := range set.Keys {
+		switch jwk.Kty {
+		case "RSA":
+			n, err := decodeBigInt(jwk.N)
+			if err != nil {
+				return nil, err
+			}
+			e, err := decodeBigInt(jwk.E)
+			if err != nil {
+				return nil, err
+			}
+			keys[jwk.Kid] = &rsa.PublicKey{N: n, E: int(e.Int64())}
+		case "EC":
+			if jwk.Crv != "P-256" {
+				continue
+			}
+			x, err := decodeBigInt(jwk.X)
+			if err != nil {
+				return nil, err
+			}
+			y, err := decodeBigInt(jwk.Y)
+			if err != nil {
+				return nil, err
+			}
+			keys[jwk.Kid] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+		}
+	}
+	return keys, nil
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signed string, signature []byte) error {
+	digest := sha256.Sum256([]byte(signed))
+	switch alg {
+	case "RS256":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("key does not match algorithm")
+		}
+		return rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, digest[:], signature)
+	case "ES256":
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(signature) != 64 {
+			return errors.New("key does not match algorithm")
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(ecKey, digest[:], r, s) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported algorithm '%s'", alg)
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	content, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.NewDecoder(bytes.NewReader(content)).Decode(v)
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,230 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package uberalls_test
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/uber/uberalls"
+)
+
+func writeJWKS(key *rsa.PrivateKey, kid string) string {
+	encode := base64.RawURLEncoding.EncodeToString
+	jwks := fmt.Sprintf(`{"keys": [{"kty": "RSA", "kid": "%s", "n": "%s", "e": "%s"}]}`,
+		kid, encode(key.N.Bytes()), encode(big.NewInt(int64(key.E)).Bytes()))
+	file, _ := ioutil.TempFile("", "jwks")
+	file.WriteString(jwks)
+	file.Close()
+	return file.Name()
+}
+
+func signJWT(key *rsa.PrivateKey, kid string, claims map[string]interface{}) string {
+	encode := func(v interface{}) string {
+		b, _ := json.Marshal(v)
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	signed := encode(map[string]string{"alg": "RS256", "kid": kid}) + "." + encode(claims)
+	digest := sha256.Sum256([]byte(signed))
+	signature, _ := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+var _ = Describe("OIDC tokens", func() {
+	var (
+		key     *rsa.PrivateKey
+		jwks    string
+		config  OIDCConfig
+		claims  map[string]interface{}
+		subject *OIDCVerifier
+	)
+
+	BeforeEach(func() {
+		var err error
+		key, err = rsa.GenerateKey(rand.Reader, 2048)
+		Expect(err).ToNot(HaveOccurred())
+		jwks = writeJWKS(key, "ci")
+
+		config = OIDCConfig{
+			Issuer:   "https://ci.example.com",
+			Audience: "uberalls",
+			JWKSFile: jwks,
+			Refs:     []string{"refs/heads/master"},
+		}
+		claims = map[string]interface{}{
+			"iss":        "https://ci.example.com",
+			"aud":        []string{"uberalls"},
+			"sub":        "repo:uber/uberalls",
+			"exp":        time.Now().Add(time.Hour).Unix(),
+			"repository": "uber/uberalls",
+			"ref":        "refs/heads/master",
+		}
+	})
+
+	JustBeforeEach(func() {
+		subject = NewOIDCVerifier(config)
+	})
+
+	AfterEach(func() {
+		os.Remove(jwks)
+	})
+
+	It("Should recognize JWTs", func() {
+		Expect(LooksLikeJWT(signJWT(key, "ci", claims))).To(BeTrue())
+		Expect(LooksLikeJWT("uat_0123")).To(BeFalse())
+	})
+
+	It("Should map claims to a workload identity", func() {
+		identity, err := subject.Verify(signJWT(key, "ci", claims))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(identity.Subject).To(Equal("repo:uber/uberalls"))
+		Expect(identity.Allows("uber/uberalls", PermissionUpload)).To(BeTrue())
+		Expect(identity.Allows("uber/other", PermissionRead)).To(BeFalse())
+	})
+
+	It("Should only grant reads to other refs", func() {
+		claims["ref"] = "refs/pull/1/merge"
+		identity, err := subject.Verify(signJWT(key, "ci", claims))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(identity.Allows("uber/uberalls", PermissionRead)).To(BeTrue())
+		Expect(identity.Allows("uber/uberalls", PermissionUpload)).To(BeFalse())
+	})
+
+	It("Should reject expired tokens", func() {
+		claims["exp"] = time.Now().Add(-time.Hour).Unix()
+		_, err := subject.Verify(signJWT(key, "ci", claims))
+		Expect(err).To(MatchError(ContainSubstring("expired")))
+	})
+
+	It("Should reject other issuers", func() {
+		claims["iss"] = "https://elsewhere.example.com"
+		_, err := subject.Verify(signJWT(key, "ci", claims))
+		Expect(err).To(MatchError("unexpected issuer 'https://elsewhere.example.com'"))
+	})
+
+	It("Should reject tokens the issuer minted for other audiences", func() {
+		claims["aud"] = []string{"someone-else"}
+		_, err := subject.Verify(signJWT(key, "ci", claims))
+		Expect(err).To(MatchError("token is not intended for this audience"))
+
+		delete(claims, "aud")
+		_, err = subject.Verify(signJWT(key, "ci", claims))
+		Expect(err).To(MatchError("token is not intended for this audience"))
+	})
+
+	It("Should reject every token without an issuer and audience configured", func() {
+		config.Audience = ""
+		_, err := NewOIDCVerifier(config).Verify(signJWT(key, "ci", claims))
+		Expect(err).To(MatchError(ContainSubstring("not configured")))
+	})
+
+	It("Should not reload the key set for every unknown key", func() {
+		fetches := 0
+		jwksServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fetches++
+			content, _ := ioutil.ReadFile(jwks)
+			w.Write(content)
+		}))
+		defer jwksServer.Close()
+		config.JWKSFile = ""
+		config.JWKSURL = jwksServer.URL
+		subject = NewOIDCVerifier(config)
+
+		for i := 0; i < 20; i++ {
+			_, err := subject.Verify(signJWT(key, fmt.Sprintf("made-up-%d", i), claims))
+			Expect(err).To(MatchError(ContainSubstring("unknown key")))
+		}
+		_, err := subject.Verify(signJWT(key, "ci", claims))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(fetches).To(Equal(1))
+	})
+
+	It("Should reject tokens signed by unknown keys", func() {
+		other, _ := rsa.GenerateKey(rand.Reader, 2048)
+		_, err := subject.Verify(signJWT(other, "ci", claims))
+		Expect(err).To(HaveOccurred())
+
+		_, err = subject.Verify(signJWT(key, "rotated", claims))
+		Expect(err).To(MatchError(ContainSubstring("unknown key")))
+	})
+
+	It("Should reject unsigned tokens", func() {
+		token := signJWT(key, "ci", claims)
+		parts := strings.Split(token, ".")
+		header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","kid":"ci"}`))
+		_, err := subject.Verify(header + "." + parts[1] + ".")
+		Expect(err).To(HaveOccurred())
+	})
+
+	Context("Authenticating uploads", func() {
+		var (
+			db      *gorm.DB
+			handler http.Handler
+		)
+		record := `{"repository": "uber/uberalls", "sha": "deadbeef", "lineCoverage": 42}`
+
+		JustBeforeEach(func() {
+			serverConfig := &Config{
+				DBType:       "sqlite3",
+				DBLocation:   "test.sqlite",
+				AuthRequired: true,
+				OIDC:         config,
+			}
+			db, _ = serverConfig.DB()
+			Expect(serverConfig.Automigrate()).To(Succeed())
+			handler = NewAuth(db, serverConfig).Wrap(NewMetricsHandler(db, nil))
+		})
+
+		It("Should accept uploads for the claimed repository", func() {
+			response := getAuthenticatedResponse(handler, "POST", "/metrics", record, signJWT(key, "ci", claims))
+			Expect(response.Code).To(Equal(http.StatusOK))
+		})
+
+		It("Should reject uploads for other repositories", func() {
+			body := strings.Replace(record, "uber/uberalls", "uber/other", 1)
+			response := getAuthenticatedResponse(handler, "POST", "/metrics", body, signJWT(key, "ci", claims))
+			Expect(response.Code).To(Equal(http.StatusForbidden))
+			Expect(response.Body.String()).To(ContainSubstring("claims repository 'uber/uberalls'"))
+		})
+
+		It("Should reject invalid tokens", func() {
+			claims["exp"] = time.Now().Add(-time.Hour).Unix()
+			response := getAuthenticatedResponse(handler, "POST", "/metrics", record, signJWT(key, "ci", claims))
+			Expect(response.Code).To(Equal(http.StatusUnauthorized))
+		})
+	})
+})
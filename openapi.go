@@ -0,0 +1,778 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package uberalls
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/uber/uberalls/coverage"
+)
+
+// OpenAPI is an OpenAPI 3 document describing the HTTP API
+type OpenAPI struct {
+	OpenAPI    string              `json:"openapi"`
+	Info       OpenAPIInfo         `json:"info"`
+	Paths      map[string]PathItem `json:"paths"`
+	Components OpenAPIComponents   `json:"components"`
+}
+
+// OpenAPIInfo describes the API
+type OpenAPIInfo struct {
+	Title       string `json:"title"`
+	Description string `json:"description"`
+	Version     string `json:"version"`
+}
+
+// OpenAPIComponents holds the schemas operations refer to
+type OpenAPIComponents struct {
+	Schemas map[string]*Schema `json:"schemas"`
+}
+
+// PathItem maps the lowercase HTTP methods a path supports to operations
+type PathItem map[string]*Operation
+
+// Operation describes what a method on a path does
+type Operation struct {
+	Summary     string              `json:"summary"`
+	OperationID string              `json:"operationId"`
+	Parameters  []Parameter         `json:"parameters,omitempty"`
+	RequestBody *RequestBody        `json:"requestBody,omitempty"`
+	Responses   map[string]Response `json:"responses"`
+}
+
+// Parameter describes a query or path parameter
+type Parameter struct {
+	Name        string  `json:"name"`
+	In          string  `json:"in"`
+	Description string  `json:"description,omitempty"`
+	Required    bool    `json:"required,omitempty"`
+	Schema      *Schema `json:"schema"`
+}
+
+// RequestBody describes the body an operation accepts
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+// Response describes a response to an operation
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+// MediaType holds the schema of a body in one media type
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Schema is the subset of JSON schema the API is described with.
+// AdditionalProperties is the schema of a map's values.
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	MinLength            int                `json:"minLength,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	ReadOnly             bool               `json:"readOnly,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+}
+
+// apiSpec describes the API this server serves
+var apiSpec = NewAPISpec()
+
+// NewAPISpec builds the OpenAPI document of the HTTP API
+func NewAPISpec() *OpenAPI {
+	schemas := map[string]*Schema{}
+	ref := func(v interface{}) *Schema {
+		return schemaOf(reflect.TypeOf(v), schemas)
+	}
+	metric := ref(Metric{})
+	errorResponse := jsonResponse("An error", ref(errorResponse{}))
+	ok := func(description string, schema *Schema) map[string]Response {
+		return map[string]Response{"200": jsonResponse(description, schema), "default": errorResponse}
+	}
+	html := map[string]Response{
+		"200": {
+			Description: "An HTML page",
+			Content:     map[string]MediaType{"text/html": {Schema: &Schema{Type: "string"}}},
+		},
+	}
+	pages := []Parameter{
+		queryParam("prefix", "Only list names starting with this prefix", stringSchema()),
+		queryParam("after", "The 'next' of the previous page", stringSchema()),
+		limitParam,
+	}
+
+	spec := &OpenAPI{
+		OpenAPI: "3.0.3",
+		Info: OpenAPIInfo{
+			Title:       "uberalls",
+			Description: "Code coverage metric storage service",
+			Version:     "1",
+		},
+		Paths: map[string]PathItem{
+			"/health": {"get": {
+				Summary:     "Check the database connection",
+				OperationID: "health",
+				Responses: map[string]Response{
+					"200": {Description: "The server is healthy"},
+					"500": {Description: "The database is unreachable"},
+				},
+			}},
+			"/openapi.json": {"get": {
+				Summary:     "Describe the API",
+				OperationID: "getOpenAPI",
+				Responses:   map[string]Response{"200": jsonResponse("This document", &Schema{Type: "object"})},
+			}},
+			"/metrics": {
+				"get": {
+					Summary:     "Get the latest metric of a commit or branch, combining suites without a suite",
+					OperationID: "getMetric",
+					Parameters:  metricQueryParams(),
+					Responses:   ok("The metric", metric),
+				},
+				"post": {
+					Summary:     "Record a metric",
+					OperationID: "recordMetric",
+					RequestBody: jsonBody(metric),
+					Responses:   ok("The metric as recorded", metric),
+				},
+			},
+			"/metrics/history": {"get": {
+				Summary:     "List the coverage of the commits of a branch, newest first",
+				OperationID: "listMetrics",
+				Parameters:  append(metricQueryParams(), limitParam),
+				Responses:   ok("One metric per commit, combining suites unless one is given", &Schema{Type: "array", Items: metric}),
+			}},
+			"/metrics/tree": {"get": {
+				Summary:     "Get the coverage of a directory and its children",
+				OperationID: "getTree",
+				Parameters:  append(metricQueryParams(), queryParam("path", "Directory, relative to the repository root", &Schema{Type: "string"})),
+				Responses:   ok("The directory's coverage", ref(TreeResponse{})),
+			}},
+			"/metrics/file": {"get": {
+				Summary:     "Get the line-level coverage of a file",
+				OperationID: "getFile",
+				Parameters: append(metricQueryParams(),
+					requiredParam("path", "File, relative to the repository root", stringSchema()),
+					queryParam("format", "'html' for an annotated source view", &Schema{Type: "string", Enum: []string{"json", "html"}}),
+				),
+				Responses: ok("The file's line hits", ref(SourceFile{})),
+			}},
+			"/sessions": {
+				"get": {
+					Summary:     "Get the progress of a sharded upload",
+					OperationID: "getSession",
+					Parameters: []Parameter{
+						requiredParam("repository", "Repository", stringSchema()),
+						requiredParam("sha", "Commit", stringSchema()),
+						queryParam("suite", "Test suite", stringSchema()),
+					},
+					Responses: ok("The session", ref(UploadSession{})),
+				},
+				"post": {
+					Summary:     "Upload one shard's partial report",
+					OperationID: "recordShard",
+					RequestBody: jsonBody(ref(PartialReport{})),
+					Responses:   ok("The session", ref(UploadSession{})),
+				},
+			},
+			"/policy": {"get": {
+				Summary:     "Get the coverage thresholds a repository's builds must meet",
+				OperationID: "getPolicy",
+				Parameters:  []Parameter{requiredParam("repository", "Repository", stringSchema())},
+				Responses:   ok("The policy; unset thresholds don't apply", ref(coverage.Policy{})),
+			}},
+			"/repositories": {"get": {
+				Summary:     "List repositories",
+				OperationID: "listRepositories",
+				Parameters:  pages,
+				Responses:   ok("A page of repositories", ref(RepositoriesPage{})),
+			}},
+			"/repositories/{name}/branches": {"get": {
+				Summary:     "List a repository's branches",
+				OperationID: "listBranches",
+				Parameters: append([]Parameter{{
+					Name:     "name",
+					In:       "path",
+					Required: true,
+					Schema:   stringSchema(),
+				}}, pages...),
+				Responses: ok("A page of branches", ref(RepositoriesPage{})),
+			}},
+			"/tokens": {
+				"get": {
+					Summary:     "List API tokens",
+					OperationID: "listTokens",
+					Responses:   ok("The tokens", &Schema{Type: "array", Items: ref(Token{})}),
+				},
+				"post": {
+					Summary:     "Mint an API token",
+					OperationID: "mintToken",
+					RequestBody: jsonBody(ref(TokenRequest{})),
+					Responses:   ok("The token, with its secret", ref(MintedToken{})),
+				},
+				"delete": {
+					Summary:     "Revoke an API token",
+					OperationID: "revokeToken",
+					Parameters:  []Parameter{requiredParam("id", "Token ID", integerSchema(1))},
+					Responses:   ok("The revoked token", ref(Token{})),
+				},
+			},
+			"/admin/visibility": {
+				"get": {
+					Summary:     "Get a repository's visibility",
+					OperationID: "getVisibility",
+					Parameters:  []Parameter{requiredParam("repository", "Repository", stringSchema())},
+					Responses:   ok("The visibility", ref(VisibilityRequest{})),
+				},
+				"post": {
+					Summary:     "Set a repository's visibility",
+					OperationID: "setVisibility",
+					RequestBody: jsonBody(ref(VisibilityRequest{})),
+					Responses:   ok("The visibility", ref(VisibilityRequest{})),
+				},
+			},
+			"/admin/grants": {
+				"get": {
+					Summary:     "List the roles granted on a repository",
+					OperationID: "listGrants",
+					Parameters:  []Parameter{requiredParam("repository", "Repository", stringSchema())},
+					Responses:   ok("The grants", &Schema{Type: "array", Items: ref(Grant{})}),
+				},
+				"post": {
+					Summary:     "Grant a role on a repository to a user or group",
+					OperationID: "grantRole",
+					RequestBody: jsonBody(ref(Grant{})),
+					Responses:   ok("The grant", ref(Grant{})),
+				},
+				"delete": {
+					Summary:     "Revoke a grant",
+					OperationID: "revokeGrant",
+					Parameters:  []Parameter{requiredParam("id", "Grant ID", integerSchema(1))},
+					Responses:   ok("The revoked grant", ref(Grant{})),
+				},
+			},
+			"/admin/groups": {
+				"get": {
+					Summary:     "List group members",
+					OperationID: "listGroupMembers",
+					Parameters:  []Parameter{queryParam("group", "Only list this group's members", stringSchema())},
+					Responses:   ok("The members", &Schema{Type: "array", Items: ref(GroupMember{})}),
+				},
+				"post": {
+					Summary:     "Add a user to a group",
+					OperationID: "addGroupMember",
+					RequestBody: jsonBody(ref(GroupMember{})),
+					Responses:   ok("The membership", ref(GroupMember{})),
+				},
+				"delete": {
+					Summary:     "Remove a user from a group",
+					OperationID: "removeGroupMember",
+					Parameters: []Parameter{
+						requiredParam("group", "Group", stringSchema()),
+						requiredParam("user", "User", stringSchema()),
+					},
+					Responses: ok("The removed membership", ref(GroupMember{})),
+				},
+			},
+			"/admin/config": {
+				"get": {
+					Summary:     "Describe the active configuration",
+					OperationID: "getConfig",
+					Responses:   ok("The configuration", ref(ConfigStatus{})),
+				},
+				"post": {
+					Summary:     "Reload the configuration",
+					OperationID: "reloadConfig",
+					Responses:   ok("The reloaded configuration", ref(ConfigStatus{})),
+				},
+			},
+			"/audit": {"get": {
+				Summary:     "List audit log entries, oldest first",
+				OperationID: "listAuditEntries",
+				Parameters: []Parameter{
+					queryParam("actor", "Only list this actor's entries", stringSchema()),
+					queryParam("action", "Only list this action, like 'metric.create'", stringSchema()),
+					queryParam("repository", "Only list this repository's entries", stringSchema()),
+					queryParam("target", "Only list entries about this target, like 'token/1'", stringSchema()),
+					queryParam("requestId", "Only list entries of this request", stringSchema()),
+					queryParam("since", "Only list entries from this Unix timestamp", integerSchema(0)),
+					queryParam("until", "Only list entries until this Unix timestamp", integerSchema(0)),
+					queryParam("after", "The 'next' of the previous page", integerSchema(0)),
+					limitParam,
+					queryParam("format", "'ndjson' to export every entry as newline-delimited JSON", &Schema{Type: "string", Enum: []string{"ndjson"}}),
+				},
+				Responses: ok("A page of entries", ref(AuditPage{})),
+			}},
+			"/dashboard": {"get": {
+				Summary:     "Browse repositories",
+				OperationID: "dashboard",
+				Parameters:  []Parameter{queryParam("after", "The last repository of the previous page", stringSchema())},
+				Responses:   html,
+			}},
+			"/dashboard/repository": {"get": {
+				Summary:     "Browse a repository's branches and history",
+				OperationID: "dashboardRepository",
+				Parameters: []Parameter{
+					requiredParam("repository", "Repository", stringSchema()),
+					queryParam("branch", "Branch to chart, "+defaultBranch+" by default", stringSchema()),
+					queryParam("suite", "Test suite to chart", stringSchema()),
+				},
+				Responses: html,
+			}},
+			"/dashboard/compare": {"get": {
+				Summary:     "Compare two commits",
+				OperationID: "dashboardCompare",
+				Parameters: []Parameter{
+					requiredParam("repository", "Repository", stringSchema()),
+					requiredParam("base", "Commit to compare with", stringSchema()),
+					requiredParam("head", "Commit to compare", stringSchema()),
+				},
+				Responses: html,
+			}},
+		},
+		Components: OpenAPIComponents{Schemas: schemas},
+	}
+
+	constrainSchemas(schemas)
+	return spec
+}
+
+// constrainSchemas adds what the Go types of request bodies don't say to
+// their schemas
+func constrainSchemas(schemas map[string]*Schema) {
+	for _, name := range []string{"Metric", "PartialReport"} {
+		s := schemas[name]
+		s.Required = []string{"repository", "sha"}
+		s.Properties["repository"].MinLength = 1
+		s.Properties["sha"].MinLength = 1
+		s.Properties["id"].ReadOnly = true
+		for _, field := range coverage.MetricFields {
+			s.Properties[field.Key].Minimum = bound(0)
+			s.Properties[field.Key].Maximum = bound(100)
+		}
+		for _, count := range []string{"timestamp", "linesCovered", "linesTested"} {
+			s.Properties[count].Minimum = bound(0)
+		}
+	}
+	schemas["PartialReport"].Properties["shard"].Minimum = bound(0)
+	schemas["PartialReport"].Properties["shardCount"].Minimum = bound(1)
+	schemas["PartialReport"].Properties["timeout"].Minimum = bound(0)
+	schemas["PartialReport"].Properties["timeout"].Maximum = bound(MaxSessionTimeout)
+	schemas["PartialReport"].Required = append(schemas["PartialReport"].Required, "shardCount")
+
+	schemas["SourceFile"].Required = []string{"name"}
+	schemas["SourceFile"].Properties["coverage"].Items.Minimum = bound(0)
+
+	schemas["TokenRequest"].Required = []string{"name"}
+	schemas["TokenRequest"].Properties["name"].MinLength = 1
+	schemas["TokenRequest"].Properties["permissions"].Items.Enum = []string{PermissionRead, PermissionUpload}
+
+	schemas["Grant"].Required = []string{"repository", "role"}
+	schemas["Grant"].Properties["repository"].MinLength = 1
+	schemas["Grant"].Properties["role"].Enum = []string{RoleViewer, RoleUploader, RoleAdmin}
+	schemas["Grant"].Properties["id"].ReadOnly = true
+	schemas["Grant"].Properties["timestamp"].ReadOnly = true
+
+	schemas["GroupMember"].Required = []string{"group", "user"}
+	schemas["GroupMember"].Properties["group"].MinLength = 1
+	schemas["GroupMember"].Properties["user"].MinLength = 1
+
+	schemas["VisibilityRequest"].Required = []string{"repository", "visibility"}
+	schemas["VisibilityRequest"].Properties["repository"].MinLength = 1
+	schemas["VisibilityRequest"].Properties["visibility"].Enum = []string{VisibilityPublic, VisibilityPrivate}
+}
+
+// metricQueryParams are the parameters ExtractMetricQuery reads, and the
+// 'until' parameter
+func metricQueryParams() []Parameter {
+	return []Parameter{
+		requiredParam("repository", "Repository", stringSchema()),
+		queryParam("sha", "Commit; takes precedence over 'branch'", stringSchema()),
+		queryParam("branch", "Branch whose latest commit to select, "+defaultBranch+" without 'sha' or 'branch'", stringSchema()),
+		queryParam("suite", "Test suite; without one, the latest upload of every suite is combined", stringSchema()),
+		queryParam("component", "Component whose rollup to select", stringSchema()),
+		queryParam("until", "Ignore uploads after this Unix timestamp", integerSchema(0)),
+	}
+}
+
+var limitParam = Parameter{
+	Name:        "limit",
+	In:          "query",
+	Description: fmt.Sprintf("Page size, %d by default", defaultPageSize),
+	Schema:      &Schema{Type: "integer", Minimum: bound(1), Maximum: bound(maxPageSize)},
+}
+
+func queryParam(name, description string, schema *Schema) Parameter {
+	return Parameter{Name: name, In: "query", Description: description, Schema: schema}
+}
+
+func requiredParam(name, description string, schema *Schema) Parameter {
+	p := queryParam(name, description, schema)
+	p.Required = true
+	return p
+}
+
+func stringSchema() *Schema {
+	return &Schema{Type: "string", MinLength: 1}
+}
+
+func integerSchema(minimum float64) *Schema {
+	return &Schema{Type: "integer", Minimum: bound(minimum)}
+}
+
+func bound(value float64) *float64 {
+	return &value
+}
+
+func jsonBody(schema *Schema) *RequestBody {
+	return &RequestBody{
+		Required: true,
+		Content:  map[string]MediaType{"application/json": {Schema: schema}},
+	}
+}
+
+func jsonResponse(description string, schema *Schema) Response {
+	return Response{
+		Description: description,
+		Content:     map[string]MediaType{"application/json": {Schema: schema}},
+	}
+}
+
+var rawMessageType = reflect.TypeOf(json.RawMessage{})
+
+// schemaOf describes a Go type as encoding/json encodes it, adding the
+// structs it refers to to schemas by name
+func schemaOf(t reflect.Type, schemas map[string]*Schema) *Schema {
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schemaOf(t.Elem(), schemas)
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &Schema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t == rawMessageType {
+			return &Schema{}
+		}
+		items := schemaOf(t.Elem(), schemas)
+		if t.Elem().Kind() == reflect.Ptr && items.Ref == "" {
+			items.Nullable = true
+		}
+		return &Schema{Type: "array", Items: items}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: schemaOf(t.Elem(), schemas)}
+	case reflect.Struct:
+		if _, ok := schemas[t.Name()]; !ok {
+			schemas[t.Name()] = &Schema{}
+			*schemas[t.Name()] = *structSchema(t, schemas)
+		}
+		return &Schema{Ref: "#/components/schemas/" + t.Name()}
+	}
+	return &Schema{}
+}
+
+// structSchema describes the JSON object a struct encodes to, including the
+// fields of embedded structs
+func structSchema(t reflect.Type, schemas map[string]*Schema) *Schema {
+	s := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" || (field.PkgPath != "" && !field.Anonymous) {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			for key, property := range structSchema(field.Type, schemas).Properties {
+				s.Properties[key] = property
+			}
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		s.Properties[name] = schemaOf(field.Type, schemas)
+	}
+	return s
+}
+
+// ServeHTTP serves the document as JSON
+func (spec *OpenAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	respondWithJSON(w, r, spec)
+}
+
+// operation finds the operation describing a request, reporting whether
+// the spec describes its path at all
+func (spec *OpenAPI) operation(r *http.Request) (*Operation, bool) {
+	path := r.URL.Path
+	if path != "/" {
+		path = strings.TrimSuffix(path, "/")
+	}
+	for template, item := range spec.Paths {
+		if !matchPath(template, path) {
+			continue
+		}
+		return item[strings.ToLower(r.Method)], true
+	}
+	return nil, false
+}
+
+// matchPath reports whether a path matches a template with at most one
+// parameter, which may span several segments like repository names do
+func matchPath(template, path string) bool {
+	open := strings.Index(template, "{")
+	if open < 0 {
+		return template == path
+	}
+	prefix, suffix := template[:open], template[strings.Index(template, "}")+1:]
+	return len(path) > len(prefix)+len(suffix) && strings.HasPrefix(path, prefix) && strings.HasSuffix(path, suffix)
+}
+
+// Validate rejects requests the spec does not allow before next handles
+// them: unsupported methods with a 405, and unknown, repeated, missing or
+// invalid query parameters with a 400. Paths the spec doesn't describe are
+// left alone. Bodies are checked by ValidateBody once handlers have decoded
+// them.
+func (spec *OpenAPI) Validate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		op, described := spec.operation(r)
+		if !described {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if op == nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			writeError(w, r, "unsupported method", errors.New(r.Method))
+			return
+		}
+
+		if err := spec.validateParams(op, r.URL.Query()); err != nil {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			writeError(w, r, "invalid request", err)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validateParams checks a request's query parameters against an operation's
+func (spec *OpenAPI) validateParams(op *Operation, query url.Values) error {
+	declared := map[string]Parameter{}
+	var names []string
+	for _, p := range op.Parameters {
+		if p.In == "query" {
+			declared[p.Name] = p
+			names = append(names, p.Name)
+		}
+	}
+
+	var unknown []string
+	for name := range query {
+		if _, ok := declared[name]; !ok {
+			unknown = append(unknown, name)
+		}
+	}
+	if len(unknown) > 0 {
+		// a misspelt filter would otherwise be dropped silently
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown parameter '%s'", unknown[0])
+	}
+
+	for _, name := range names {
+		p := declared[name]
+		values, ok := query[name]
+		switch {
+		case !ok && p.Required:
+			return fmt.Errorf("missing parameter '%s'", name)
+		case !ok:
+			continue
+		case len(values) > 1:
+			return fmt.Errorf("parameter '%s' given %d times", name, len(values))
+		}
+		if err := spec.validateParam(p.Schema, values[0], "parameter '"+name+"'"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateParam checks a query parameter's value against its schema
+func (spec *OpenAPI) validateParam(schema *Schema, value, at string) error {
+	switch schema.Type {
+	case "integer":
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%s: must be an integer, not '%s'", at, value)
+		}
+		return checkNumber(schema, float64(n), at)
+	case "string":
+		return checkString(schema, value, at)
+	}
+	return nil
+}
+
+// ValidateBody checks a request body, once a handler decoded it, against the
+// schema the spec declares for the request's operation. Properties the
+// schema doesn't know were already dropped by decoding, and are allowed.
+func (spec *OpenAPI) ValidateBody(r *http.Request, body interface{}) error {
+	op, _ := spec.operation(r)
+	if op == nil || op.RequestBody == nil {
+		return nil
+	}
+	return spec.validateValue(op.RequestBody.Content["application/json"].Schema, reflect.ValueOf(body), "body")
+}
+
+// validateValue checks a Go value against the schema describing its JSON
+// encoding, naming where in the body it was found in the error. Zero values
+// count as missing, as encoding/json leaves missing properties zero.
+func (spec *OpenAPI) validateValue(schema *Schema, v reflect.Value, at string) error {
+	if schema.Ref != "" {
+		schema = spec.Components.Schemas[strings.TrimPrefix(schema.Ref, "#/components/schemas/")]
+	}
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		properties := jsonFields(v)
+		for _, name := range schema.Required {
+			if property, ok := properties[name]; !ok || property.IsZero() {
+				return fmt.Errorf("%s: missing property '%s'", at, name)
+			}
+		}
+		names := make([]string, 0, len(properties))
+		for name := range properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			property, ok := schema.Properties[name]
+			if !ok || property.ReadOnly || properties[name].IsZero() {
+				continue
+			}
+			if err := spec.validateValue(property, properties[name], at+"."+name); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		if schema.Items == nil {
+			return nil
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := spec.validateValue(schema.Items, v.Index(i), fmt.Sprintf("%s[%d]", at, i)); err != nil {
+				return err
+			}
+		}
+	case reflect.String:
+		return checkString(schema, v.String(), at)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return checkNumber(schema, float64(v.Int()), at)
+	case reflect.Float32, reflect.Float64:
+		return checkNumber(schema, v.Float(), at)
+	}
+	return nil
+}
+
+// jsonFields maps the JSON property names of a struct's fields, including
+// those of embedded structs, to their values
+func jsonFields(v reflect.Value) map[string]reflect.Value {
+	fields := map[string]reflect.Value{}
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" || (field.PkgPath != "" && !field.Anonymous) {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			for key, value := range jsonFields(v.Field(i)) {
+				fields[key] = value
+			}
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		fields[name] = v.Field(i)
+	}
+	return fields
+}
+
+func checkString(schema *Schema, s, at string) error {
+	if utf8.RuneCountInString(s) < schema.MinLength {
+		return fmt.Errorf("%s: must not be empty", at)
+	}
+	if len(schema.Enum) > 0 && !contains(schema.Enum, s) {
+		return fmt.Errorf("%s: must be one of %s, not '%s'", at, strings.Join(schema.Enum, ", "), s)
+	}
+	return nil
+}
+
+func checkNumber(schema *Schema, f float64, at string) error {
+	if schema.Minimum != nil && f < *schema.Minimum {
+		return fmt.Errorf("%s: must be at least %v", at, *schema.Minimum)
+	}
+	if schema.Maximum != nil && f > *schema.Maximum {
+		return fmt.Errorf("%s: must be at most %v", at, *schema.Maximum)
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,159 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package uberalls_test
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/uber/uberalls"
+)
+
+var _ = Describe("OpenAPI", func() {
+	var (
+		spec    *OpenAPI
+		handler http.Handler
+		body    string
+	)
+
+	send := func(method, target, requestBody string) *httptest.ResponseRecorder {
+		request, _ := http.NewRequest(method, target, strings.NewReader(requestBody))
+		response := httptest.NewRecorder()
+		handler.ServeHTTP(response, request)
+		return response
+	}
+
+	errorOf := func(response *httptest.ResponseRecorder) string {
+		var e map[string]string
+		Expect(json.Unmarshal(response.Body.Bytes(), &e)).To(Succeed())
+		return e["error"]
+	}
+
+	BeforeEach(func() {
+		spec = NewAPISpec()
+		body = ""
+		handler = spec.Validate(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			read, _ := ioutil.ReadAll(r.Body)
+			body = string(read)
+		}))
+	})
+
+	It("Should describe every endpoint and the Metric schema", func() {
+		response := httptest.NewRecorder()
+		spec.ServeHTTP(response, httptest.NewRequest("GET", "/openapi.json", nil))
+		Expect(response.Code).To(Equal(http.StatusOK))
+
+		var document map[string]interface{}
+		Expect(json.Unmarshal(response.Body.Bytes(), &document)).To(Succeed())
+		Expect(document["openapi"]).To(HavePrefix("3."))
+		Expect(document["paths"]).To(HaveKey("/metrics"))
+		Expect(document["paths"]).To(HaveKey("/repositories/{name}/branches"))
+
+		metric := document["components"].(map[string]interface{})["schemas"].(map[string]interface{})["Metric"].(map[string]interface{})
+		Expect(metric["required"]).To(ConsistOf("repository", "sha"))
+		Expect(metric["properties"]).To(HaveKey("lineCoverage"))
+		Expect(metric["properties"]).To(HaveKey("sourceFiles"))
+	})
+
+	It("Should pass valid requests through", func() {
+		Expect(send("GET", "/metrics?repository=foo&branch=master&until=100", "").Code).To(Equal(http.StatusOK))
+		Expect(send("GET", "/repositories/foo/bar/branches?limit=10", "").Code).To(Equal(http.StatusOK))
+		Expect(send("GET", "/unknown?anything=1", "").Code).To(Equal(http.StatusOK))
+
+		metric := `{"repository":"foo","sha":"abc","lineCoverage":75.5,"sourceFiles":[{"name":"a.go","coverage":[1,null,0],"source_digest":"x"}]}`
+		Expect(send("POST", "/metrics", metric).Code).To(Equal(http.StatusOK))
+		Expect(body).To(Equal(metric))
+	})
+
+	It("Should reject unknown and repeated parameters", func() {
+		response := send("GET", "/metrics?repository=foo&brnach=master", "")
+		Expect(response.Code).To(Equal(http.StatusBadRequest))
+		Expect(errorOf(response)).To(Equal("invalid request: unknown parameter 'brnach'"))
+
+		response = send("GET", "/metrics?repository=foo&sha=a&sha=b", "")
+		Expect(response.Code).To(Equal(http.StatusBadRequest))
+		Expect(errorOf(response)).To(Equal("invalid request: parameter 'sha' given 2 times"))
+	})
+
+	It("Should reject missing, empty and malformed parameters", func() {
+		response := send("GET", "/metrics?branch=master", "")
+		Expect(response.Code).To(Equal(http.StatusBadRequest))
+		Expect(errorOf(response)).To(Equal("invalid request: missing parameter 'repository'"))
+
+		response = send("GET", "/metrics?repository=foo&branch=", "")
+		Expect(response.Code).To(Equal(http.StatusBadRequest))
+		Expect(errorOf(response)).To(Equal("invalid request: parameter 'branch': must not be empty"))
+
+		response = send("GET", "/metrics?repository=foo&until=yesterday", "")
+		Expect(response.Code).To(Equal(http.StatusBadRequest))
+		Expect(errorOf(response)).To(Equal("invalid request: parameter 'until': must be an integer, not 'yesterday'"))
+
+		response = send("GET", "/repositories?limit=1000", "")
+		Expect(response.Code).To(Equal(http.StatusBadRequest))
+		Expect(errorOf(response)).To(Equal("invalid request: parameter 'limit': must be at most 500"))
+
+		response = send("GET", "/metrics/file?repository=foo&path=a.go&format=pdf", "")
+		Expect(response.Code).To(Equal(http.StatusBadRequest))
+		Expect(errorOf(response)).To(Equal("invalid request: parameter 'format': must be one of json, html, not 'pdf'"))
+	})
+
+	It("Should reject decoded bodies not matching their schema", func() {
+		request := httptest.NewRequest("POST", "/metrics", nil)
+		valid := Metric{Repository: "foo", Sha: "abc", LineCoverage: 75.5}
+		Expect(spec.ValidateBody(request, &valid)).To(Succeed())
+
+		for message, m := range map[string]Metric{
+			"body: missing property 'sha'":           {Repository: "foo"},
+			"body.lineCoverage: must be at most 100": {Repository: "foo", Sha: "abc", LineCoverage: 150},
+			"body.linesTested: must be at least 0":   {Repository: "foo", Sha: "abc", LinesTested: -1},
+		} {
+			Expect(spec.ValidateBody(request, &m)).To(MatchError(message))
+		}
+
+		grants := httptest.NewRequest("POST", "/admin/grants", nil)
+		Expect(spec.ValidateBody(grants, &Grant{Repository: "foo", UserName: "alice", Role: "owner"})).
+			To(MatchError("body.role: must be one of viewer, uploader, admin, not 'owner'"))
+	})
+
+	It("Should accept unknown properties in bodies", func() {
+		config := &Config{DBType: "sqlite3", DBLocation: "test.sqlite"}
+		db, _ := config.DB()
+		Expect(config.Automigrate()).To(Succeed())
+		handler = spec.Validate(NewMetricsHandler(db, nil))
+		response := send("POST", "/metrics", `{"repository": "openapi/repo", "sha": "abc", "Branch": "master", "jenkinsBuild": 42}`)
+		Expect(response.Code).To(Equal(http.StatusOK))
+
+		response = send("POST", "/metrics", `{"repository": "openapi/repo", "sha": "abc", "lineCoverage": 150}`)
+		Expect(response.Code).To(Equal(http.StatusBadRequest))
+		Expect(errorOf(response)).To(Equal("invalid request: body.lineCoverage: must be at most 100"))
+	})
+
+	It("Should reject undescribed methods", func() {
+		response := send("DELETE", "/metrics?repository=foo", "")
+		Expect(response.Code).To(Equal(http.StatusMethodNotAllowed))
+		Expect(errorOf(response)).To(Equal("unsupported method: DELETE"))
+	})
+})
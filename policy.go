@@ -0,0 +1,93 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package uberalls
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+
+	"github.com/uber/uberalls/coverage"
+)
+
+// PolicyConfig maps repositories to the coverage policy `uberalls check`
+// applies to them; "*" applies to repositories without their own
+type PolicyConfig map[string]coverage.Policy
+
+// Policy returns a repository's coverage policy
+func (pc PolicyConfig) Policy(repository string) coverage.Policy {
+	if policy, ok := pc[repository]; ok {
+		return policy
+	}
+	return pc["*"]
+}
+
+// PolicyHandler serves the configured coverage policies
+type PolicyHandler struct {
+	lock   *sync.RWMutex
+	policy *PolicyConfig
+}
+
+// NewPolicyHandler creates a new PolicyHandler
+func NewPolicyHandler(policy PolicyConfig) PolicyHandler {
+	return PolicyHandler{
+		lock:   new(sync.RWMutex),
+		policy: &policy,
+	}
+}
+
+// SetPolicy replaces the policies served
+func (ph PolicyHandler) SetPolicy(policy PolicyConfig) {
+	ph.lock.Lock()
+	defer ph.lock.Unlock()
+	*ph.policy = policy
+}
+
+// ServeHTTP handles GET /policy
+func (ph PolicyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method != "GET" {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		writeError(w, r, "unsupported method", errors.New(r.Method))
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, r, "error parsing params", err)
+		return
+	}
+
+	repository := r.Form.Get("repository")
+	if repository == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, r, "missing 'repository'", errors.New("need repository"))
+		return
+	}
+	if !authorize(w, r, repository, PermissionRead) {
+		return
+	}
+
+	ph.lock.RLock()
+	policy := ph.policy.Policy(repository)
+	ph.lock.RUnlock()
+	respondWithJSON(w, r, policy)
+}
@@ -0,0 +1,344 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package uberalls
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Repository visibilities
+const (
+	VisibilityPublic  = "public"
+	VisibilityPrivate = "private"
+)
+
+// Roles that can be granted on a repository
+const (
+	RoleViewer   = "viewer"
+	RoleUploader = "uploader"
+	RoleAdmin    = "admin"
+)
+
+// rolePermissions lists the permissions each role holds
+var rolePermissions = map[string][]string{
+	RoleViewer:   {PermissionRead},
+	RoleUploader: {PermissionRead, PermissionUpload},
+	RoleAdmin:    {PermissionRead, PermissionUpload, PermissionManage},
+}
+
+// Grant gives a user, or every member of a group, a role on a repository
+type Grant struct {
+	ID         int64  `gorm:"primary_key:yes" json:"id"`
+	Repository string `sql:"not null" json:"repository"`
+	UserName   string `json:"user,omitempty"`
+	GroupName  string `json:"group,omitempty"`
+	Role       string `sql:"not null" json:"role"`
+	Timestamp  int64  `sql:"not null" json:"timestamp"`
+}
+
+// GroupMember records a user's membership of a group
+type GroupMember struct {
+	ID        int64  `gorm:"primary_key:yes" json:"-"`
+	GroupName string `sql:"not null" json:"group"`
+	UserName  string `sql:"not null" json:"user"`
+}
+
+// VisibilityRequest sets a repository's visibility
+type VisibilityRequest struct {
+	Repository string `json:"repository"`
+	Visibility string `json:"visibility"`
+}
+
+// isPrivate reports whether a repository was made private
+func isPrivate(db *gorm.DB, repository string) bool {
+	r := Repository{}
+	db.Where("name = ?", repository).First(&r)
+	return r.Visibility == VisibilityPrivate
+}
+
+// groupsOf lists the groups a user belongs to
+func groupsOf(db *gorm.DB, user string) []string {
+	var groups []string
+	db.Model(GroupMember{}).Where("user_name = ?", user).Order("group_name").Pluck("group_name", &groups)
+	return groups
+}
+
+// hasRole reports whether a principal was granted a role holding a
+// permission on a repository, directly or through one of its groups
+func hasRole(db *gorm.DB, p Principal, repository, permission string) bool {
+	if p.User == "" && len(p.Groups) == 0 {
+		return false
+	}
+
+	var grants []Grant
+	query := db.Where("repository = ?", repository)
+	if len(p.Groups) > 0 {
+		query = query.Where("user_name = ? OR group_name IN (?)", p.User, p.Groups)
+	} else {
+		query = query.Where("user_name = ?", p.User)
+	}
+	query.Find(&grants)
+
+	for _, grant := range grants {
+		for _, granted := range rolePermissions[grant.Role] {
+			if granted == permission {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AccessHandler serves the admin API for repository visibility, grants and
+// groups
+type AccessHandler struct {
+	db *gorm.DB
+}
+
+// NewAccessHandler creates a new AccessHandler
+func NewAccessHandler(db *gorm.DB) AccessHandler {
+	return AccessHandler{db: db}
+}
+
+// ServeHTTP handles /admin/visibility, /admin/grants and /admin/groups
+func (ah AccessHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := r.ParseForm(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, r, "error parsing params", err)
+		return
+	}
+
+	switch strings.TrimSuffix(r.URL.Path, "/") {
+	case "/admin/visibility":
+		ah.handleVisibility(w, r)
+	case "/admin/grants":
+		ah.handleGrants(w, r)
+	case "/admin/groups":
+		ah.handleGroups(w, r)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		writeError(w, r, "unknown resource", errors.New(r.URL.Path))
+	}
+}
+
+func (ah AccessHandler) handleVisibility(w http.ResponseWriter, r *http.Request) {
+	if r.Method == "GET" {
+		repository := r.Form.Get("repository")
+		if !authorize(w, r, repository, PermissionRead) {
+			return
+		}
+		visibility := VisibilityPublic
+		if isPrivate(ah.db, repository) {
+			visibility = VisibilityPrivate
+		}
+		respondWithJSON(w, r, VisibilityRequest{repository, visibility})
+		return
+	}
+
+	request := VisibilityRequest{}
+	if !decodeBody(w, r, &request) {
+		return
+	}
+	if request.Repository == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, r, "missing 'repository'", errors.New("need repository"))
+		return
+	}
+	if request.Visibility != VisibilityPublic && request.Visibility != VisibilityPrivate {
+		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, r, "invalid 'visibility'", errors.New(request.Visibility))
+		return
+	}
+	if !authorize(w, r, request.Repository, PermissionManage) {
+		return
+	}
+
+	repository := Repository{}
+	ah.db.Where(Repository{Name: request.Repository}).
+		Attrs(Repository{Visibility: VisibilityPublic}).FirstOrCreate(&repository)
+	before := VisibilityRequest{repository.Name, repository.Visibility}
+	ah.db.Model(&repository).UpdateColumn("visibility", request.Visibility)
+	requestLogger(r).Info("Set visibility", "repository", request.Repository, "visibility", request.Visibility)
+	Audit(ah.db, r, AuditVisibilityUpdate, request.Repository, "repository/"+request.Repository, before, request)
+	respondWithJSON(w, r, request)
+}
+
+func (ah AccessHandler) handleGrants(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case "GET":
+		repository := r.Form.Get("repository")
+		if !authorize(w, r, repository, PermissionManage) {
+			return
+		}
+		var grants []Grant
+		ah.db.Where("repository = ?", repository).Order("id").Find(&grants)
+		respondWithJSON(w, r, grants)
+	case "POST":
+		grant := Grant{}
+		if !decodeBody(w, r, &grant) {
+			return
+		}
+		if !authorize(w, r, grant.Repository, PermissionManage) {
+			return
+		}
+		previous := ah.existingGrant(grant)
+		if err := ah.Grant(&grant); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			writeError(w, r, "error granting role", err)
+			return
+		}
+		if previous != nil {
+			Audit(ah.db, r, AuditGrantUpdate, grant.Repository, auditTarget("grant", grant.ID), previous, grant)
+		} else {
+			Audit(ah.db, r, AuditGrantCreate, grant.Repository, auditTarget("grant", grant.ID), nil, grant)
+		}
+		respondWithJSON(w, r, grant)
+	case "DELETE":
+		grant := Grant{}
+		ah.db.Where("id = ?", r.Form.Get("id")).First(&grant)
+		if grant.ID == 0 {
+			w.WriteHeader(http.StatusNotFound)
+			writeError(w, r, "no rows found", errors.New("-"))
+			return
+		}
+		if !authorize(w, r, grant.Repository, PermissionManage) {
+			return
+		}
+		ah.db.Delete(&grant)
+		requestLogger(r).Info("Revoked role", "role", grant.Role, "repository", grant.Repository, "user", grant.UserName, "group", grant.GroupName)
+		Audit(ah.db, r, AuditGrantDelete, grant.Repository, auditTarget("grant", grant.ID), grant, nil)
+		respondWithJSON(w, r, grant)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		writeError(w, r, "unsupported method", errors.New(r.Method))
+	}
+}
+
+// existingGrant finds the grant a new one for the same user or group would
+// replace
+func (ah AccessHandler) existingGrant(grant Grant) *Grant {
+	previous := Grant{}
+	ah.db.Where(
+		"repository = ? AND user_name = ? AND group_name = ?",
+		grant.Repository, grant.UserName, grant.GroupName,
+	).First(&previous)
+	if previous.ID == 0 {
+		return nil
+	}
+	return &previous
+}
+
+// Grant gives a role on a repository, replacing any role the user or group
+// already held there
+func (ah AccessHandler) Grant(grant *Grant) error {
+	if grant.Repository == "" {
+		return errors.New("missing 'repository'")
+	}
+	if (grant.UserName == "") == (grant.GroupName == "") {
+		return errors.New("exactly one of 'user' and 'group' is required")
+	}
+	if _, ok := rolePermissions[grant.Role]; !ok {
+		return errors.New("unknown role '" + grant.Role + "'")
+	}
+
+	ah.db.Where(
+		"repository = ? AND user_name = ? AND group_name = ?",
+		grant.Repository, grant.UserName, grant.GroupName,
+	).Delete(Grant{})
+	grant.ID = 0
+	grant.Timestamp = time.Now().Unix()
+	if err := ah.db.Create(grant).Error; err != nil {
+		return err
+	}
+	logger.Info("Granted role", "role", grant.Role, "repository", grant.Repository, "user", grant.UserName, "group", grant.GroupName)
+	return nil
+}
+
+func (ah AccessHandler) handleGroups(w http.ResponseWriter, r *http.Request) {
+	if status, err := isAdmin(r); err != nil {
+		w.WriteHeader(status)
+		writeError(w, r, "unauthorized", err)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		var members []GroupMember
+		query := ah.db.Order("group_name, user_name")
+		if group := r.Form.Get("group"); group != "" {
+			query = query.Where("group_name = ?", group)
+		}
+		query.Find(&members)
+		respondWithJSON(w, r, members)
+	case "POST":
+		member := GroupMember{}
+		if !decodeBody(w, r, &member) {
+			return
+		}
+		if member.GroupName == "" || member.UserName == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			writeError(w, r, "missing 'group' or 'user'", errors.New("need group and user"))
+			return
+		}
+		ah.db.Where(member).FirstOrCreate(&member)
+		requestLogger(r).Info("Added group member", "group", member.GroupName, "user", member.UserName)
+		Audit(ah.db, r, AuditGroupCreate, "", "group/"+member.GroupName, nil, member)
+		respondWithJSON(w, r, member)
+	case "DELETE":
+		member := GroupMember{GroupName: r.Form.Get("group"), UserName: r.Form.Get("user")}
+		ah.db.Where("group_name = ? AND user_name = ?", member.GroupName, member.UserName).Delete(GroupMember{})
+		requestLogger(r).Info("Removed group member", "group", member.GroupName, "user", member.UserName)
+		Audit(ah.db, r, AuditGroupDelete, "", "group/"+member.GroupName, member, nil)
+		respondWithJSON(w, r, member)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		writeError(w, r, "unsupported method", errors.New(r.Method))
+	}
+}
+
+// decodeBody decodes a JSON request body and validates it against the API
+// spec, responding with an error if it can't or it doesn't match
+func decodeBody(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if r.Body == nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, r, "no response body", errors.New("nil body"))
+		return false
+	}
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		w.WriteHeader(decodeStatus(err))
+		writeError(w, r, "unable to decode body", err)
+		return false
+	}
+	if err := apiSpec.ValidateBody(r, v); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, r, "invalid request", err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,148 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package uberalls_test
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/uber/uberalls"
+)
+
+var _ = Describe("Role-based access control", func() {
+	var (
+		db         *gorm.DB
+		config     *Config
+		metrics    http.Handler
+		access     http.Handler
+		repository string
+		alice      string
+		bob        string
+		carol      string
+	)
+
+	mintFor := func(user string) string {
+		minted, err := NewTokensHandler(db).Mint(TokenRequest{Name: user + "-token", User: user})
+		Expect(err).ToNot(HaveOccurred())
+		return minted.Secret
+	}
+
+	BeforeEach(func() {
+		config = &Config{
+			DBType:     "sqlite3",
+			DBLocation: "test.sqlite",
+			AdminToken: "admin-secret",
+		}
+		db, _ = config.DB()
+		Expect(config.Automigrate()).To(Succeed())
+
+		suffix := fmt.Sprintf("%x", time.Now().UnixNano())
+		repository = "private/" + suffix
+		alice = mintFor("alice-" + suffix)
+		bob = mintFor("bob-" + suffix)
+		carol = mintFor("carol-" + suffix)
+
+		auth := NewAuth(db, config)
+		metrics = auth.Wrap(NewMetricsHandler(db, nil))
+		access = auth.Wrap(NewAccessHandler(db))
+
+		record := fmt.Sprintf(`{"repository": %q, "sha": "deadbeef"}`, repository)
+		Expect(getAuthenticatedResponse(metrics, "POST", "/metrics", record, "").Code).To(Equal(http.StatusOK))
+
+		body := fmt.Sprintf(`{"repository": %q, "user": "alice-%s", "role": "admin"}`, repository, suffix)
+		Expect(getAuthenticatedResponse(access, "POST", "/admin/grants", body, "admin-secret").Code).To(Equal(http.StatusOK))
+
+		body = fmt.Sprintf(`{"group": "team-%s", "user": "bob-%s"}`, suffix, suffix)
+		Expect(getAuthenticatedResponse(access, "POST", "/admin/groups", body, "admin-secret").Code).To(Equal(http.StatusOK))
+
+		body = fmt.Sprintf(`{"repository": %q, "group": "team-%s", "role": "viewer"}`, repository, suffix)
+		Expect(getAuthenticatedResponse(access, "POST", "/admin/grants", body, alice).Code).To(Equal(http.StatusOK))
+	})
+
+	query := func(secret string) int {
+		url := fmt.Sprintf("/metrics?repository=%s&sha=deadbeef", repository)
+		return getAuthenticatedResponse(metrics, "GET", url, "", secret).Code
+	}
+
+	It("Should keep public repositories open", func() {
+		Expect(query("")).To(Equal(http.StatusOK))
+	})
+
+	It("Should only let repository admins manage grants", func() {
+		url := "/admin/grants?repository=" + repository
+		Expect(getAuthenticatedResponse(access, "GET", url, "", alice).Code).To(Equal(http.StatusOK))
+		Expect(getAuthenticatedResponse(access, "GET", url, "", bob).Code).To(Equal(http.StatusForbidden))
+	})
+
+	It("Should only let admins manage groups", func() {
+		Expect(getAuthenticatedResponse(access, "GET", "/admin/groups", "", alice).Code).To(Equal(http.StatusForbidden))
+	})
+
+	It("Should reject unknown roles", func() {
+		body := fmt.Sprintf(`{"repository": %q, "user": "dave", "role": "owner"}`, repository)
+		Expect(getAuthenticatedResponse(access, "POST", "/admin/grants", body, alice).Code).To(Equal(http.StatusBadRequest))
+	})
+
+	Context("Once the repository is private", func() {
+		BeforeEach(func() {
+			body := fmt.Sprintf(`{"repository": %q, "visibility": "private"}`, repository)
+			Expect(getAuthenticatedResponse(access, "POST", "/admin/visibility", body, alice).Code).To(Equal(http.StatusOK))
+		})
+
+		It("Should reject anonymous reads", func() {
+			Expect(query("")).To(Equal(http.StatusUnauthorized))
+		})
+
+		It("Should reject users without a role", func() {
+			Expect(query(carol)).To(Equal(http.StatusForbidden))
+		})
+
+		It("Should allow viewers through their group", func() {
+			Expect(query(bob)).To(Equal(http.StatusOK))
+		})
+
+		It("Should not let viewers upload", func() {
+			record := fmt.Sprintf(`{"repository": %q, "sha": "cafe"}`, repository)
+			Expect(getAuthenticatedResponse(metrics, "POST", "/metrics", record, bob).Code).To(Equal(http.StatusForbidden))
+			Expect(getAuthenticatedResponse(metrics, "POST", "/metrics", record, alice).Code).To(Equal(http.StatusOK))
+		})
+
+		It("Should report the visibility", func() {
+			response := getAuthenticatedResponse(access, "GET", "/admin/visibility?repository="+repository, "", bob)
+			Expect(response.Code).To(Equal(http.StatusOK))
+			Expect(response.Body.String()).To(ContainSubstring(VisibilityPrivate))
+		})
+
+		It("Should hide it from repository listings", func() {
+			listing := NewAuth(db, config).Wrap(NewRepositoriesHandler(db))
+			response := getAuthenticatedResponse(listing, "GET", "/repositories?prefix="+repository, "", carol)
+			Expect(response.Body.String()).ToNot(ContainSubstring(repository))
+
+			response = getAuthenticatedResponse(listing, "GET", "/repositories?prefix="+repository, "", bob)
+			Expect(response.Body.String()).To(ContainSubstring(repository))
+		})
+	})
+})
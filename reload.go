@@ -0,0 +1,354 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package uberalls
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// configCheckInterval is how often config files are checked for changes
+const configCheckInterval = 5 * time.Second
+
+// reloadableSections are the top-level settings, and sections of settings,
+// that take effect when the configuration is reloaded. The others, like the
+// database and listen address, need a restart.
+var reloadableSections = []string{
+	"log",
+	"limits",
+	"components",
+	"authRequired",
+	"anonymousRead",
+	"adminToken",
+	"oidc",
+	"policy",
+}
+
+func isReloadable(path []string) bool {
+	for _, section := range reloadableSections {
+		if strings.EqualFold(path[0], section) {
+			return true
+		}
+	}
+	return false
+}
+
+// ConfigValue is a setting of the active configuration
+type ConfigValue struct {
+	Value      json.RawMessage `json:"value"`
+	Source     string          `json:"source,omitempty"`
+	Reloadable bool            `json:"reloadable"`
+}
+
+// ConfigStatus describes the active configuration
+type ConfigStatus struct {
+	// Version counts the configurations applied, from 1 at startup
+	Version int `json:"version"`
+	// Checksum identifies the active settings, with secrets redacted
+	Checksum string   `json:"checksum"`
+	LoadedAt int64    `json:"loadedAt"`
+	Files    []string `json:"files"`
+	// RestartRequired lists changed settings that only take effect after a
+	// restart
+	RestartRequired []string `json:"restartRequired,omitempty"`
+	// LastError is why the last reload failed, if it did
+	LastError string                 `json:"lastError,omitempty"`
+	Settings  map[string]ConfigValue `json:"settings"`
+}
+
+// Reloader reloads the configuration from the layers it was loaded from,
+// applying the settings that can change without a restart
+type Reloader struct {
+	args    []string
+	lock    *sync.Mutex
+	applied []func(*Config) error
+
+	config          *Config
+	provenance      Provenance
+	version         int
+	loadedAt        time.Time
+	files           []string
+	modTimes        map[string]time.Time
+	restartRequired []string
+	lastError       error
+}
+
+// NewReloader creates a Reloader for a configuration loaded with args. The
+// process-wide logger is reconfigured on every reload.
+func NewReloader(config *Config, provenance Provenance, args []string) *Reloader {
+	if provenance == nil {
+		provenance = Provenance{}
+	}
+	files, _ := ConfigFiles(args)
+	r := &Reloader{
+		args:       args,
+		lock:       new(sync.Mutex),
+		config:     config,
+		provenance: provenance,
+		version:    1,
+		loadedAt:   time.Now(),
+		files:      files,
+		modTimes:   modTimes(files),
+	}
+	r.OnReload(func(c *Config) error {
+		return ConfigureLogging(c.Log)
+	})
+	return r
+}
+
+// OnReload registers a function applying reloaded settings. Functions are
+// called in the order they were registered; if one fails, those already called
+// are called again with the previous configuration.
+func (r *Reloader) OnReload(apply func(*Config) error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.applied = append(r.applied, apply)
+}
+
+// Config returns the active configuration
+func (r *Reloader) Config() *Config {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.config
+}
+
+// Reload loads and validates the configuration again, then applies its
+// reloadable settings. The active configuration is kept if the new one is
+// invalid or can't be applied.
+func (r *Reloader) Reload() error {
+	return r.reload(nil)
+}
+
+// ReloadIfChanged reloads the configuration if any of its files changed since
+// they were last loaded, reporting whether they did
+func (r *Reloader) ReloadIfChanged() (bool, error) {
+	r.lock.Lock()
+	changed := !sameModTimes(modTimes(r.files), r.modTimes)
+	r.lock.Unlock()
+	if !changed {
+		return false, nil
+	}
+	return true, r.Reload()
+}
+
+// WatchFilesEvery reloads the configuration whenever its files change,
+// checking them at an interval
+func (r *Reloader) WatchFilesEvery(interval time.Duration) {
+	for {
+		time.Sleep(interval)
+		r.ReloadIfChanged()
+	}
+}
+
+// WatchSignals reloads the configuration whenever a signal is received, such
+// as SIGHUP
+func (r *Reloader) WatchSignals(signals <-chan os.Signal) {
+	for signal := range signals {
+		logger.Info("Reloading configuration", "signal", signal)
+		r.Reload()
+	}
+}
+
+// reload reloads the configuration, auditing the change as made by req, or by
+// uberalls itself when req is nil
+func (r *Reloader) reload(req *http.Request) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	files, err := ConfigFiles(r.args)
+	if err != nil {
+		return r.failed(err)
+	}
+	// Files changing while they're loaded are loaded again next time
+	r.files, r.modTimes = files, modTimes(files)
+
+	loaded, provenance, err := LoadValidated(r.args)
+	if err != nil {
+		return r.failed(err)
+	}
+
+	next := *r.config
+	nextProvenance := Provenance{}
+	for key, source := range r.provenance {
+		nextProvenance[key] = source
+	}
+	var changed, restartRequired []string
+	for _, s := range Settings() {
+		if reflect.DeepEqual(s.field(loaded).Interface(), s.field(r.config).Interface()) {
+			continue
+		}
+		if !s.Reloadable {
+			restartRequired = append(restartRequired, s.Key)
+			continue
+		}
+		s.field(&next).Set(s.field(loaded))
+		nextProvenance[s.Key] = provenance[s.Key]
+		changed = append(changed, s.Key)
+	}
+	r.restartRequired = restartRequired
+	if len(restartRequired) > 0 {
+		logger.Warn("Changed settings need a restart", "settings", strings.Join(restartRequired, ","))
+	}
+	if len(changed) == 0 {
+		r.lastError = nil
+		return nil
+	}
+
+	for i, apply := range r.applied {
+		if err := apply(&next); err != nil {
+			for _, undo := range r.applied[:i+1] {
+				undo(r.config)
+			}
+			return r.failed(err)
+		}
+	}
+
+	before, after := map[string]json.RawMessage{}, map[string]json.RawMessage{}
+	for _, s := range Settings() {
+		for _, key := range changed {
+			if s.Key == key {
+				before[key] = displayJSON(s.Display(r.config))
+				after[key] = displayJSON(s.Display(&next))
+			}
+		}
+	}
+
+	r.config = &next
+	r.provenance = nextProvenance
+	r.version++
+	r.loadedAt = time.Now()
+	r.lastError = nil
+	logger.Info("Configuration reloaded", "version", r.version, "changed", strings.Join(changed, ","))
+
+	if db, err := r.config.DB(); err == nil {
+		Audit(db, req, AuditConfigReload, "", "config/"+strconv.Itoa(r.version), before, after)
+	}
+	return nil
+}
+
+// failed records why a reload failed, keeping the active configuration
+func (r *Reloader) failed(err error) error {
+	r.lastError = err
+	logger.Error("Configuration not reloaded", "error", err)
+	return err
+}
+
+// Status describes the active configuration, redacting secrets
+func (r *Reloader) Status() ConfigStatus {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	status := ConfigStatus{
+		Version:         r.version,
+		LoadedAt:        r.loadedAt.Unix(),
+		Files:           r.files,
+		RestartRequired: r.restartRequired,
+		Settings:        map[string]ConfigValue{},
+	}
+	if r.lastError != nil {
+		status.LastError = r.lastError.Error()
+	}
+
+	sum := sha256.New()
+	for _, s := range Settings() {
+		value := s.Display(r.config)
+		sum.Write([]byte(s.Key + "=" + value + "\n"))
+		status.Settings[s.Key] = ConfigValue{
+			Value:      displayJSON(value),
+			Source:     r.provenance[s.Key],
+			Reloadable: s.Reloadable,
+		}
+	}
+	status.Checksum = hex.EncodeToString(sum.Sum(nil))
+	return status
+}
+
+// ServeHTTP handles /admin/config: GET reports the active configuration, and
+// POST reloads it
+func (r *Reloader) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if status, err := isAdmin(req); err != nil {
+		w.WriteHeader(status)
+		writeError(w, req, "unauthorized", err)
+		return
+	}
+
+	switch req.Method {
+	case "GET":
+		respondWithJSON(w, req, r.Status())
+	case "POST":
+		if err := r.reload(req); err != nil {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			writeError(w, req, "configuration not reloaded", err)
+			return
+		}
+		respondWithJSON(w, req, r.Status())
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		writeError(w, req, "unsupported method", errors.New(req.Method))
+	}
+}
+
+// displayJSON embeds a value formatted by Setting.Display in JSON, quoting
+// those that aren't JSON, like redacted secrets
+func displayJSON(value string) json.RawMessage {
+	if json.Valid([]byte(value)) {
+		return json.RawMessage(value)
+	}
+	quoted, _ := json.Marshal(value)
+	return json.RawMessage(quoted)
+}
+
+// modTimes returns the modification time of each file, or the zero time for
+// files that can't be read
+func modTimes(files []string) map[string]time.Time {
+	times := map[string]time.Time{}
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			times[file] = info.ModTime()
+		} else {
+			times[file] = time.Time{}
+		}
+	}
+	return times
+}
+
+func sameModTimes(a, b map[string]time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for file, t := range a {
+		if other, ok := b[file]; !ok || !other.Equal(t) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,179 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package uberalls_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/uber/uberalls"
+)
+
+var _ = Describe("Reloading configuration", func() {
+	var (
+		file     string
+		reloader *Reloader
+		mux      *http.ServeMux
+	)
+
+	writeConfig := func(extra string) {
+		content := `{
+			"dbType": "sqlite3",
+			"dbLocation": "test.sqlite",
+			"listenPort": 14740,
+			"adminToken": "admin-secret"` + extra + `
+		}`
+		Expect(ioutil.WriteFile(file, []byte(content), 0600)).To(Succeed())
+	}
+
+	readMetrics := func() int {
+		return getAuthenticatedResponse(mux, "GET", "/metrics?repository=reload/repo", "", "").Code
+	}
+
+	BeforeEach(func() {
+		f, _ := ioutil.TempFile("", "uberalls-config")
+		f.Close()
+		file = f.Name()
+		writeConfig("")
+
+		args := []string{"--config", file}
+		config, provenance, err := LoadValidated(args)
+		Expect(err).ToNot(HaveOccurred())
+		reloader = NewReloader(config, provenance, args)
+		mux = MakeReloadingServeMux(reloader)
+	})
+
+	AfterEach(func() {
+		os.Remove(file)
+	})
+
+	It("Should report the active configuration to admins", func() {
+		response := getAuthenticatedResponse(mux, "GET", "/admin/config", "", "admin-secret")
+		Expect(response.Code).To(Equal(http.StatusOK))
+
+		var status ConfigStatus
+		Expect(json.Unmarshal(response.Body.Bytes(), &status)).To(Succeed())
+		Expect(status.Version).To(Equal(1))
+		Expect(status.Checksum).ToNot(BeEmpty())
+		Expect(status.Files).To(Equal([]string{file}))
+		var adminToken string
+		Expect(json.Unmarshal(status.Settings["adminToken"].Value, &adminToken)).To(Succeed())
+		Expect(adminToken).To(Equal("<redacted>"))
+		Expect(status.Settings["adminToken"].Source).To(Equal("file:" + file))
+		Expect(status.Settings["authRequired"].Reloadable).To(BeTrue())
+		Expect(status.Settings["dbType"].Reloadable).To(BeFalse())
+
+		response = getAuthenticatedResponse(mux, "GET", "/admin/config", "", "")
+		Expect(response.Code).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("Should apply reloaded policies", func() {
+		Expect(readMetrics()).ToNot(Equal(http.StatusUnauthorized))
+		before := reloader.Status().Checksum
+
+		writeConfig(`, "authRequired": true, "limits": {"ratePerSecond": 100}`)
+		Expect(reloader.Reload()).To(Succeed())
+
+		Expect(readMetrics()).To(Equal(http.StatusUnauthorized))
+		status := reloader.Status()
+		Expect(status.Version).To(Equal(2))
+		Expect(status.Checksum).ToNot(Equal(before))
+		Expect(status.LastError).To(BeEmpty())
+		Expect(reloader.Config().Limits.RatePerSecond).To(Equal(100.0))
+
+		db, _ := reloader.Config().DB()
+		entry := AuditEntry{}
+		db.Where("action = ?", AuditConfigReload).Last(&entry)
+		Expect(entry.Actor).To(Equal("system"))
+		Expect(entry.AfterJSON).To(ContainSubstring(`"authRequired":true`))
+	})
+
+	It("Should reload when asked by an admin", func() {
+		writeConfig(`, "anonymousRead": true`)
+		response := getAuthenticatedResponse(mux, "POST", "/admin/config", "", "admin-secret")
+		Expect(response.Code).To(Equal(http.StatusOK))
+		Expect(reloader.Status().Version).To(Equal(2))
+		Expect(reloader.Config().AnonymousRead).To(BeTrue())
+	})
+
+	It("Should keep the active configuration when the new one is invalid", func() {
+		writeConfig(`, "authRequired": true, "log": {"level": "loud"}`)
+		Expect(reloader.Reload()).ToNot(Succeed())
+
+		Expect(readMetrics()).ToNot(Equal(http.StatusUnauthorized))
+		status := reloader.Status()
+		Expect(status.Version).To(Equal(1))
+		Expect(status.LastError).To(ContainSubstring("log.level"))
+
+		response := getAuthenticatedResponse(mux, "POST", "/admin/config", "", "admin-secret")
+		Expect(response.Code).To(Equal(http.StatusUnprocessableEntity))
+	})
+
+	It("Should roll back when a setting can't be applied", func() {
+		var applied []bool
+		reloader.OnReload(func(c *Config) error {
+			applied = append(applied, c.AuthRequired)
+			if c.AuthRequired {
+				return errors.New("cannot apply")
+			}
+			return nil
+		})
+
+		writeConfig(`, "authRequired": true`)
+		Expect(reloader.Reload()).To(MatchError("cannot apply"))
+
+		Expect(applied).To(Equal([]bool{true, false}))
+		Expect(readMetrics()).ToNot(Equal(http.StatusUnauthorized))
+		Expect(reloader.Config().AuthRequired).To(BeFalse())
+		Expect(reloader.Status().Version).To(Equal(1))
+	})
+
+	It("Should report settings that need a restart", func() {
+		writeConfig(`, "listenPort": 8080`)
+		Expect(reloader.Reload()).To(Succeed())
+
+		status := reloader.Status()
+		Expect(status.Version).To(Equal(1))
+		Expect(status.RestartRequired).To(Equal([]string{"listenPort"}))
+		Expect(reloader.Config().ListenPort).To(Equal(14740))
+	})
+
+	It("Should reload when its files change", func() {
+		changed, err := reloader.ReloadIfChanged()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changed).To(BeFalse())
+
+		writeConfig(`, "authRequired": true`)
+		later := time.Now().Add(time.Minute)
+		Expect(os.Chtimes(file, later, later)).To(Succeed())
+
+		changed, err = reloader.ReloadIfChanged()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(changed).To(BeTrue())
+		Expect(readMetrics()).To(Equal(http.StatusUnauthorized))
+	})
+})
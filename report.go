@@ -0,0 +1,50 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package uberalls
+
+import (
+	"github.com/uber/uberalls/coverage"
+)
+
+// SourceFile holds line-level coverage for a single file
+type SourceFile = coverage.SourceFile
+
+// FileCoverage is the stored summary of a file's coverage within a report
+type FileCoverage struct {
+	ID              int64  `gorm:"primary_key:yes" json:"-"`
+	MetricID        int64  `sql:"not null" json:"-"`
+	Path            string `sql:"not null" json:"path"`
+	LinesCovered    int64  `sql:"not null" json:"linesCovered"`
+	LinesTested     int64  `sql:"not null" json:"linesTested"`
+	BranchesCovered int64  `sql:"not null" json:"branchesCovered"`
+	BranchesTested  int64  `sql:"not null" json:"branchesTested"`
+}
+
+// NewFileCoverage summarizes a source file for a metric
+func NewFileCoverage(metricID int64, f SourceFile) FileCoverage {
+	fc := FileCoverage{
+		MetricID: metricID,
+		Path:     coverage.CleanPath(f.Name),
+	}
+	fc.LinesCovered, fc.LinesTested = f.LineCounts()
+	fc.BranchesCovered, fc.BranchesTested = f.BranchCounts()
+	return fc
+}
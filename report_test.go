@@ -0,0 +1,56 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package uberalls_test
+
+import (
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/uber/uberalls"
+)
+
+func hits(counts ...int64) []*int64 {
+	lines := make([]*int64, len(counts))
+	for i, count := range counts {
+		if count >= 0 {
+			c := count
+			lines[i] = &c
+		}
+	}
+	return lines
+}
+
+var _ = Describe("File summaries", func() {
+	It("Should count a file's lines and branches under its clean path", func() {
+		fc := NewFileCoverage(7, SourceFile{
+			Name:     "./src//main.go",
+			Coverage: hits(-1, 1, 0, 3),
+			Branches: []int64{2, 0, 0, 1, 2, 0, 1, 0},
+		})
+		Expect(fc).To(Equal(FileCoverage{
+			MetricID:        7,
+			Path:            "src/main.go",
+			LinesCovered:    2,
+			LinesTested:     3,
+			BranchesCovered: 1,
+			BranchesTested:  2,
+		}))
+	})
+})
@@ -0,0 +1,325 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package uberalls
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Pagination limits for discovery endpoints
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+// Repository tracks every repository uberalls has received metrics for
+type Repository struct {
+	ID             int64   `gorm:"primary_key:yes" json:"-"`
+	Name           string  `sql:"not null" json:"name"`
+	UploadCount    int64   `sql:"not null" json:"uploadCount"`
+	LastUpload     int64   `sql:"not null" json:"lastUpload"`
+	LatestMetricID int64   `sql:"not null" json:"-"`
+	Visibility     string  `sql:"not null;default:'public'" json:"visibility"`
+	Latest         *Metric `sql:"-" json:"latest,omitempty"`
+}
+
+// Branch tracks every branch of a repository uberalls has received metrics for
+type Branch struct {
+	ID             int64   `gorm:"primary_key:yes" json:"-"`
+	Repository     string  `sql:"not null" json:"repository"`
+	Name           string  `sql:"not null" json:"name"`
+	UploadCount    int64   `sql:"not null" json:"uploadCount"`
+	LastUpload     int64   `sql:"not null" json:"lastUpload"`
+	LatestMetricID int64   `sql:"not null" json:"-"`
+	Latest         *Metric `sql:"-" json:"latest,omitempty"`
+}
+
+// RepositoriesPage is one page of a discovery listing
+type RepositoriesPage struct {
+	Repositories []Repository `json:"repositories,omitempty"`
+	Branches     []Branch     `json:"branches,omitempty"`
+	// Next is the 'after' parameter for the following page, if there is one
+	Next string `json:"next,omitempty"`
+}
+
+// trackUpload counts a metric towards its repository and branch, making it
+// their latest unless a more recent one was already recorded. It runs in the
+// transaction recording the metric.
+func trackUpload(tx *gorm.DB, m Metric) error {
+	if m.Component != "" {
+		return nil
+	}
+
+	repository := Repository{}
+	if err := tx.Where(Repository{Name: m.Repository}).
+		Attrs(Repository{Visibility: VisibilityPublic}).FirstOrCreate(&repository).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&repository).UpdateColumn("upload_count", gorm.Expr("upload_count + 1")).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(Repository{}).Where("id = ? AND last_upload <= ?", repository.ID, m.Timestamp).
+		UpdateColumns(map[string]interface{}{"last_upload": m.Timestamp, "latest_metric_id": m.ID}).Error; err != nil {
+		return err
+	}
+
+	if m.Branch == "" {
+		return nil
+	}
+	branch := Branch{}
+	if err := tx.Where(Branch{Repository: m.Repository, Name: m.Branch}).FirstOrCreate(&branch).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&branch).UpdateColumn("upload_count", gorm.Expr("upload_count + 1")).Error; err != nil {
+		return err
+	}
+	return tx.Model(Branch{}).Where("id = ? AND last_upload <= ?", branch.ID, m.Timestamp).
+		UpdateColumns(map[string]interface{}{"last_upload": m.Timestamp, "latest_metric_id": m.ID}).Error
+}
+
+// uploadSummary counts the uploads of a repository, or of one of its branches
+type uploadSummary struct {
+	Repository  string
+	Branch      string
+	UploadCount int64
+	LastUpload  int64
+}
+
+// backfillRepositories populates the repository and branch tables from
+// metrics recorded before they existed
+func backfillRepositories(db *gorm.DB) error {
+	count := 0
+	db.Model(Repository{}).Count(&count)
+	if count > 0 {
+		return nil
+	}
+
+	tx := db.Begin()
+	if err := backfillUploads(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+func backfillUploads(tx *gorm.DB) error {
+	var repositories, branches []uploadSummary
+	if err := tx.Model(Metric{}).Select("repository, COUNT(*) AS upload_count, MAX(timestamp) AS last_upload").
+		Where("component = ?", "").Group("repository").Scan(&repositories).Error; err != nil {
+		return err
+	}
+	for _, summary := range repositories {
+		latest, err := lastUploadID(tx, summary)
+		if err != nil {
+			return err
+		}
+		repository := Repository{
+			Name:           summary.Repository,
+			UploadCount:    summary.UploadCount,
+			LastUpload:     summary.LastUpload,
+			LatestMetricID: latest,
+			Visibility:     VisibilityPublic,
+		}
+		if err := tx.Create(&repository).Error; err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Model(Metric{}).Select("repository, branch, COUNT(*) AS upload_count, MAX(timestamp) AS last_upload").
+		Where("component = ? AND branch <> ?", "", "").Group("repository, branch").Scan(&branches).Error; err != nil {
+		return err
+	}
+	for _, summary := range branches {
+		latest, err := lastUploadID(tx, summary)
+		if err != nil {
+			return err
+		}
+		branch := Branch{
+			Repository:     summary.Repository,
+			Name:           summary.Branch,
+			UploadCount:    summary.UploadCount,
+			LastUpload:     summary.LastUpload,
+			LatestMetricID: latest,
+		}
+		if err := tx.Create(&branch).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// lastUploadID finds the metric recorded by the last upload a summary counts
+func lastUploadID(tx *gorm.DB, summary uploadSummary) (int64, error) {
+	query := tx.Where("repository = ? AND component = ? AND timestamp = ?", summary.Repository, "", summary.LastUpload)
+	if summary.Branch != "" {
+		query = query.Where("branch = ?", summary.Branch)
+	}
+	m := Metric{}
+	err := query.Order("id desc").First(&m).Error
+	return m.ID, err
+}
+
+// RepositoriesHandler serves the repositories and branches known to uberalls
+type RepositoriesHandler struct {
+	db *gorm.DB
+}
+
+// NewRepositoriesHandler creates a new RepositoriesHandler
+func NewRepositoriesHandler(db *gorm.DB) RepositoriesHandler {
+	return RepositoriesHandler{db: db}
+}
+
+// ServeHTTP handles GET /repositories and GET /repositories/{name}/branches
+func (rh RepositoriesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := r.ParseForm(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, r, "error parsing params", err)
+		return
+	}
+
+	limit, err := pageSize(r.Form)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, r, "invalid 'limit'", err)
+		return
+	}
+
+	var page RepositoriesPage
+	path := strings.TrimSuffix(r.URL.Path, "/")
+	switch {
+	case path == "/repositories":
+		page = rh.Repositories(r.Form.Get("prefix"), r.Form.Get("after"), limit, readable(r))
+	case strings.HasPrefix(path, "/repositories/") && strings.HasSuffix(path, "/branches"):
+		name := strings.TrimSuffix(strings.TrimPrefix(path, "/repositories/"), "/branches")
+		if !authorize(w, r, name, PermissionRead) {
+			return
+		}
+		page = rh.Branches(name, r.Form.Get("prefix"), r.Form.Get("after"), limit)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		writeError(w, r, "unknown resource", errors.New(r.URL.Path))
+		return
+	}
+
+	bodyString, err := json.Marshal(page)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, r, "unable to encode response", err)
+		return
+	}
+	w.Write(bodyString)
+}
+
+// Repositories lists the repositories readable reports true for by name,
+// starting after a given name. Unreadable repositories are skipped before
+// paging, so they neither shorten pages nor show up as 'next'.
+func (rh RepositoriesHandler) Repositories(prefix, after string, limit int, readable func(string) bool) RepositoriesPage {
+	var repositories []Repository
+	for {
+		var batch []Repository
+		rh.pageQuery(rh.db, prefix, after, limit).Find(&batch)
+		for _, repository := range batch {
+			if readable(repository.Name) {
+				repositories = append(repositories, repository)
+			}
+		}
+		if len(batch) <= limit || len(repositories) > limit {
+			break
+		}
+		after = batch[len(batch)-1].Name
+	}
+
+	page := RepositoriesPage{Repositories: repositories}
+	if len(repositories) > limit {
+		page.Repositories = repositories[:limit]
+		page.Next = page.Repositories[limit-1].Name
+	}
+	for i := range page.Repositories {
+		page.Repositories[i].Latest = rh.metric(page.Repositories[i].LatestMetricID)
+	}
+	return page
+}
+
+// Branches lists a repository's branches by name, starting after a given name
+func (rh RepositoriesHandler) Branches(repository, prefix, after string, limit int) RepositoriesPage {
+	var branches []Branch
+	rh.pageQuery(rh.db.Where("repository = ?", repository), prefix, after, limit).Find(&branches)
+
+	page := RepositoriesPage{Branches: branches}
+	if len(branches) > limit {
+		page.Branches = branches[:limit]
+		page.Next = page.Branches[limit-1].Name
+	}
+	for i := range page.Branches {
+		page.Branches[i].Latest = rh.metric(page.Branches[i].LatestMetricID)
+	}
+	return page
+}
+
+// readable reports whether a request may read a repository
+func readable(r *http.Request) func(string) bool {
+	return func(repository string) bool {
+		_, err := permitted(r, repository, PermissionRead)
+		return err == nil
+	}
+}
+
+// pageQuery selects one more row than the page holds, to detect a next page
+func (rh RepositoriesHandler) pageQuery(db *gorm.DB, prefix, after string, limit int) *gorm.DB {
+	if prefix != "" {
+		db = db.Where("name LIKE ? ESCAPE '!'", escapeLike(prefix)+"%")
+	}
+	if after != "" {
+		db = db.Where("name > ?", after)
+	}
+	return db.Order("name").Limit(limit + 1)
+}
+
+func (rh RepositoriesHandler) metric(id int64) *Metric {
+	m := new(Metric)
+	rh.db.Where("id = ?", id).First(m)
+	if m.ID == 0 {
+		return nil
+	}
+	return m
+}
+
+func pageSize(form map[string][]string) (int, error) {
+	if len(form["limit"]) < 1 {
+		return defaultPageSize, nil
+	}
+	limit, err := strconv.Atoi(form["limit"][0])
+	if err != nil {
+		return 0, err
+	}
+	if limit < 1 || limit > maxPageSize {
+		return 0, errors.New("must be between 1 and " + strconv.Itoa(maxPageSize))
+	}
+	return limit, nil
+}
@@ -0,0 +1,221 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package uberalls_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/uber/uberalls"
+)
+
+func getRepositoriesResponse(url string, db *gorm.DB) *httptest.ResponseRecorder {
+	request, _ := http.NewRequest("GET", url, nil)
+	response := httptest.NewRecorder()
+	NewRepositoriesHandler(db).ServeHTTP(response, request)
+	return response
+}
+
+func decodeRepositoriesPage(response *httptest.ResponseRecorder) RepositoriesPage {
+	page := RepositoriesPage{}
+	Expect(json.NewDecoder(response.Body).Decode(&page)).To(Succeed())
+	return page
+}
+
+var _ = Describe("/repositories handler", func() {
+	var (
+		db     *gorm.DB
+		prefix string
+	)
+
+	BeforeEach(func() {
+		c := &Config{
+			DBType:     "sqlite3",
+			DBLocation: "test.sqlite",
+		}
+		db, _ = c.DB()
+		Expect(c.Automigrate()).To(Succeed())
+
+		prefix = fmt.Sprintf("discovery-%x", time.Now().UnixNano())
+		for _, record := range []string{
+			`{"repository": "%s/a", "sha": "aaaa", "branch": "origin/master", "lineCoverage": 40, "timestamp": 1480636700}`,
+			`{"repository": "%s/a", "sha": "bbbb", "branch": "origin/master", "lineCoverage": 45, "timestamp": 1480636760}`,
+			`{"repository": "%s/a", "sha": "cccc", "branch": "feature", "lineCoverage": 50, "timestamp": 1480636730}`,
+			`{"repository": "%s/b", "sha": "dddd", "lineCoverage": 60, "timestamp": 1480636800}`,
+			`{"repository": "%s/c", "sha": "eeee", "lineCoverage": 70, "timestamp": 1480636800}`,
+		} {
+			response := getMetricsResponse("POST", strings.NewReader(fmt.Sprintf(record, prefix)), "", db)
+			Expect(response.Code).To(Equal(http.StatusOK))
+		}
+	})
+
+	It("Should list repositories matching a prefix", func() {
+		response := getRepositoriesResponse("/repositories?prefix="+prefix, db)
+		Expect(response.Code).To(Equal(http.StatusOK))
+
+		page := decodeRepositoriesPage(response)
+		Expect(page.Repositories).To(HaveLen(3))
+		Expect(page.Next).To(BeEmpty())
+	})
+
+	It("Should summarize a repository", func() {
+		page := decodeRepositoriesPage(getRepositoriesResponse("/repositories?prefix="+prefix+"/a", db))
+		Expect(page.Repositories).To(HaveLen(1))
+
+		repository := page.Repositories[0]
+		Expect(repository.UploadCount).To(Equal(int64(3)))
+		Expect(repository.LastUpload).To(Equal(int64(1480636760)))
+		Expect(repository.Latest).ToNot(BeNil())
+		Expect(repository.Latest.Sha).To(Equal("bbbb"))
+	})
+
+	It("Should paginate", func() {
+		page := decodeRepositoriesPage(getRepositoriesResponse("/repositories?limit=2&prefix="+prefix, db))
+		Expect(page.Repositories).To(HaveLen(2))
+		Expect(page.Next).To(Equal(prefix + "/b"))
+
+		page = decodeRepositoriesPage(getRepositoriesResponse("/repositories?limit=2&prefix="+prefix+"&after="+page.Next, db))
+		Expect(page.Repositories).To(HaveLen(1))
+		Expect(page.Repositories[0].Name).To(Equal(prefix + "/c"))
+		Expect(page.Next).To(BeEmpty())
+	})
+
+	Context("With a private repository at the page boundary", func() {
+		var listing http.Handler
+
+		BeforeEach(func() {
+			db.Model(Repository{}).Where("name = ?", prefix+"/b").UpdateColumn("visibility", VisibilityPrivate)
+			listing = NewAuth(db, &Config{}).Wrap(NewRepositoriesHandler(db))
+		})
+
+		list := func(url string) string {
+			request, _ := http.NewRequest("GET", url, nil)
+			response := httptest.NewRecorder()
+			listing.ServeHTTP(response, request)
+			Expect(response.Code).To(Equal(http.StatusOK))
+			return response.Body.String()
+		}
+
+		It("Should fill pages with readable repositories", func() {
+			body := list("/repositories?limit=2&prefix=" + prefix)
+			Expect(body).ToNot(ContainSubstring(prefix + "/b"))
+
+			page := RepositoriesPage{}
+			Expect(json.Unmarshal([]byte(body), &page)).To(Succeed())
+			Expect(page.Repositories).To(HaveLen(2))
+			Expect(page.Repositories[1].Name).To(Equal(prefix + "/c"))
+			Expect(page.Next).To(BeEmpty())
+		})
+
+		It("Should not name it as the next page", func() {
+			body := list("/repositories?limit=1&prefix=" + prefix + "&after=" + prefix + "/a")
+			Expect(body).ToNot(ContainSubstring(prefix + "/b"))
+			Expect(body).To(ContainSubstring(prefix + "/c"))
+		})
+	})
+
+	It("Should reject invalid limits", func() {
+		response := getRepositoriesResponse("/repositories?limit=0", db)
+		Expect(response.Code).To(Equal(http.StatusBadRequest))
+	})
+
+	It("Should list a repository's branches", func() {
+		response := getRepositoriesResponse("/repositories/"+prefix+"/a/branches", db)
+		Expect(response.Code).To(Equal(http.StatusOK))
+
+		page := decodeRepositoriesPage(response)
+		Expect(page.Branches).To(HaveLen(2))
+		Expect(page.Branches[0].Name).To(Equal("feature"))
+		Expect(page.Branches[1].Name).To(Equal("origin/master"))
+		Expect(page.Branches[1].UploadCount).To(Equal(int64(2)))
+		Expect(page.Branches[1].Latest.Sha).To(Equal("bbbb"))
+	})
+
+	It("Should search branches by prefix", func() {
+		page := decodeRepositoriesPage(getRepositoriesResponse("/repositories/"+prefix+"/a/branches?prefix=origin/", db))
+		Expect(page.Branches).To(HaveLen(1))
+	})
+
+	It("Should 404 for unknown resources", func() {
+		response := getRepositoriesResponse("/repositories/"+prefix+"/a", db)
+		Expect(response.Code).To(Equal(http.StatusNotFound))
+	})
+
+	Context("With metrics recorded before repositories were tracked", func() {
+		var config *Config
+
+		BeforeEach(func() {
+			config = &Config{
+				DBType:     "sqlite3",
+				DBLocation: fmt.Sprintf("file:%s?mode=memory&cache=shared", prefix),
+			}
+			old, err := config.DB()
+			Expect(err).ToNot(HaveOccurred())
+			old.AutoMigrate(new(Metric))
+			for _, m := range []Metric{
+				{Repository: "old", Sha: "aaaa", Branch: "origin/master", Timestamp: 1480636700},
+				{Repository: "old", Sha: "bbbb", Branch: "origin/master", Timestamp: 1480636760},
+				{Repository: "old", Sha: "cccc", Branch: "feature", Timestamp: 1480636730},
+				{Repository: "old", Sha: "cccc", Component: "api", Timestamp: 1480636790},
+			} {
+				Expect(old.Create(&m).Error).ToNot(HaveOccurred())
+			}
+			Expect(config.Automigrate()).To(Succeed())
+		})
+
+		AfterEach(func() {
+			config.Close()
+		})
+
+		It("Should backfill repositories and branches", func() {
+			db, _ := config.DB()
+			page := decodeRepositoriesPage(getRepositoriesResponse("/repositories", db))
+			Expect(page.Repositories).To(HaveLen(1))
+			Expect(page.Repositories[0].UploadCount).To(Equal(int64(3)))
+			Expect(page.Repositories[0].LastUpload).To(Equal(int64(1480636760)))
+			Expect(page.Repositories[0].Latest.Sha).To(Equal("bbbb"))
+
+			page = decodeRepositoriesPage(getRepositoriesResponse("/repositories/old/branches", db))
+			Expect(page.Branches).To(HaveLen(2))
+			Expect(page.Branches[0].Latest.Sha).To(Equal("cccc"))
+			Expect(page.Branches[1].UploadCount).To(Equal(int64(2)))
+		})
+
+		It("Should not record metrics whose upload can't be tracked", func() {
+			db, _ := config.DB()
+			db.DropTable(new(Branch))
+			response := getMetricsResponse("POST", strings.NewReader(`{"repository": "old", "sha": "dddd", "branch": "new"}`), "", db)
+			Expect(response.Code).To(Equal(http.StatusBadRequest))
+
+			count := 0
+			db.Model(Metric{}).Where("sha = ?", "dddd").Count(&count)
+			Expect(count).To(BeZero())
+		})
+	})
+})
@@ -0,0 +1,117 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package uberalls
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Default server timeouts, in seconds
+const (
+	DefaultReadHeaderTimeout = 10
+	DefaultReadTimeout       = 60
+	DefaultWriteTimeout      = 60
+	DefaultIdleTimeout       = 120
+	DefaultShutdownTimeout   = 30
+)
+
+// ServerConfig configures the HTTP server's timeouts, in seconds. Zero uses
+// the default and a negative value disables the timeout.
+type ServerConfig struct {
+	ReadHeaderTimeout int
+	ReadTimeout       int
+	WriteTimeout      int
+	IdleTimeout       int
+	// ShutdownTimeout is how long in-flight requests may take to finish
+	// once the server is asked to stop
+	ShutdownTimeout int
+}
+
+func timeout(seconds, defaultSeconds int) time.Duration {
+	switch {
+	case seconds < 0:
+		return 0
+	case seconds == 0:
+		seconds = defaultSeconds
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// ShutdownDuration returns how long to drain requests for on shutdown
+func (c ServerConfig) ShutdownDuration() time.Duration {
+	return timeout(c.ShutdownTimeout, DefaultShutdownTimeout)
+}
+
+// NewServer builds an http.Server for handler from configuration
+func NewServer(config *Config, handler http.Handler) (*http.Server, error) {
+	server := &http.Server{
+		Addr:              config.ConnectionString(),
+		Handler:           handler,
+		ReadHeaderTimeout: timeout(config.Server.ReadHeaderTimeout, DefaultReadHeaderTimeout),
+		ReadTimeout:       timeout(config.Server.ReadTimeout, DefaultReadTimeout),
+		WriteTimeout:      timeout(config.Server.WriteTimeout, DefaultWriteTimeout),
+		IdleTimeout:       timeout(config.Server.IdleTimeout, DefaultIdleTimeout),
+	}
+
+	if config.TLS.Enabled() {
+		tlsConfig, err := config.TLS.ServerConfig()
+		if err != nil {
+			return nil, err
+		}
+		server.TLSConfig = tlsConfig
+	}
+	return server, nil
+}
+
+// Serve serves requests on listener until a signal arrives, then stops
+// accepting connections and waits up to drain for in-flight requests to
+// finish
+func Serve(server *http.Server, listener net.Listener, signals <-chan os.Signal, drain time.Duration) error {
+	errs := make(chan error, 1)
+	go func() {
+		if server.TLSConfig != nil {
+			errs <- server.ServeTLS(listener, "", "")
+		} else {
+			errs <- server.Serve(listener)
+		}
+	}()
+
+	select {
+	case err := <-errs:
+		return err
+	case sig := <-signals:
+		logger.Info("Draining requests", "signal", sig, "timeout", drain)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), drain)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		return err
+	}
+	if err := <-errs; err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package uberalls_test
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/uber/uberalls"
+)
+
+var _ = Describe("Server", func() {
+	var config *Config
+
+	BeforeEach(func() {
+		config = &Config{
+			DBType:        "sqlite3",
+			DBLocation:    "test.sqlite",
+			ListenAddress: "127.0.0.1",
+			ListenPort:    0,
+		}
+	})
+
+	It("Should apply default timeouts", func() {
+		server, err := NewServer(config, http.NotFoundHandler())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(server.Addr).To(Equal("127.0.0.1:0"))
+		Expect(server.ReadHeaderTimeout).To(Equal(DefaultReadHeaderTimeout * time.Second))
+		Expect(server.IdleTimeout).To(Equal(DefaultIdleTimeout * time.Second))
+		Expect(server.TLSConfig).To(BeNil())
+		Expect(config.Server.ShutdownDuration()).To(Equal(DefaultShutdownTimeout * time.Second))
+	})
+
+	It("Should configure timeouts", func() {
+		config.Server = ServerConfig{ReadTimeout: 5, WriteTimeout: -1, ShutdownTimeout: 2}
+		server, err := NewServer(config, http.NotFoundHandler())
+		Expect(err).ToNot(HaveOccurred())
+		Expect(server.ReadTimeout).To(Equal(5 * time.Second))
+		Expect(server.WriteTimeout).To(BeZero())
+		Expect(config.Server.ShutdownDuration()).To(Equal(2 * time.Second))
+	})
+
+	It("Should fail on invalid TLS configuration", func() {
+		config.TLS = TLSConfig{CertFile: "missing.pem"}
+		_, err := NewServer(config, http.NotFoundHandler())
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Should close the database", func() {
+		_, err := config.DB()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(config.Close()).To(Succeed())
+		Expect(config.Close()).To(Succeed())
+	})
+
+	Context("Shutting down", func() {
+		var (
+			listener net.Listener
+			signals  chan os.Signal
+			started  chan bool
+			served   chan error
+		)
+
+		BeforeEach(func() {
+			started = make(chan bool, 1)
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				started <- true
+				time.Sleep(200 * time.Millisecond)
+				w.Write([]byte("done"))
+			})
+			server, err := NewServer(config, handler)
+			Expect(err).ToNot(HaveOccurred())
+			listener, err = net.Listen("tcp", server.Addr)
+			Expect(err).ToNot(HaveOccurred())
+
+			signals = make(chan os.Signal, 1)
+			served = make(chan error, 1)
+			go func() {
+				served <- Serve(server, listener, signals, time.Second)
+			}()
+		})
+
+		It("Should drain in-flight requests", func() {
+			responses := make(chan string, 1)
+			go func() {
+				response, err := http.Get("http://" + listener.Addr().String())
+				if err != nil {
+					responses <- err.Error()
+					return
+				}
+				body, _ := ioutil.ReadAll(response.Body)
+				response.Body.Close()
+				responses <- string(body)
+			}()
+
+			Eventually(started).Should(Receive())
+			signals <- syscall.SIGTERM
+
+			Eventually(responses).Should(Receive(Equal("done")))
+			Eventually(served).Should(Receive(BeNil()))
+
+			_, err := net.Dial("tcp", listener.Addr().String())
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
@@ -0,0 +1,403 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package uberalls
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+	"github.com/uber/uberalls/coverage"
+)
+
+// Upload session states
+const (
+	SessionOpen     = "open"
+	SessionComplete = "complete"
+	SessionExpired  = "expired"
+)
+
+// DefaultSessionTimeout is how long a session waits for its shards, in seconds
+const DefaultSessionTimeout = 3600
+
+// MaxSessionTimeout is the longest timeout a shard may ask for, in seconds
+const MaxSessionTimeout = 24 * 3600
+
+// UploadSession collects partial reports from the shards of a CI run until
+// they can be merged into a single Metric
+type UploadSession struct {
+	ID             int64  `gorm:"primary_key:yes" json:"id"`
+	Repository     string `sql:"not null" json:"repository"`
+	Sha            string `sql:"not null" json:"sha"`
+	Branch         string `json:"branch"`
+	Suite          string `json:"suite"`
+	ShardCount     int64  `sql:"not null" json:"shardCount"`
+	Status         string `sql:"not null" json:"status"`
+	Timestamp      int64  `sql:"not null" json:"timestamp"`
+	ExpiresAt      int64  `sql:"not null" json:"expiresAt"`
+	ShardsReceived int64  `sql:"not null" json:"shardsReceived"`
+	MetricID       int64  `json:"metricId,omitempty"`
+}
+
+// UploadShard is one partial report of an upload session. Its Report is sized
+// to be longtext on MySQL, where text holds only 64KB.
+type UploadShard struct {
+	ID        int64  `gorm:"primary_key:yes"`
+	SessionID int64  `sql:"not null"`
+	Shard     int64  `sql:"not null"`
+	Report    string `sql:"size:4294967295;not null"`
+	Timestamp int64  `sql:"not null"`
+}
+
+// PartialReport is the body a shard uploads: a regular metric plus the
+// shard's position in the session
+type PartialReport struct {
+	Metric
+	Shard      int64 `json:"shard"`
+	ShardCount int64 `json:"shardCount"`
+	// Timeout overrides DefaultSessionTimeout when the session is created, up
+	// to MaxSessionTimeout
+	Timeout int64 `json:"timeout"`
+	// Session is the ID of the session the shard belongs to, as returned for
+	// an earlier shard. Without it, a shard joins the open session of its
+	// commit and suite, or starts a new one.
+	Session int64 `json:"session,omitempty"`
+}
+
+// SessionsHandler handles uploads from sharded CI runs
+type SessionsHandler struct {
+	db      *gorm.DB
+	metrics MetricsHandler
+	// lock serializes shard uploads so a session is finalized exactly once
+	lock *sync.Mutex
+}
+
+// NewSessionsHandler creates a new SessionsHandler, recording finalized
+// sessions through metrics
+func NewSessionsHandler(db *gorm.DB, metrics MetricsHandler) SessionsHandler {
+	return SessionsHandler{
+		db:      db,
+		metrics: metrics,
+		lock:    new(sync.Mutex),
+	}
+}
+
+// ServeHTTP handles an HTTP request for upload sessions
+func (sh SessionsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if r.Method == "GET" {
+		sh.handleSessionQuery(w, r)
+	} else {
+		sh.handleShardSave(w, r)
+	}
+}
+
+func (sh SessionsHandler) handleSessionQuery(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, r, "error parsing params", err)
+		return
+	}
+
+	if len(r.Form["repository"]) < 1 || len(r.Form["sha"]) < 1 {
+		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, r, "missing 'repository' or 'sha'", errors.New("need repository and sha"))
+		return
+	}
+
+	query := UploadSession{
+		Repository: r.Form["repository"][0],
+		Sha:        r.Form["sha"][0],
+	}
+	if !authorize(w, r, query.Repository, PermissionRead) {
+		return
+	}
+	if len(r.Form["suite"]) > 0 {
+		query.Suite = r.Form["suite"][0]
+	}
+
+	session := new(UploadSession)
+	sh.db.Where(&query).Order("timestamp desc").First(session)
+	if session.ID == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		writeError(w, r, "no rows found", errors.New("-"))
+		return
+	}
+
+	respondWithSession(w, r, *session)
+}
+
+func (sh SessionsHandler) handleShardSave(w http.ResponseWriter, r *http.Request) {
+	report := new(PartialReport)
+	if !decodeBody(w, r, report) {
+		return
+	}
+
+	if !authorize(w, r, report.Repository, PermissionUpload) {
+		return
+	}
+
+	sh.lock.Lock()
+	defer sh.lock.Unlock()
+
+	session, err := sh.RecordShard(report)
+	if err != nil {
+		if _, ok := err.(invalidShard); ok {
+			w.WriteHeader(http.StatusBadRequest)
+		} else if err == errSessionClosed {
+			w.WriteHeader(http.StatusConflict)
+		} else {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		writeError(w, r, "error recording shard", err)
+		return
+	}
+
+	report.SourceFiles = nil
+	Audit(sh.db, r, AuditShardCreate, session.Repository, auditTarget("session", session.ID), nil, report)
+	respondWithSession(w, r, session)
+}
+
+var errSessionClosed = errors.New("session closed before all shards arrived")
+
+// invalidShard is an error in the shard itself, as opposed to one storing it
+type invalidShard string
+
+func (e invalidShard) Error() string {
+	return string(e)
+}
+
+// RecordShard stores a partial report in its session, creating the session
+// for the first shard and finalizing it once every shard has arrived
+func (sh SessionsHandler) RecordShard(report *PartialReport) (UploadSession, error) {
+	if report.Repository == "" || report.Sha == "" {
+		return UploadSession{}, invalidShard("missing required field")
+	}
+	if report.ShardCount < 1 || report.Shard < 0 || report.Shard >= report.ShardCount {
+		return UploadSession{}, invalidShard("'shard' must be between 0 and 'shardCount'")
+	}
+	if report.Timeout > MaxSessionTimeout {
+		return UploadSession{}, invalidShard(fmt.Sprintf("'timeout' must be at most %d", MaxSessionTimeout))
+	}
+
+	session, err := sh.openSession(report)
+	if err != nil {
+		return UploadSession{}, err
+	}
+
+	body, err := json.Marshal(report.Metric)
+	if err != nil {
+		return UploadSession{}, err
+	}
+
+	// a retried shard replaces its earlier upload
+	if err := sh.db.Where("session_id = ? AND shard = ?", session.ID, report.Shard).Delete(UploadShard{}).Error; err != nil {
+		return UploadSession{}, err
+	}
+	if err := sh.db.Create(&UploadShard{
+		SessionID: session.ID,
+		Shard:     report.Shard,
+		Report:    string(body),
+		Timestamp: time.Now().Unix(),
+	}).Error; err != nil {
+		return UploadSession{}, err
+	}
+
+	if err := sh.db.Model(UploadShard{}).Where("session_id = ?", session.ID).Count(&session.ShardsReceived).Error; err != nil {
+		return UploadSession{}, err
+	}
+	if err := sh.db.Save(&session).Error; err != nil {
+		return UploadSession{}, err
+	}
+	if session.ShardsReceived >= session.ShardCount {
+		if err := sh.finalize(&session, SessionComplete); err != nil {
+			return UploadSession{}, err
+		}
+	}
+	return session, nil
+}
+
+// openSession finds the session a shard belongs to. A shard naming its
+// session is rejected if that session has closed; otherwise, once the latest
+// session of its commit is complete or expired, a new shard starts a new run,
+// as when CI is rerun.
+func (sh SessionsHandler) openSession(report *PartialReport) (UploadSession, error) {
+	now := time.Now().Unix()
+	session := UploadSession{}
+	if report.Session != 0 {
+		sh.db.Where("id = ?", report.Session).First(&session)
+		if session.ID == 0 || session.Repository != report.Repository || session.Sha != report.Sha || session.Suite != report.Suite {
+			return UploadSession{}, invalidShard("unknown session")
+		}
+	} else {
+		sh.db.Where(
+			"repository = ? AND sha = ? AND suite = ?",
+			report.Repository, report.Sha, report.Suite,
+		).Order("timestamp desc").First(&session)
+	}
+
+	if session.Status == SessionOpen && session.ExpiresAt <= now {
+		// ExpireSessionsEvery hasn't got to it yet
+		if err := sh.finalize(&session, SessionExpired); err != nil {
+			return UploadSession{}, err
+		}
+	}
+
+	switch {
+	case report.Session != 0 && session.Status != SessionOpen:
+		return session, errSessionClosed
+	case session.ID == 0 || session.Status != SessionOpen:
+	case session.ShardCount != report.ShardCount:
+		return session, invalidShard("'shardCount' does not match the session")
+	default:
+		return session, nil
+	}
+
+	timeout := report.Timeout
+	if timeout <= 0 {
+		timeout = DefaultSessionTimeout
+	}
+	session = UploadSession{
+		Repository: report.Repository,
+		Sha:        report.Sha,
+		Branch:     report.Branch,
+		Suite:      report.Suite,
+		ShardCount: report.ShardCount,
+		Status:     SessionOpen,
+		Timestamp:  now,
+		ExpiresAt:  now + timeout,
+	}
+	if err := sh.db.Create(&session).Error; err != nil {
+		return UploadSession{}, err
+	}
+	logger.Info("Opened upload session", "session", session.ID, "repository", session.Repository, "sha", session.Sha)
+	return session, nil
+}
+
+// ExpireSessions finalizes open sessions whose timeout has passed with the
+// shards received so far. A session that cannot be finalized is logged and
+// left open for the next run, without holding up the others.
+func (sh SessionsHandler) ExpireSessions(now int64) error {
+	sh.lock.Lock()
+	defer sh.lock.Unlock()
+
+	var sessions []UploadSession
+	if err := sh.db.Where("status = ? AND expires_at <= ?", SessionOpen, now).Find(&sessions).Error; err != nil {
+		return err
+	}
+
+	for _, session := range sessions {
+		if err := sh.finalize(&session, SessionExpired); err != nil {
+			logger.Error("Unable to expire upload session", "session", session.ID, "error", err)
+		}
+	}
+	return nil
+}
+
+// ExpireSessionsEvery runs ExpireSessions periodically, until done is closed
+func (sh SessionsHandler) ExpireSessionsEvery(interval time.Duration, done <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			if err := sh.ExpireSessions(now.Unix()); err != nil {
+				logger.Error("Unable to expire upload sessions", "error", err)
+			}
+		}
+	}
+}
+
+// finalize merges a session's shards into one Metric and closes the session
+func (sh SessionsHandler) finalize(session *UploadSession, status string) error {
+	var shards []UploadShard
+	if err := sh.db.Where("session_id = ?", session.ID).Order("shard").Find(&shards).Error; err != nil {
+		return err
+	}
+
+	if len(shards) > 0 {
+		reports := make([]Metric, 0, len(shards))
+		for _, shard := range shards {
+			m := Metric{}
+			if err := json.Unmarshal([]byte(shard.Report), &m); err != nil {
+				return err
+			}
+			reports = append(reports, m)
+		}
+
+		m := MergeReports(reports)
+		m.Branch = session.Branch
+		m.Suite = session.Suite
+		if err := sh.metrics.RecordMetric(&m); err != nil {
+			return err
+		}
+		session.MetricID = m.ID
+		m.SourceFiles = nil
+		Audit(sh.db, nil, AuditMetricCreate, m.Repository, auditTarget("metric", m.ID), nil, m)
+	}
+
+	session.Status = status
+	if err := sh.db.Save(session).Error; err != nil {
+		return err
+	}
+	if err := sh.db.Where("session_id = ?", session.ID).Delete(UploadShard{}).Error; err != nil {
+		return err
+	}
+	logger.Info("Closed upload session", "session", session.ID, "status", status, "shards", len(shards), "shard_count", session.ShardCount)
+	return nil
+}
+
+// MergeReports combines the partial reports of a sharded run. Line-level data
+// is merged as the union of covered lines; without it, every field keeps the
+// best value reported by any shard, as coverage.MergeMetrics does for suites,
+// rather than adding up shards that may have run the same lines.
+func MergeReports(reports []Metric) Metric {
+	merged := coverage.MergeMetrics(reports)
+	merged.ID = 0
+	merged.Timestamp = 0
+	merged.Suites = nil
+
+	sourceFiles := make([][]SourceFile, 0, len(reports))
+	for _, r := range reports {
+		sourceFiles = append(sourceFiles, r.SourceFiles)
+	}
+	merged.SourceFiles = coverage.MergeSourceFiles(sourceFiles...)
+	merged.ApplySourceFiles()
+	return merged
+}
+
+func respondWithSession(w http.ResponseWriter, r *http.Request, s UploadSession) {
+	bodyString, err := json.Marshal(s)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, r, "unable to encode response", err)
+		return
+	}
+
+	w.Write(bodyString)
+}
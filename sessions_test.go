@@ -0,0 +1,188 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package uberalls_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/uber/uberalls"
+)
+
+func getSessionsResponse(method string, body string, params string, db *gorm.DB) *httptest.ResponseRecorder {
+	url := "/sessions"
+	if params != "" {
+		url = fmt.Sprintf("%s?%s", url, params)
+	}
+	request, _ := http.NewRequest(method, url, strings.NewReader(body))
+	response := httptest.NewRecorder()
+	NewSessionsHandler(db, NewMetricsHandler(db, nil)).ServeHTTP(response, request)
+	return response
+}
+
+func decodeSession(response *httptest.ResponseRecorder) UploadSession {
+	session := UploadSession{}
+	Expect(json.NewDecoder(response.Body).Decode(&session)).To(Succeed())
+	return session
+}
+
+var _ = Describe("/sessions handler", func() {
+	var (
+		c        *Config
+		db       *gorm.DB
+		response *httptest.ResponseRecorder
+		sha      string
+	)
+
+	shard := func(index int, coverage string) string {
+		return fmt.Sprintf(`{
+			"repository": "sharded", "sha": %q, "branch": "origin/master",
+			"suite": "unit", "shard": %d, "shardCount": 2, "methodCoverage": %d,
+			"sourceFiles": [{"name": "main.go", "coverage": %s}]}`,
+			sha, index, 10*(index+1), coverage)
+	}
+
+	BeforeEach(func() {
+		c = &Config{
+			DBType:     "sqlite3",
+			DBLocation: "test.sqlite",
+		}
+		db, _ = c.DB()
+		Expect(c.Automigrate()).To(Succeed())
+		sha = fmt.Sprintf("%x", time.Now().UnixNano())
+	})
+
+	It("Should reject shards outside the session", func() {
+		response = getSessionsResponse("POST", `{"repository": "sharded", "sha": "abc", "shard": 2, "shardCount": 2}`, "", db)
+		Expect(response.Code).To(Equal(http.StatusBadRequest))
+	})
+
+	It("Should reject a timeout above the maximum", func() {
+		body := fmt.Sprintf(`{"repository": "sharded", "sha": "abc", "shard": 0, "shardCount": 2, "timeout": %d}`, MaxSessionTimeout+1)
+		response = getSessionsResponse("POST", body, "", db)
+		Expect(response.Code).To(Equal(http.StatusBadRequest))
+	})
+
+	It("Should not add up shards without line-level data", func() {
+		merged := MergeReports([]Metric{
+			{LinesCovered: 40, LinesTested: 50, LineCoverage: 80},
+			{LinesCovered: 30, LinesTested: 50, LineCoverage: 60, MethodCoverage: 70},
+		})
+		Expect(merged.LinesCovered).To(Equal(int64(40)))
+		Expect(merged.LinesTested).To(Equal(int64(50)))
+		Expect(merged.LineCoverage).To(Equal(80.))
+		Expect(merged.MethodCoverage).To(Equal(70.))
+	})
+
+	Context("With one shard uploaded", func() {
+		BeforeEach(func() {
+			response = getSessionsResponse("POST", shard(0, "[null, 1, 0, 0]"), "", db)
+			Expect(response.Code).To(Equal(http.StatusOK))
+		})
+
+		It("Should keep the session open", func() {
+			session := decodeSession(response)
+			Expect(session.Status).To(Equal(SessionOpen))
+			Expect(session.ShardsReceived).To(Equal(int64(1)))
+			Expect(session.MetricID).To(BeZero())
+		})
+
+		It("Should replace a retried shard", func() {
+			response = getSessionsResponse("POST", shard(0, "[null, 1, 1, 0]"), "", db)
+			Expect(decodeSession(response).ShardsReceived).To(Equal(int64(1)))
+		})
+
+		It("Should reject a different shard count", func() {
+			body := strings.Replace(shard(1, "[]"), `"shardCount": 2`, `"shardCount": 3`, 1)
+			response = getSessionsResponse("POST", body, "", db)
+			Expect(response.Code).To(Equal(http.StatusBadRequest))
+		})
+
+		It("Should be queryable", func() {
+			response = getSessionsResponse("GET", "", "repository=sharded&sha="+sha, db)
+			Expect(response.Code).To(Equal(http.StatusOK))
+			Expect(decodeSession(response).ShardCount).To(Equal(int64(2)))
+		})
+
+		Context("When the last shard arrives", func() {
+			BeforeEach(func() {
+				response = getSessionsResponse("POST", shard(1, "[null, 0, 0, 4]"), "", db)
+				Expect(response.Code).To(Equal(http.StatusOK))
+			})
+
+			It("Should finalize the session", func() {
+				session := decodeSession(response)
+				Expect(session.Status).To(Equal(SessionComplete))
+				Expect(session.MetricID).ToNot(BeZero())
+			})
+
+			It("Should record the merged metric", func() {
+				response = getMetricsResponse("GET", nil, "repository=sharded&sha="+sha, db)
+				metric := new(Metric)
+				Expect(json.NewDecoder(response.Body).Decode(metric)).To(Succeed())
+				Expect(metric.Suite).To(Equal("unit"))
+				Expect(metric.LinesCovered).To(Equal(int64(2)))
+				Expect(metric.LinesTested).To(Equal(int64(3)))
+				Expect(metric.MethodCoverage).To(Equal(20.))
+			})
+		})
+
+		Context("When the session times out", func() {
+			BeforeEach(func() {
+				handler := NewSessionsHandler(db, NewMetricsHandler(db, nil))
+				Expect(handler.ExpireSessions(time.Now().Unix() + DefaultSessionTimeout)).To(Succeed())
+			})
+
+			It("Should record the shards received", func() {
+				response = getSessionsResponse("GET", "", "repository=sharded&sha="+sha, db)
+				session := decodeSession(response)
+				Expect(session.Status).To(Equal(SessionExpired))
+				Expect(session.MetricID).ToNot(BeZero())
+			})
+
+			It("Should reject late shards of the session", func() {
+				id := decodeSession(response).ID
+				body := strings.Replace(shard(1, "[]"), `"shard": 1`, fmt.Sprintf(`"shard": 1, "session": %d`, id), 1)
+				response = getSessionsResponse("POST", body, "", db)
+				Expect(response.Code).To(Equal(http.StatusConflict))
+			})
+
+			It("Should start a new session for a rerun", func() {
+				expired := decodeSession(response)
+				response = getSessionsResponse("POST", shard(0, "[]"), "", db)
+				Expect(response.Code).To(Equal(http.StatusOK))
+
+				session := decodeSession(response)
+				Expect(session.ID).ToNot(Equal(expired.ID))
+				Expect(session.Status).To(Equal(SessionOpen))
+				Expect(session.ShardsReceived).To(Equal(int64(1)))
+			})
+		})
+	})
+})
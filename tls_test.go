@@ -0,0 +1,191 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package uberalls_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/uber/uberalls"
+)
+
+// issueCertificate creates a certificate for name, self-signed when parent is
+// nil
+func issueCertificate(name string, parent *tls.Certificate) tls.Certificate {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     []string{name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	issuer, signer := template, interface{}(key)
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+	} else {
+		issuer, signer = parent.Leaf, parent.PrivateKey
+	}
+	der, _ := x509.CreateCertificate(rand.Reader, template, issuer, &key.PublicKey, signer)
+	leaf, _ := x509.ParseCertificate(der)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}
+}
+
+func writeCertificate(certificate tls.Certificate, certFile, keyFile string) {
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Certificate[0]})
+	keyDER, _ := x509.MarshalECPrivateKey(certificate.PrivateKey.(*ecdsa.PrivateKey))
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	ioutil.WriteFile(certFile, certPEM, 0600)
+	ioutil.WriteFile(keyFile, keyPEM, 0600)
+}
+
+var _ = Describe("TLS", func() {
+	var (
+		dir    string
+		ca     tls.Certificate
+		config TLSConfig
+	)
+
+	BeforeEach(func() {
+		dir, _ = ioutil.TempDir("", "tls")
+		ca = issueCertificate("uberalls-ca", nil)
+		config = TLSConfig{
+			CertFile: filepath.Join(dir, "server.pem"),
+			KeyFile:  filepath.Join(dir, "server.key"),
+		}
+		writeCertificate(issueCertificate("localhost", &ca), config.CertFile, config.KeyFile)
+	})
+
+	AfterEach(func() {
+		os.RemoveAll(dir)
+	})
+
+	It("Should only be enabled with a certificate", func() {
+		Expect(config.Enabled()).To(BeTrue())
+		Expect(TLSConfig{}.Enabled()).To(BeFalse())
+	})
+
+	It("Should default to TLS 1.2", func() {
+		tlsConfig, err := config.ServerConfig()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tlsConfig.MinVersion).To(BeEquivalentTo(tls.VersionTLS12))
+		Expect(tlsConfig.ClientAuth).To(Equal(tls.NoClientCert))
+	})
+
+	It("Should configure the minimum version", func() {
+		config.MinVersion = "1.3"
+		tlsConfig, err := config.ServerConfig()
+		Expect(err).ToNot(HaveOccurred())
+		Expect(tlsConfig.MinVersion).To(BeEquivalentTo(tls.VersionTLS13))
+
+		config.MinVersion = "2.0"
+		_, err = config.ServerConfig()
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Should error on missing files", func() {
+		config.KeyFile = filepath.Join(dir, "missing.key")
+		_, err := config.ServerConfig()
+		Expect(err).To(HaveOccurred())
+	})
+
+	It("Should reload certificates when they change", func() {
+		reloader, err := NewCertificateReloader(config.CertFile, config.KeyFile)
+		Expect(err).ToNot(HaveOccurred())
+		first, _ := reloader.GetCertificate(nil)
+
+		writeCertificate(issueCertificate("rotated", &ca), config.CertFile, config.KeyFile)
+		later := time.Now().Add(time.Minute)
+		os.Chtimes(config.CertFile, later, later)
+		os.Chtimes(config.KeyFile, later, later)
+
+		Eventually(func() []byte {
+			certificate, _ := reloader.GetCertificate(nil)
+			return certificate.Certificate[0]
+		}, 3*time.Second, 100*time.Millisecond).ShouldNot(Equal(first.Certificate[0]))
+	})
+
+	Context("With a client CA", func() {
+		var (
+			server *httptest.Server
+			roots  *x509.CertPool
+		)
+
+		BeforeEach(func() {
+			config.ClientCAFile = filepath.Join(dir, "ca.pem")
+			writeCertificate(ca, config.ClientCAFile, filepath.Join(dir, "ca.key"))
+			roots = x509.NewCertPool()
+			roots.AddCert(ca.Leaf)
+
+			tlsConfig, err := config.ServerConfig()
+			Expect(err).ToNot(HaveOccurred())
+			server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			server.TLS = tlsConfig
+			server.StartTLS()
+		})
+
+		AfterEach(func() {
+			server.Close()
+		})
+
+		get := func(certificates ...tls.Certificate) error {
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+				RootCAs:      roots,
+				ServerName:   "localhost",
+				Certificates: certificates,
+			}}}
+			response, err := client.Get(server.URL)
+			if err == nil {
+				response.Body.Close()
+			}
+			return err
+		}
+
+		It("Should accept clients with a trusted certificate", func() {
+			Expect(get(issueCertificate("ci", &ca))).To(Succeed())
+		})
+
+		It("Should reject clients without a certificate", func() {
+			Expect(get()).ToNot(Succeed())
+		})
+
+		It("Should reject clients with an untrusted certificate", func() {
+			Expect(get(issueCertificate("ci", nil))).ToNot(Succeed())
+		})
+	})
+})
@@ -0,0 +1,172 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package uberalls
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// TokenRequest is the body of a request to mint a token
+type TokenRequest struct {
+	Name         string   `json:"name"`
+	User         string   `json:"user"`
+	Repositories []string `json:"repositories"`
+	Permissions  []string `json:"permissions"`
+}
+
+// MintedToken is returned once, when a token is minted
+type MintedToken struct {
+	Token
+	Secret string `json:"secret"`
+}
+
+// TokensHandler serves the admin API for API tokens
+type TokensHandler struct {
+	db *gorm.DB
+}
+
+// NewTokensHandler creates a new TokensHandler
+func NewTokensHandler(db *gorm.DB) TokensHandler {
+	return TokensHandler{db: db}
+}
+
+// ServeHTTP handles an HTTP request to list, mint or revoke tokens
+func (th TokensHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if status, err := isAdmin(r); err != nil {
+		w.WriteHeader(status)
+		writeError(w, r, "unauthorized", err)
+		return
+	}
+
+	switch r.Method {
+	case "GET":
+		th.handleList(w, r)
+	case "POST":
+		th.handleMint(w, r)
+	case "DELETE":
+		th.handleRevoke(w, r)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		writeError(w, r, "unsupported method", errors.New(r.Method))
+	}
+}
+
+func (th TokensHandler) handleList(w http.ResponseWriter, r *http.Request) {
+	var tokens []Token
+	th.db.Order("id").Find(&tokens)
+	for i := range tokens {
+		tokens[i].expand()
+	}
+	respondWithJSON(w, r, tokens)
+}
+
+func (th TokensHandler) handleMint(w http.ResponseWriter, r *http.Request) {
+	request := TokenRequest{}
+	if !decodeBody(w, r, &request) {
+		return
+	}
+
+	minted, err := th.Mint(request)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, r, "error minting token", err)
+		return
+	}
+	Audit(th.db, r, AuditTokenCreate, "", auditTarget("token", minted.ID), nil, minted.Token)
+	respondWithJSON(w, r, minted)
+}
+
+// Mint creates a token, returning its secret alongside it. Tokens minted for
+// a user also hold that user's roles.
+func (th TokensHandler) Mint(request TokenRequest) (MintedToken, error) {
+	if request.Name == "" {
+		return MintedToken{}, errors.New("'name' is required")
+	}
+	if request.User == "" && (len(request.Repositories) == 0 || len(request.Permissions) == 0) {
+		return MintedToken{}, errors.New("'repositories' and 'permissions' are required without a 'user'")
+	}
+
+	token := Token{
+		Name:      request.Name,
+		UserName:  request.User,
+		Timestamp: time.Now().Unix(),
+	}
+	for _, glob := range request.Repositories {
+		if strings.Contains(glob, "\n") {
+			return MintedToken{}, errors.New("invalid repository glob")
+		}
+		if err := CheckGlob(glob); err != nil {
+			return MintedToken{}, err
+		}
+	}
+	token.Scopes = strings.Join(request.Repositories, "\n")
+	for _, permission := range request.Permissions {
+		switch permission {
+		case PermissionRead:
+			token.CanRead = true
+		case PermissionUpload:
+			token.CanUpload = true
+		default:
+			return MintedToken{}, errors.New("unknown permission '" + permission + "'")
+		}
+	}
+
+	secret, err := NewSecret()
+	if err != nil {
+		return MintedToken{}, err
+	}
+	token.Hash = HashSecret(secret)
+	if err := th.db.Create(&token).Error; err != nil {
+		return MintedToken{}, err
+	}
+	token.expand()
+	logger.Info("Minted token", "token", token.ID, "name", token.Name, "repositories", strings.Join(token.Repositories, ","))
+	return MintedToken{Token: token, Secret: secret}, nil
+}
+
+func (th TokensHandler) handleRevoke(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, r, "error parsing params", err)
+		return
+	}
+
+	token := Token{}
+	th.db.Where("id = ?", r.Form.Get("id")).First(&token)
+	if token.ID == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		writeError(w, r, "no rows found", errors.New("-"))
+		return
+	}
+
+	th.db.Delete(&token)
+	requestLogger(r).Info("Revoked token", "token", token.ID, "name", token.Name)
+	token.expand()
+	Audit(th.db, r, AuditTokenDelete, "", auditTarget("token", token.ID), token, nil)
+	respondWithJSON(w, r, token)
+}
@@ -0,0 +1,108 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package uberalls_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/jinzhu/gorm"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/uber/uberalls"
+)
+
+var _ = Describe("/tokens handler", func() {
+	var (
+		db      *gorm.DB
+		handler http.Handler
+	)
+
+	BeforeEach(func() {
+		config := &Config{
+			DBType:       "sqlite3",
+			DBLocation:   "test.sqlite",
+			AuthRequired: true,
+			AdminToken:   "admin-secret",
+		}
+		db, _ = config.DB()
+		Expect(config.Automigrate()).To(Succeed())
+		handler = NewAuth(db, config).Wrap(NewTokensHandler(db))
+	})
+
+	It("Should require the admin token", func() {
+		response := getAuthenticatedResponse(handler, "GET", "/tokens", "", "")
+		Expect(response.Code).To(Equal(http.StatusUnauthorized))
+	})
+
+	It("Should not let API tokens manage tokens", func() {
+		minted, err := NewTokensHandler(db).Mint(TokenRequest{
+			Name:         "ci",
+			Repositories: []string{"*"},
+			Permissions:  []string{PermissionUpload, PermissionRead},
+		})
+		Expect(err).ToNot(HaveOccurred())
+
+		response := getAuthenticatedResponse(handler, "GET", "/tokens", "", minted.Secret)
+		Expect(response.Code).To(Equal(http.StatusForbidden))
+	})
+
+	It("Should reject unknown permissions", func() {
+		body := `{"name": "ci", "repositories": ["*"], "permissions": ["delete"]}`
+		response := getAuthenticatedResponse(handler, "POST", "/tokens", body, "admin-secret")
+		Expect(response.Code).To(Equal(http.StatusBadRequest))
+	})
+
+	Context("Minting a token", func() {
+		var minted MintedToken
+
+		BeforeEach(func() {
+			body := `{"name": "ci", "repositories": ["uber/*"], "permissions": ["upload"]}`
+			response := getAuthenticatedResponse(handler, "POST", "/tokens", body, "admin-secret")
+			Expect(response.Code).To(Equal(http.StatusOK))
+			Expect(json.NewDecoder(response.Body).Decode(&minted)).To(Succeed())
+		})
+
+		It("Should return the secret", func() {
+			Expect(minted.Secret).ToNot(BeEmpty())
+			Expect(minted.Repositories).To(Equal([]string{"uber/*"}))
+			Expect(minted.Permissions).To(Equal([]string{PermissionUpload}))
+		})
+
+		It("Should list it without its secret", func() {
+			response := getAuthenticatedResponse(handler, "GET", "/tokens", "", "admin-secret")
+			Expect(response.Code).To(Equal(http.StatusOK))
+			Expect(response.Body.String()).ToNot(ContainSubstring(minted.Secret))
+			Expect(response.Body.String()).ToNot(ContainSubstring(HashSecret(minted.Secret)))
+		})
+
+		It("Should revoke it", func() {
+			url := fmt.Sprintf("/tokens?id=%d", minted.ID)
+			response := getAuthenticatedResponse(handler, "DELETE", url, "", "admin-secret")
+			Expect(response.Code).To(Equal(http.StatusOK))
+
+			response = getAuthenticatedResponse(handler, "GET", "/tokens", "", minted.Secret)
+			Expect(response.Code).To(Equal(http.StatusUnauthorized))
+		})
+	})
+})
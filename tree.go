@@ -0,0 +1,231 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package uberalls
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	"github.com/uber/uberalls/coverage"
+)
+
+// TreeNode is the aggregated coverage of a file or directory
+type TreeNode struct {
+	Name                string  `json:"name"`
+	Path                string  `json:"path"`
+	Type                string  `json:"type"`
+	LinesCovered        int64   `json:"linesCovered"`
+	LinesTested         int64   `json:"linesTested"`
+	LineCoverage        float64 `json:"lineCoverage"`
+	BranchesCovered     int64   `json:"branchesCovered"`
+	BranchesTested      int64   `json:"branchesTested"`
+	ConditionalCoverage float64 `json:"conditionalCoverage"`
+}
+
+// Tree node types
+const (
+	TreeDirectory = "directory"
+	TreeFile      = "file"
+)
+
+// TreeResponse describes a directory and its immediate children
+type TreeResponse struct {
+	Repository string     `json:"repository"`
+	Sha        string     `json:"sha"`
+	MetricID   int64      `json:"metricId"`
+	Directory  TreeNode   `json:"directory"`
+	Children   []TreeNode `json:"children"`
+}
+
+// TreeHandler serves coverage aggregated by directory
+type TreeHandler struct {
+	db *gorm.DB
+}
+
+// NewTreeHandler creates a new TreeHandler
+func NewTreeHandler(db *gorm.DB) TreeHandler {
+	return TreeHandler{db: db}
+}
+
+// ServeHTTP handles an HTTP request for a directory's coverage
+func (th TreeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := r.ParseForm(); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, r, "error parsing params", err)
+		return
+	}
+
+	if len(r.Form["repository"]) < 1 {
+		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, r, "missing 'repository'", errors.New("need repository"))
+		return
+	}
+
+	query := coverage.ExtractMetricQuery(r.Form)
+	if !authorize(w, r, query.Repository, PermissionRead) {
+		return
+	}
+
+	m := latestMetric(th.db, query, r.Form)
+	if m.ID == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		writeError(w, r, "no rows found", errors.New("-"))
+		return
+	}
+
+	dir := ""
+	if len(r.Form["path"]) > 0 {
+		dir = coverage.CleanPath(r.Form["path"][0])
+	}
+
+	files, err := th.files(*m, query.Suite == "", dir, r.Form)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		writeError(w, r, "unable to decode line-level data", err)
+		return
+	}
+
+	if len(files) == 0 {
+		w.WriteHeader(http.StatusNotFound)
+		writeError(w, r, "no file-level data found", errors.New(dir))
+		return
+	}
+
+	tree := BuildTree(dir, files)
+	tree.Repository = m.Repository
+	tree.Sha = m.Sha
+	tree.MetricID = m.ID
+
+	bodyString, err := json.Marshal(tree)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		writeError(w, r, "unable to encode response", err)
+		return
+	}
+	w.Write(bodyString)
+}
+
+// files lists the files of m below a directory, merged from the latest upload
+// of every suite of its commit when combining them
+func (th TreeHandler) files(m Metric, combine bool, dir string, form url.Values) ([]FileCoverage, error) {
+	var files []FileCoverage
+	if combine {
+		merged, ok, err := combinedSourceFiles(th.db, latestSuites(th.db, m, form))
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			for _, f := range merged {
+				fc := NewFileCoverage(m.ID, f)
+				if dir == "" || strings.HasPrefix(fc.Path, dir+"/") {
+					files = append(files, fc)
+				}
+			}
+			return files, nil
+		}
+	}
+
+	dbQuery := th.db.Where("metric_id = ?", m.ID)
+	if dir != "" {
+		dbQuery = dbQuery.Where("path LIKE ? ESCAPE '!'", escapeLike(dir)+"/%")
+	}
+	dbQuery.Find(&files)
+	return files, nil
+}
+
+// BuildTree aggregates the files below a directory into its immediate
+// children, directories first
+func BuildTree(dir string, files []FileCoverage) TreeResponse {
+	prefix := ""
+	if dir != "" {
+		prefix = dir + "/"
+	}
+
+	tree := TreeResponse{
+		Directory: TreeNode{Name: pathBase(dir), Path: dir, Type: TreeDirectory},
+	}
+	children := make(map[string]*TreeNode)
+	var names []string
+
+	for _, f := range files {
+		if !strings.HasPrefix(f.Path, prefix) {
+			continue
+		}
+		rest := strings.TrimPrefix(f.Path, prefix)
+		name := rest
+		nodeType := TreeFile
+		if i := strings.Index(rest, "/"); i >= 0 {
+			name = rest[:i]
+			nodeType = TreeDirectory
+		}
+
+		child, ok := children[name]
+		if !ok {
+			child = &TreeNode{Name: name, Path: prefix + name, Type: nodeType}
+			children[name] = child
+			names = append(names, name)
+		}
+		child.add(f)
+		tree.Directory.add(f)
+	}
+
+	sort.Strings(names)
+	tree.Children = make([]TreeNode, 0, len(names))
+	for _, nodeType := range []string{TreeDirectory, TreeFile} {
+		for _, name := range names {
+			if child := children[name]; child.Type == nodeType {
+				child.computePercentages()
+				tree.Children = append(tree.Children, *child)
+			}
+		}
+	}
+	tree.Directory.computePercentages()
+	return tree
+}
+
+func (n *TreeNode) add(f FileCoverage) {
+	n.LinesCovered += f.LinesCovered
+	n.LinesTested += f.LinesTested
+	n.BranchesCovered += f.BranchesCovered
+	n.BranchesTested += f.BranchesTested
+}
+
+func (n *TreeNode) computePercentages() {
+	n.LineCoverage = coverage.Percentage(n.LinesCovered, n.LinesTested)
+	n.ConditionalCoverage = coverage.Percentage(n.BranchesCovered, n.BranchesTested)
+}
+
+func pathBase(p string) string {
+	return p[strings.LastIndex(p, "/")+1:]
+}
+
+var likeEscaper = strings.NewReplacer(`!`, `!!`, `%`, `!%`, `_`, `!_`)
+
+func escapeLike(s string) string {
+	return likeEscaper.Replace(s)
+}
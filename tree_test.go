@@ -0,0 +1,153 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package uberalls_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/uber/uberalls"
+)
+
+func getTreeResponse(params string, db *gorm.DB) *httptest.ResponseRecorder {
+	request, _ := http.NewRequest("GET", "/metrics/tree?"+params, nil)
+	response := httptest.NewRecorder()
+	NewTreeHandler(db).ServeHTTP(response, request)
+	return response
+}
+
+var _ = Describe("Directory tree", func() {
+	files := []FileCoverage{
+		{Path: "main.go", LinesCovered: 1, LinesTested: 2},
+		{Path: "api/server.go", LinesCovered: 3, LinesTested: 4, BranchesCovered: 1, BranchesTested: 2},
+		{Path: "api/v1/handler.go", LinesCovered: 1, LinesTested: 4},
+		{Path: "api_docs/index.md", LinesCovered: 0, LinesTested: 1},
+	}
+
+	Context("At the root", func() {
+		tree := BuildTree("", files)
+
+		It("Should aggregate everything", func() {
+			Expect(tree.Directory.LinesCovered).To(Equal(int64(5)))
+			Expect(tree.Directory.LinesTested).To(Equal(int64(11)))
+		})
+
+		It("Should list directories before files", func() {
+			Expect(tree.Children).To(HaveLen(3))
+			Expect(tree.Children[0].Path).To(Equal("api"))
+			Expect(tree.Children[0].Type).To(Equal(TreeDirectory))
+			Expect(tree.Children[1].Path).To(Equal("api_docs"))
+			Expect(tree.Children[2].Path).To(Equal("main.go"))
+			Expect(tree.Children[2].Type).To(Equal(TreeFile))
+		})
+
+		It("Should compute percentages", func() {
+			Expect(tree.Children[0].LineCoverage).To(Equal(50.))
+			Expect(tree.Children[0].ConditionalCoverage).To(Equal(50.))
+		})
+	})
+
+	Context("In a subdirectory", func() {
+		tree := BuildTree("api", files)
+
+		It("Should only include the directory's files", func() {
+			Expect(tree.Directory.Name).To(Equal("api"))
+			Expect(tree.Directory.LinesTested).To(Equal(int64(8)))
+			Expect(tree.Children).To(HaveLen(2))
+			Expect(tree.Children[0].Path).To(Equal("api/v1"))
+			Expect(tree.Children[1].Path).To(Equal("api/server.go"))
+		})
+	})
+
+	Context("Served over HTTP", func() {
+		var db *gorm.DB
+
+		BeforeEach(func() {
+			c := &Config{
+				DBType:     "sqlite3",
+				DBLocation: "test.sqlite",
+			}
+			db, _ = c.DB()
+			Expect(c.Automigrate()).To(Succeed())
+
+			record := `{
+				"repository": "tree", "sha": "deadbeef",
+				"sourceFiles": [
+					{"name": "api/server.go", "coverage": [1, 0]},
+					{"name": "api/v1/handler.go", "coverage": [1, 1, null]},
+					{"name": "api_docs/index.md", "coverage": [0]},
+					{"name": "main.go", "coverage": [0]}
+				]}`
+			response := getMetricsResponse("POST", strings.NewReader(record), "", db)
+			Expect(response.Code).To(Equal(http.StatusOK))
+		})
+
+		It("Should list a directory's children", func() {
+			response := getTreeResponse("repository=tree&sha=deadbeef&path=api/", db)
+			Expect(response.Code).To(Equal(http.StatusOK))
+
+			tree := TreeResponse{}
+			Expect(json.NewDecoder(response.Body).Decode(&tree)).To(Succeed())
+			Expect(tree.Directory.LinesCovered).To(Equal(int64(3)))
+			Expect(tree.Directory.LinesTested).To(Equal(int64(4)))
+			Expect(tree.Children).To(HaveLen(2))
+		})
+
+		It("Should merge the files of every suite unless one is requested", func() {
+			for _, record := range []string{
+				`{"repository": "tree/suites", "sha": "deadbeef", "suite": "unit",
+					"sourceFiles": [{"name": "api/server.go", "coverage": [1, 0]}]}`,
+				`{"repository": "tree/suites", "sha": "deadbeef", "suite": "integration",
+					"sourceFiles": [{"name": "api/server.go", "coverage": [0, 1]}, {"name": "main.go", "coverage": [1]}]}`,
+			} {
+				response := getMetricsResponse("POST", strings.NewReader(record), "", db)
+				Expect(response.Code).To(Equal(http.StatusOK))
+			}
+
+			tree := TreeResponse{}
+			response := getTreeResponse("repository=tree/suites&sha=deadbeef", db)
+			Expect(json.NewDecoder(response.Body).Decode(&tree)).To(Succeed())
+			Expect(tree.Directory.LinesCovered).To(Equal(int64(3)))
+			Expect(tree.Directory.LinesTested).To(Equal(int64(3)))
+
+			response = getTreeResponse("repository=tree/suites&sha=deadbeef&suite=unit", db)
+			Expect(json.NewDecoder(response.Body).Decode(&tree)).To(Succeed())
+			Expect(tree.Directory.LinesCovered).To(Equal(int64(1)))
+			Expect(tree.Directory.LinesTested).To(Equal(int64(2)))
+		})
+
+		It("Should 404 for unknown directories", func() {
+			response := getTreeResponse("repository=tree&sha=deadbeef&path=web", db)
+			Expect(response.Code).To(Equal(http.StatusNotFound))
+		})
+
+		It("Should require a repository", func() {
+			response := getTreeResponse("sha=deadbeef", db)
+			Expect(response.Code).To(Equal(http.StatusBadRequest))
+		})
+	})
+})
@@ -18,39 +18,141 @@
 // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
 // THE SOFTWARE.
 
-package main
+// Package uberalls records code coverage metrics and serves them over HTTP.
+// The uberalls command in cmd/uberalls runs it.
+package uberalls
 
 import (
-	"log"
+	"io"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
-// MakeServeMux instantiates an http ServeMux for the server
+// MakeServeMux instantiates an http ServeMux for the server, exiting if the
+// database cannot be opened or migrated
 func MakeServeMux(config *Config) *http.ServeMux {
+	return MakeReloadingServeMux(NewReloader(config, nil, nil))
+}
+
+// MakeReloadingServeMux instantiates an http ServeMux for the server whose
+// handlers apply the settings the reloader reloads, exiting if the database
+// cannot be opened or migrated
+func MakeReloadingServeMux(reloader *Reloader) *http.ServeMux {
+	mux, err := NewReloadingServeMux(reloader)
+	if err != nil {
+		logger.Fatal("Could not establish database connection", "error", err)
+	}
+	return mux
+}
+
+// NewServeMux instantiates an http ServeMux for the server, returning an
+// error if the database cannot be opened or migrated
+func NewServeMux(config *Config) (*http.ServeMux, error) {
+	return NewReloadingServeMux(NewReloader(config, nil, nil))
+}
+
+// NewReloadingServeMux is MakeReloadingServeMux, returning an error instead of
+// exiting if the database cannot be opened or migrated
+func NewReloadingServeMux(reloader *Reloader) (*http.ServeMux, error) {
+	config := reloader.Config()
 	db, err := config.DB()
 	if err != nil {
-		log.Fatalf("Unable to initialize DB connection: %v", err)
+		return nil, err
 	}
 
 	if err := config.Automigrate(); err != nil {
-		log.Fatalf("Could not establish database connection: %v", err)
+		return nil, err
+	}
+	metrics := NewMetricsHandler(db, config.Components)
+	sessions := NewSessionsHandler(db, metrics)
+	go sessions.ExpireSessionsEvery(time.Minute, config.Closed())
+
+	policy := NewPolicyHandler(config.Policy)
+	auth := NewAuth(db, config)
+	limiter := NewLimiter(config.Limits)
+	wrap := func(handler http.Handler) http.Handler {
+		return limiter.Wrap(auth.Wrap(limiter.WrapPrincipal(apiSpec.Validate(handler))))
 	}
+	reloader.OnReload(func(c *Config) error {
+		auth.Update(c)
+		limiter.Update(c.Limits)
+		metrics.SetComponents(c.Components)
+		policy.SetPolicy(c.Policy)
+		return nil
+	})
+
 	mux := http.NewServeMux()
 	mux.Handle("/health", NewHealthHandler(db))
-	mux.Handle("/metrics", NewMetricsHandler(db))
+	mux.Handle("/openapi.json", wrap(apiSpec))
+	mux.Handle("/metrics", wrap(metrics))
+	mux.Handle("/metrics/history", wrap(NewHistoryHandler(metrics)))
+	mux.Handle("/metrics/tree", wrap(NewTreeHandler(db)))
+	mux.Handle("/metrics/file", wrap(NewFileHandler(db)))
+	mux.Handle("/sessions", wrap(sessions))
+	mux.Handle("/policy", wrap(policy))
+	mux.Handle("/tokens", wrap(NewTokensHandler(db)))
+	mux.Handle("/admin/", wrap(NewAccessHandler(db)))
+	mux.Handle("/admin/config", wrap(reloader))
+	mux.Handle("/audit", wrap(NewAuditHandler(db)))
 
-	return mux
+	repositories := wrap(NewRepositoriesHandler(db))
+	mux.Handle("/repositories", repositories)
+	mux.Handle("/repositories/", repositories)
+
+	dashboard := wrap(NewDashboardHandler(db, metrics))
+	mux.Handle("/dashboard/", dashboard)
+	mux.Handle("/", dashboard)
+
+	return mux, nil
 }
 
-func main() {
-	config, err := Configure()
+// runServe loads the configuration and serves until asked to stop
+func runServe(args []string, stdout, stderr io.Writer) error {
+	config, provenance, err := loadCommandConfig(newCommandFlags("serve", stderr), args)
 	if err != nil {
-		log.Fatalf("Unable to load configuration: %s", err)
+		return err
+	}
+	if err := ConfigureLogging(config.Log); err != nil {
+		return err
 	}
 
-	mux := MakeServeMux(config)
-	listenString := config.ConnectionString()
-	log.Printf("Listening on %s... ", listenString)
+	// The database is closed however serving ends, including when draining
+	// requests times out
+	defer config.Close()
+	reloader := NewReloader(config, provenance, args)
+	mux, err := NewReloadingServeMux(reloader)
+	if err != nil {
+		return err
+	}
+	server, err := NewServer(config, LogRequests(mux))
+	if err != nil {
+		return err
+	}
 
-	log.Fatal(http.ListenAndServe(listenString, mux))
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		return err
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	go reloader.WatchSignals(hangups)
+	go reloader.WatchFilesEvery(configCheckInterval)
+
+	logger.Info("Listening", "address", server.Addr, "tls", server.TLSConfig != nil)
+	if err := Serve(server, listener, signals, config.Server.ShutdownDuration()); err != nil {
+		return err
+	}
+
+	if err := config.Close(); err != nil {
+		return err
+	}
+	logger.Info("Shut down cleanly")
+	return nil
 }
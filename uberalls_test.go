@@ -18,7 +18,7 @@
 // OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
 // THE SOFTWARE.
 
-package main_test
+package uberalls_test
 
 import (
 	"net/http"
@@ -41,6 +41,11 @@ var _ = Describe("Uberalls main", func() {
 		Expect(mux).ToNot(BeNil())
 	})
 
+	It("should return database errors rather than exit", func() {
+		_, err := NewServeMux(&Config{DBType: "nosuchdb"})
+		Expect(err).To(HaveOccurred())
+	})
+
 	It("should configure", func() {
 		_, err := Configure()
 		Expect(err).ToNot(HaveOccurred())
@@ -0,0 +1,131 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package uberallstest
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/uber/uberalls/coverage"
+)
+
+// handler serves requests with the uberalls handlers, after counting them,
+// applying faults and checking tokens
+func (s *Server) handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.lock.Lock()
+		s.requests++
+		fault := s.fault(r)
+		token := s.token
+		s.lock.Unlock()
+
+		if fault != nil && fault.Latency > 0 {
+			select {
+			case <-r.Context().Done():
+				return
+			case <-time.After(fault.Latency):
+			}
+		}
+		if fault != nil && fault.Status != 0 {
+			writeError(w, fault.Status, "injected fault", errors.New(http.StatusText(fault.Status)))
+			return
+		}
+
+		authorization := r.Header.Get("Authorization")
+		switch {
+		case token == "":
+		case authorization == "":
+			writeError(w, http.StatusUnauthorized, "unauthorized", errors.New("authentication required"))
+			return
+		case authorization != "Bearer "+token:
+			writeError(w, http.StatusUnauthorized, "invalid credentials", errors.New("unknown token"))
+			return
+		}
+		// The uberalls handlers accept every request once the token is checked
+		r.Header.Del("Authorization")
+
+		if r.URL.Path == "/metrics" && r.Method == "POST" {
+			s.recordUpload(w, r, next)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// recordUpload serves an upload, keeping it if it was recorded
+func (s *Server) recordUpload(w http.ResponseWriter, r *http.Request, next http.Handler) {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "unable to read body", err)
+		return
+	}
+	r.Body = ioutil.NopCloser(bytes.NewReader(body))
+
+	recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	next.ServeHTTP(recorder, r)
+
+	var m coverage.Metric
+	if recorder.status < 300 && json.Unmarshal(body, &m) == nil {
+		s.lock.Lock()
+		s.uploads = append(s.uploads, m)
+		s.lock.Unlock()
+	}
+}
+
+// fault finds the fault affecting a request, counting it against the fault's
+// Times, which the caller holds the lock for
+func (s *Server) fault(r *http.Request) *Fault {
+	for i, f := range s.faults {
+		if (f.Path != "" && f.Path != r.URL.Path) || (f.Method != "" && f.Method != r.Method) {
+			continue
+		}
+		matched := *f
+		if f.Times > 0 {
+			f.Times--
+			if f.Times == 0 {
+				s.faults = append(s.faults[:i], s.faults[i+1:]...)
+			}
+		}
+		return &matched
+	}
+	return nil
+}
+
+// statusRecorder remembers the status code written to a response
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sr *statusRecorder) WriteHeader(status int) {
+	sr.status = status
+	sr.ResponseWriter.WriteHeader(status)
+}
+
+func writeError(w http.ResponseWriter, status int, message string, err error) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(coverage.ErrorResponse{Error: message + ": " + err.Error()})
+}
@@ -0,0 +1,180 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+// Package uberallstest provides an in-process uberalls server for testing
+// programs that call the uberalls API.
+//
+// The server runs the real uberalls handlers on an in-memory SQLite database,
+// so it serves every endpoint the real server does: /metrics, /metrics/tree,
+// /metrics/file, /sessions, /repositories, /policy, the dashboard and the
+// administration endpoints. On top of them, it lets tests seed metrics,
+// inspect uploads, check bearer tokens and inject faults.
+package uberallstest
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/uber/uberalls"
+	"github.com/uber/uberalls/client"
+	"github.com/uber/uberalls/coverage"
+)
+
+// databases numbers the in-memory databases, which are shared by name within
+// a process
+var databases int64
+
+// Server is an uberalls server for tests
+type Server struct {
+	// URL is the server's base URL, like "http://127.0.0.1:41234"
+	URL string
+
+	server   *httptest.Server
+	config   *uberalls.Config
+	metrics  uberalls.MetricsHandler
+	lock     sync.Mutex
+	uploads  []coverage.Metric
+	token    string
+	faults   []*Fault
+	requests int
+}
+
+// Fault makes the server misbehave on the requests it matches
+type Fault struct {
+	// Path restricts the fault to requests for a path, like "/metrics";
+	// empty matches every path
+	Path string
+	// Method restricts the fault to requests with a method; empty matches
+	// every method
+	Method string
+	// Latency delays the response, or the failure
+	Latency time.Duration
+	// Status, when set, is answered instead of handling the request
+	Status int
+	// Times is how many requests the fault affects before it is cleared;
+	// 0 affects every request
+	Times int
+}
+
+// NewServer starts a server with an empty database, which must be closed
+// with Close. It panics if the database can't be created or migrated, rather
+// than exiting the test binary.
+func NewServer() *Server {
+	config := &uberalls.Config{
+		DBType:     "sqlite3",
+		DBLocation: fmt.Sprintf("file:uberallstest-%d?mode=memory&cache=shared", atomic.AddInt64(&databases, 1)),
+	}
+	db, err := config.DB()
+	if err != nil {
+		panic(err)
+	}
+	// Connections would each see the database locked by the others
+	db.DB().SetMaxOpenConns(1)
+	mux, err := uberalls.NewServeMux(config)
+	if err != nil {
+		panic(err)
+	}
+
+	s := &Server{config: config, metrics: uberalls.NewMetricsHandler(db, nil)}
+	s.server = httptest.NewServer(s.handler(mux))
+	s.URL = s.server.URL
+	return s
+}
+
+// Close shuts the server down and discards its database
+func (s *Server) Close() {
+	s.server.Close()
+	s.config.Close()
+}
+
+// Client creates a client of the server, retrying without waiting
+func (s *Server) Client() *client.Client {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	c := client.New(s.URL, s.token)
+	c.Backoff = time.Millisecond
+	return c
+}
+
+// RequireToken makes the server answer 401 to requests without a bearer
+// token, or with another one. An empty token accepts every request again.
+func (s *Server) RequireToken(token string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.token = token
+}
+
+// Seed records metrics as if they had been uploaded, without counting them as
+// uploads, and returns them with their IDs. Metrics without a timestamp get
+// the current time, and line-level data is recorded as it is for uploads. It
+// panics if a metric has no repository or commit.
+func (s *Server) Seed(metrics ...coverage.Metric) []coverage.Metric {
+	seeded := make([]coverage.Metric, 0, len(metrics))
+	for _, m := range metrics {
+		if err := s.metrics.RecordMetric(&m); err != nil {
+			panic(err)
+		}
+		m.SourceFiles = nil
+		seeded = append(seeded, m)
+	}
+	return seeded
+}
+
+// Metrics returns every metric recorded, seeded or uploaded, in the order
+// they were recorded
+func (s *Server) Metrics() []coverage.Metric {
+	var metrics []coverage.Metric
+	db, _ := s.config.DB()
+	db.Order("id").Find(&metrics)
+	return metrics
+}
+
+// Uploads returns the metrics POSTed to /metrics and recorded, as they were
+// sent, in the order they were received
+func (s *Server) Uploads() []coverage.Metric {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]coverage.Metric(nil), s.uploads...)
+}
+
+// Requests returns how many requests the server has received, including
+// those that failed
+func (s *Server) Requests() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.requests
+}
+
+// Inject adds a fault; when several match a request, the first added applies
+func (s *Server) Inject(fault Fault) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.faults = append(s.faults, &fault)
+}
+
+// ClearFaults removes every fault
+func (s *Server) ClearFaults() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.faults = nil
+}
@@ -0,0 +1,200 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+package uberallstest_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/uber/uberalls/client"
+	"github.com/uber/uberalls/coverage"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/uber/uberalls/uberallstest"
+)
+
+var _ = Describe("Fake server", func() {
+	var (
+		server *Server
+		c      *client.Client
+		ctx    = context.Background()
+	)
+
+	BeforeEach(func() {
+		server = NewServer()
+		c = server.Client()
+		server.Seed(
+			coverage.Metric{Repository: "fake/repo", Sha: "first", Branch: "master", LineCoverage: 50, Timestamp: 1000},
+			coverage.Metric{Repository: "fake/repo", Sha: "second", Branch: "master", LineCoverage: 60, Timestamp: 2000},
+		)
+	})
+
+	AfterEach(func() {
+		server.Close()
+	})
+
+	It("Should serve seeded metrics", func() {
+		latest, err := c.Latest(ctx, client.Query{Repository: "fake/repo", Branch: "master"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(latest.Sha).To(Equal("second"))
+
+		latest, err = c.Latest(ctx, client.Query{Repository: "fake/repo", Branch: "master", Until: 1500})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(latest.Sha).To(Equal("first"))
+
+		latest, err = c.Latest(ctx, client.Query{Repository: "fake/repo", Sha: "unknown"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(latest).To(BeNil())
+	})
+
+	It("Should record uploads", func() {
+		one := int64(1)
+		recorded, err := c.Record(ctx, coverage.Metric{
+			Repository:  "fake/repo",
+			Sha:         "third",
+			Branch:      "master",
+			SourceFiles: []coverage.SourceFile{{Name: "main.go", Coverage: []*int64{&one, nil}}},
+		})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(recorded.ID).To(BeEquivalentTo(3))
+		Expect(recorded.LineCoverage).To(Equal(100.))
+		Expect(recorded.SourceFiles).To(BeEmpty())
+
+		Expect(server.Uploads()).To(HaveLen(1))
+		Expect(server.Uploads()[0].SourceFiles).To(HaveLen(1))
+		Expect(server.Metrics()).To(HaveLen(3))
+
+		_, err = c.Record(ctx, coverage.Metric{Repository: "fake/repo"})
+		Expect(err).To(MatchError(ContainSubstring("missing property 'sha'")))
+		Expect(server.Uploads()).To(HaveLen(1))
+	})
+
+	It("Should combine the latest upload of every suite", func() {
+		server.Seed(
+			coverage.Metric{Repository: "fake/repo", Sha: "suites", Suite: "unit", LineCoverage: 40},
+			coverage.Metric{Repository: "fake/repo", Sha: "suites", Suite: "integration", LineCoverage: 70},
+		)
+		latest, err := c.Latest(ctx, client.Query{Repository: "fake/repo", Sha: "suites"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(latest.LineCoverage).To(Equal(70.))
+		Expect(latest.Suites).To(ConsistOf("unit", "integration"))
+	})
+
+	It("Should compare commits and list history", func() {
+		comparison, err := c.Compare(ctx, client.Query{Repository: "fake/repo"}, "first", "second")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(comparison.Changes["lineCoverage"]).To(Equal(10.))
+
+		history, err := c.History(ctx, client.Query{Repository: "fake/repo", Branch: "master"}, 0)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(history).To(HaveLen(2))
+		Expect(history[0].Sha).To(Equal("second"))
+	})
+
+	It("Should serve line-level data and sharded uploads", func() {
+		one := int64(1)
+		server.Seed(coverage.Metric{
+			Repository:  "fake/repo",
+			Sha:         "lines",
+			SourceFiles: []coverage.SourceFile{{Name: "pkg/main.go", Coverage: []*int64{&one, nil}}},
+		})
+		for _, path := range []string{
+			"/metrics/tree?repository=fake/repo&sha=lines",
+			"/metrics/file?repository=fake/repo&sha=lines&path=pkg/main.go",
+		} {
+			response, err := http.Get(server.URL + path)
+			Expect(err).ToNot(HaveOccurred())
+			Expect(response.StatusCode).To(Equal(http.StatusOK))
+		}
+
+		shard := `{"repository": "fake/repo", "sha": "sharded", "shard": 0, "shardCount": 1, "lineCoverage": 75}`
+		response, err := http.Post(server.URL+"/sessions", "application/json", strings.NewReader(shard))
+		Expect(err).ToNot(HaveOccurred())
+		Expect(response.StatusCode).To(Equal(http.StatusOK))
+		latest, err := c.Latest(ctx, client.Query{Repository: "fake/repo", Sha: "sharded"})
+		Expect(err).ToNot(HaveOccurred())
+		Expect(latest.LineCoverage).To(Equal(75.))
+	})
+
+	It("Should list repositories and branches", func() {
+		var page struct {
+			Repositories []struct {
+				Name        string
+				UploadCount int64
+				Latest      coverage.Metric
+			}
+			Branches []struct{ Name string }
+		}
+		response, err := http.Get(server.URL + "/repositories")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(json.NewDecoder(response.Body).Decode(&page)).To(Succeed())
+		Expect(page.Repositories).To(HaveLen(1))
+		Expect(page.Repositories[0].UploadCount).To(BeEquivalentTo(2))
+		Expect(page.Repositories[0].Latest.Sha).To(Equal("second"))
+
+		response, err = http.Get(server.URL + "/repositories/fake/repo/branches")
+		Expect(err).ToNot(HaveOccurred())
+		Expect(json.NewDecoder(response.Body).Decode(&page)).To(Succeed())
+		Expect(page.Branches).To(HaveLen(1))
+		Expect(page.Branches[0].Name).To(Equal("master"))
+	})
+
+	It("Should require a token when asked to", func() {
+		server.RequireToken("secret")
+		_, err := c.Latest(ctx, client.Query{Repository: "fake/repo"})
+		Expect(err).To(Equal(client.Error{StatusCode: http.StatusUnauthorized, Message: "unauthorized: authentication required"}))
+
+		c.Token = "secret"
+		_, err = c.Latest(ctx, client.Query{Repository: "fake/repo"})
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	Context("With faults", func() {
+		It("Should fail requests a number of times", func() {
+			server.Inject(Fault{Path: "/metrics", Method: "POST", Status: http.StatusTooManyRequests, Times: 2})
+			_, err := c.Record(ctx, coverage.Metric{Repository: "fake/repo", Sha: "retried"})
+			Expect(err).ToNot(HaveOccurred())
+			Expect(server.Requests()).To(Equal(3))
+			Expect(server.Uploads()).To(HaveLen(1))
+		})
+
+		It("Should fail every matching request until cleared", func() {
+			server.Inject(Fault{Status: http.StatusInternalServerError})
+			_, err := c.Latest(ctx, client.Query{Repository: "fake/repo"})
+			Expect(err).To(MatchError(ContainSubstring("injected fault")))
+
+			server.ClearFaults()
+			_, err = c.Latest(ctx, client.Query{Repository: "fake/repo"})
+			Expect(err).ToNot(HaveOccurred())
+		})
+
+		It("Should delay responses", func() {
+			server.Inject(Fault{Latency: time.Hour})
+			timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+			defer cancel()
+			_, err := c.Latest(timeout, client.Query{Repository: "fake/repo"})
+			Expect(err).To(HaveOccurred())
+		})
+	})
+})
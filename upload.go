@@ -0,0 +1,253 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package uberalls
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/uber/uberalls/client"
+	"github.com/uber/uberalls/coverage"
+)
+
+// clientFlags are the flags of commands calling an uberalls server
+type clientFlags struct {
+	server  *string
+	token   *string
+	retries *int
+}
+
+// addClientFlags defines the flags of commands calling an uberalls server,
+// defaulting to the UBERALLS_URL and UBERALLS_TOKEN environment variables
+func addClientFlags(flags *flag.FlagSet) clientFlags {
+	server := os.Getenv("UBERALLS_URL")
+	if server == "" {
+		server = client.DefaultURL
+	}
+	return clientFlags{
+		server:  flags.String("server", server, "URL of the uberalls server (default $UBERALLS_URL)"),
+		token:   flags.String("token", os.Getenv("UBERALLS_TOKEN"), "API or OIDC token (default $UBERALLS_TOKEN)"),
+		retries: flags.Int("retries", 3, "how many times to retry failed requests"),
+	}
+}
+
+func (cf clientFlags) client() *client.Client {
+	c := client.New(*cf.server, *cf.token)
+	c.Retries = *cf.retries
+	c.OnRetry = func(err error, wait time.Duration) {
+		logger.Warn("Retrying request", "wait", wait, "error", err)
+	}
+	return c
+}
+
+// buildFlags are the flags overriding the build a client command detects
+type buildFlags struct {
+	dir        *string
+	repository *string
+	sha        *string
+	branch     *string
+	suite      *string
+	baseBranch *string
+	baseSha    *string
+}
+
+func addBuildFlags(flags *flag.FlagSet) buildFlags {
+	return buildFlags{
+		dir:        flags.String("dir", ".", "workspace of the build"),
+		repository: flags.String("repo", "", "repository (default: detected from CI or git)"),
+		sha:        flags.String("sha", "", "commit (default: detected from CI or git)"),
+		branch:     flags.String("branch", "", "branch (default: detected from CI or git)"),
+		suite:      flags.String("suite", "", "test suite"),
+		baseBranch: flags.String("base-branch", "", "branch to compare with (default: the pull request's target, or --branch)"),
+		baseSha:    flags.String("base-sha", "", "commit to compare with, instead of the latest of --base-branch"),
+	}
+}
+
+// build detects the build, then applies the flags given
+func (bf buildFlags) build() (Build, error) {
+	build := DetectBuild(os.Getenv, *bf.dir)
+	for _, override := range []struct {
+		flag  *string
+		field *string
+	}{
+		{bf.repository, &build.Repository},
+		{bf.sha, &build.Sha},
+		{bf.branch, &build.Branch},
+		{bf.baseBranch, &build.BaseBranch},
+		{bf.baseSha, &build.BaseSha},
+	} {
+		if *override.flag != "" {
+			*override.field = *override.flag
+		}
+	}
+
+	switch {
+	case build.Repository == "":
+		return build, errors.New("cannot detect the repository; pass --repo")
+	case build.Sha == "":
+		return build, errors.New("cannot detect the commit; pass --sha")
+	}
+	return build, nil
+}
+
+// baseQuery selects the metric a build is compared with: the pull request's
+// base commit or target branch, or otherwise the latest of its own branch
+func (bf buildFlags) baseQuery(build Build) (client.Query, string) {
+	query := client.Query{Repository: build.Repository, Suite: *bf.suite}
+	switch {
+	case build.BaseSha != "":
+		query.Sha = build.BaseSha
+		return query, build.BaseSha
+	case build.BaseBranch != "":
+		query.Branch = build.BaseBranch
+		return query, build.BaseBranch
+	case build.Branch != "":
+		query.Branch = build.Branch
+		return query, build.Branch
+	}
+	query.Branch = defaultBranch
+	return query, defaultBranch
+}
+
+// findBase fetches the metric a build's head is compared with. A branch's
+// latest upload is often head itself, as on pushes to it, so uploads of
+// head's commit, and any later than head, are skipped.
+func (bf buildFlags) findBase(ctx context.Context, c *client.Client, build Build, head Metric) (*Metric, string, error) {
+	query, label := bf.baseQuery(build)
+	query.Component = head.Component
+	query.Until = head.Timestamp
+	for {
+		base, err := c.Latest(ctx, query)
+		if err != nil || base == nil || base.Sha != head.Sha {
+			return base, label, err
+		}
+		if base.Timestamp <= 1 {
+			return nil, label, nil
+		}
+		query.Until = base.Timestamp - 1
+	}
+}
+
+func runUpload(args []string, stdout, stderr io.Writer) error {
+	flags := newCommandFlags("upload", stderr)
+	api := addClientFlags(flags)
+	build := addBuildFlags(flags)
+	var reports stringsFlag
+	flags.Var(&reports, "report", "coverage report to upload; may be repeated (default: found in --dir)")
+	dryRun := flags.Bool("dry-run", false, "print what would be uploaded without uploading it")
+	if err := flags.Parse(args); err != nil {
+		return usageError{err}
+	}
+	if flags.NArg() > 0 {
+		return fmt.Errorf("unexpected argument '%s'", flags.Arg(0))
+	}
+
+	b, err := build.build()
+	if err != nil {
+		return err
+	}
+
+	root := git(*build.dir, "rev-parse", "--show-toplevel")
+	if root == "" {
+		if root, err = filepath.Abs(*build.dir); err != nil {
+			return err
+		}
+	}
+	if len(reports) == 0 {
+		if reports, err = FindReports(*build.dir); err != nil {
+			return err
+		}
+		if len(reports) == 0 {
+			return fmt.Errorf("no coverage reports found in %s; pass --report", *build.dir)
+		}
+	}
+
+	var parsed [][]SourceFile
+	for _, report := range reports {
+		files, err := ParseReport(report, root)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stderr, "Read %d file(s) from %s\n", len(files), report)
+		parsed = append(parsed, files)
+	}
+
+	m := Metric{
+		Repository:  b.Repository,
+		Sha:         b.Sha,
+		Branch:      b.Branch,
+		Suite:       *build.suite,
+		SourceFiles: coverage.MergeSourceFiles(parsed...),
+	}
+	m.ApplySourceFiles()
+	if *dryRun {
+		fmt.Fprintf(stdout, "Would upload %s@%s (%s) from %s\n", m.Repository, m.Sha, m.Branch, describeCI(b))
+		return PrintComparison(stdout, m, nil, "")
+	}
+
+	c := api.client()
+	base, baseLabel, err := build.findBase(context.Background(), c, b, m)
+	if err != nil {
+		return fmt.Errorf("querying base: %v", err)
+	}
+	recorded, err := c.Record(context.Background(), m)
+	if err != nil {
+		return fmt.Errorf("uploading: %v", err)
+	}
+
+	fmt.Fprintf(stdout, "Uploaded %s@%s (%s) from %s\n", recorded.Repository, recorded.Sha, recorded.Branch, describeCI(b))
+	return PrintComparison(stdout, *recorded, base, baseLabel)
+}
+
+func describeCI(b Build) string {
+	if b.CI == "" {
+		return "git"
+	}
+	return b.CI
+}
+
+// PrintComparison prints a metric's coverage, and its change from base when
+// there is one
+func PrintComparison(w io.Writer, m Metric, base *Metric, baseLabel string) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	if base == nil {
+		if baseLabel != "" {
+			fmt.Fprintf(tw, "No coverage recorded for %s to compare with\n", baseLabel)
+		}
+		fmt.Fprintf(tw, "line coverage\t%.2f%%\t\n", m.LineCoverage)
+		fmt.Fprintf(tw, "conditional coverage\t%.2f%%\t\n", m.ConditionalCoverage)
+		fmt.Fprintf(tw, "lines covered\t%d/%d\t\n", m.LinesCovered, m.LinesTested)
+		return tw.Flush()
+	}
+
+	fmt.Fprintf(tw, "\t%s\t%s (%.7s)\tchange\n", "this commit", baseLabel, base.Sha)
+	fmt.Fprintf(tw, "line coverage\t%.2f%%\t%.2f%%\t%+.2f\n", m.LineCoverage, base.LineCoverage, m.LineCoverage-base.LineCoverage)
+	fmt.Fprintf(tw, "conditional coverage\t%.2f%%\t%.2f%%\t%+.2f\n", m.ConditionalCoverage, base.ConditionalCoverage, m.ConditionalCoverage-base.ConditionalCoverage)
+	fmt.Fprintf(tw, "lines covered\t%d/%d\t%d/%d\t%+d\n", m.LinesCovered, m.LinesTested, base.LinesCovered, base.LinesTested, m.LinesCovered-base.LinesCovered)
+	return tw.Flush()
+}
@@ -0,0 +1,104 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package uberalls_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	"github.com/jinzhu/gorm"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/uber/uberalls"
+)
+
+var _ = Describe("Uploading coverage", func() {
+	var (
+		db             *gorm.DB
+		server         *httptest.Server
+		dir            string
+		stdout, stderr bytes.Buffer
+		repository     string
+	)
+
+	upload := func(args ...string) int {
+		stdout.Reset()
+		stderr.Reset()
+		base := []string{"upload", "--server", server.URL, "--dir", dir, "--repo", repository, "--retries", "0"}
+		return Run(append(base, args...), &stdout, &stderr)
+	}
+
+	BeforeEach(func() {
+		config := &Config{DBType: "sqlite3", DBLocation: "test.sqlite"}
+		db, _ = config.DB()
+		Expect(config.Automigrate()).To(Succeed())
+		server = httptest.NewServer(NewMetricsHandler(db, nil))
+
+		repository = "upload/repo"
+		db.Where("repository = ?", repository).Delete(Metric{})
+		dir, _ = ioutil.TempDir("", "uberalls-upload")
+		Expect(ioutil.WriteFile(filepath.Join(dir, "lcov.info"), []byte("SF:a.js\nDA:1,1\nDA:2,1\nDA:3,0\nDA:4,0\nend_of_record\n"), 0644)).To(Succeed())
+	})
+
+	AfterEach(func() {
+		server.Close()
+		os.RemoveAll(dir)
+	})
+
+	It("Should upload the reports it finds", func() {
+		Expect(upload("--sha", "deadbeef", "--branch", "master")).To(Equal(0))
+		Expect(stdout.String()).To(ContainSubstring("Uploaded upload/repo@deadbeef (master)"))
+		Expect(stdout.String()).To(ContainSubstring("No coverage recorded for master"))
+		Expect(stdout.String()).To(MatchRegexp(`line coverage\s+50.00%`))
+
+		m := Metric{}
+		db.Where("repository = ? AND sha = ?", repository, "deadbeef").First(&m)
+		Expect(m.LinesCovered).To(BeEquivalentTo(2))
+		Expect(m.LinesTested).To(BeEquivalentTo(4))
+	})
+
+	It("Should print the change from the base branch", func() {
+		Expect(upload("--sha", "base", "--branch", "master")).To(Equal(0))
+		Expect(ioutil.WriteFile(filepath.Join(dir, "lcov.info"), []byte("SF:a.js\nDA:1,1\nDA:2,1\nDA:3,1\nDA:4,0\nend_of_record\n"), 0644)).To(Succeed())
+
+		Expect(upload("--sha", "head", "--branch", "feature", "--base-branch", "master")).To(Equal(0))
+		Expect(stdout.String()).To(MatchRegexp(`line coverage\s+75.00%\s+50.00%\s+\+25.00`))
+		Expect(stdout.String()).To(MatchRegexp(`lines covered\s+3/4\s+2/4\s+\+1`))
+	})
+
+	It("Should not upload on a dry run", func() {
+		Expect(upload("--sha", "dry", "--dry-run")).To(Equal(0))
+		Expect(stdout.String()).To(ContainSubstring("Would upload upload/repo@dry"))
+		m := Metric{}
+		db.Where("repository = ? AND sha = ?", repository, "dry").First(&m)
+		Expect(m.ID).To(BeZero())
+	})
+
+	It("Should fail without reports", func() {
+		os.Remove(filepath.Join(dir, "lcov.info"))
+		Expect(upload("--sha", "deadbeef")).To(Equal(1))
+		Expect(stderr.String()).To(ContainSubstring("no coverage reports found"))
+	})
+})
@@ -0,0 +1,290 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package uberalls
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+)
+
+// ConfigError is a problem with one configuration key, and the layer that
+// set it, like "file:config/default.json" or "env:UBERALLS_LISTEN_PORT"
+type ConfigError struct {
+	Source  string
+	Key     string
+	Message string
+}
+
+func (e ConfigError) Error() string {
+	if e.Key == "" {
+		return fmt.Sprintf("%s: %s", e.Source, e.Message)
+	}
+	return fmt.Sprintf("%s: %s: %s", e.Source, e.Key, e.Message)
+}
+
+// ConfigErrors collects every problem found in a configuration
+type ConfigErrors []ConfigError
+
+func (errs ConfigErrors) Error() string {
+	lines := make([]string, 0, len(errs)+1)
+	lines = append(lines, fmt.Sprintf("%d configuration problem(s):", len(errs)))
+	for _, err := range errs {
+		lines = append(lines, "  "+err.Error())
+	}
+	return strings.Join(lines, "\n")
+}
+
+// orNil returns errs as an error, or nil when there are none
+func (errs ConfigErrors) orNil() error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// findSetting finds the setting at a path of config file keys, matching
+// case-insensitively like encoding/json
+func findSetting(settings []Setting, path []string) (Setting, bool) {
+	for _, s := range settings {
+		if len(s.path) == len(path) && pathHasPrefix(s.path, path) {
+			return s, true
+		}
+	}
+	return Setting{}, false
+}
+
+// isSection reports whether a path of config file keys names a section
+// holding settings, like "tls"
+func isSection(settings []Setting, path []string) bool {
+	for _, s := range settings {
+		if len(s.path) > len(path) && pathHasPrefix(s.path, path) {
+			return true
+		}
+	}
+	return false
+}
+
+func pathHasPrefix(path, prefix []string) bool {
+	for i, part := range prefix {
+		if !strings.EqualFold(path[i], part) {
+			return false
+		}
+	}
+	return true
+}
+
+// decodeFile decodes a config file into c key by key, rejecting unknown keys
+// and recording the file as the provenance of each key it sets
+func decodeFile(c *Config, path string, content []byte, provenance Provenance) ConfigErrors {
+	source := LayerFile + ":" + path
+	content, err := toJSON(path, content)
+	if err != nil {
+		return ConfigErrors{{source, "", err.Error()}}
+	}
+
+	settings := Settings()
+	var errs ConfigErrors
+
+	var walk func(raw json.RawMessage, prefix []string)
+	walk = func(raw json.RawMessage, prefix []string) {
+		fields := map[string]json.RawMessage{}
+		if err := json.Unmarshal(raw, &fields); err != nil {
+			if len(prefix) == 0 {
+				errs = append(errs, ConfigError{source, "", err.Error()})
+			} else {
+				errs = append(errs, ConfigError{source, strings.Join(prefix, "."), "must be an object"})
+			}
+			return
+		}
+
+		keys := make([]string, 0, len(fields))
+		for key := range fields {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			path := append(append([]string{}, prefix...), key)
+			if s, ok := findSetting(settings, path); ok {
+				if err := json.Unmarshal(fields[key], s.field(c).Addr().Interface()); err != nil {
+					errs = append(errs, ConfigError{source, s.Key, describeDecodeError(err)})
+				} else if provenance != nil {
+					provenance[s.Key] = source
+				}
+			} else if isSection(settings, path) {
+				walk(fields[key], path)
+			} else {
+				errs = append(errs, ConfigError{source, strings.Join(path, "."), "unknown key"})
+			}
+		}
+	}
+
+	walk(content, nil)
+	return errs
+}
+
+func describeDecodeError(err error) string {
+	if typeErr, ok := err.(*json.UnmarshalTypeError); ok {
+		return fmt.Sprintf("must be %s, not %s", typeErr.Type, typeErr.Value)
+	}
+	return err.Error()
+}
+
+// Validate checks a configuration for problems, attributing each to the layer
+// that set the offending key
+func Validate(c *Config, provenance Provenance) ConfigErrors {
+	var errs ConfigErrors
+	problem := func(key, format string, args ...interface{}) {
+		source := provenance[key]
+		if source == "" {
+			source = LayerDefault
+		}
+		errs = append(errs, ConfigError{source, key, fmt.Sprintf(format, args...)})
+	}
+	checkFile := func(key, path string) {
+		if path == "" {
+			return
+		}
+		if _, err := os.Stat(path); err != nil {
+			problem(key, "%v", err)
+		}
+	}
+
+	drivers := sql.Drivers()
+	supported := false
+	for _, driver := range drivers {
+		supported = supported || driver == c.DBType
+	}
+	if !supported {
+		problem("dbType", "'%s' is not supported; use one of %s", c.DBType, strings.Join(drivers, ", "))
+	}
+	if c.DBLocation == "" {
+		problem("dbLocation", "is required")
+	}
+	if c.ListenPort < 1 || c.ListenPort > 65535 {
+		problem("listenPort", "%d is not between 1 and 65535", c.ListenPort)
+	}
+
+	if _, err := NewLogger(nil, LogConfig{Format: c.Log.Format}); err != nil {
+		problem("log.format", "%v", err)
+	}
+	if _, err := NewLogger(nil, LogConfig{Level: c.Log.Level}); err != nil {
+		problem("log.level", "%v", err)
+	}
+
+	if c.TLS.Enabled() {
+		if c.TLS.CertFile == "" {
+			problem("tls.certFile", "is required with tls.keyFile")
+		}
+		if c.TLS.KeyFile == "" {
+			problem("tls.keyFile", "is required with tls.certFile")
+		}
+	}
+	checkFile("tls.certFile", c.TLS.CertFile)
+	checkFile("tls.keyFile", c.TLS.KeyFile)
+	checkFile("tls.clientCAFile", c.TLS.ClientCAFile)
+	if _, ok := tlsVersions[c.TLS.MinVersion]; c.TLS.MinVersion != "" && !ok {
+		problem("tls.minVersion", "'%s' is not one of 1.0, 1.1, 1.2 or 1.3", c.TLS.MinVersion)
+	}
+
+	if c.OIDC.Enabled() {
+		if c.OIDC.Issuer == "" {
+			problem("oidc.issuer", "is required to accept OIDC tokens")
+		}
+		if c.OIDC.Audience == "" {
+			problem("oidc.audience", "is required to accept OIDC tokens")
+		}
+	}
+	if c.OIDC.JWKSFile != "" && c.OIDC.JWKSURL != "" {
+		problem("oidc.jwksURL", "can't be set with oidc.jwksFile")
+	}
+	checkFile("oidc.jwksFile", c.OIDC.JWKSFile)
+	for _, glob := range c.OIDC.Refs {
+		if err := CheckGlob(glob); err != nil {
+			problem("oidc.refs", "%v", err)
+		}
+	}
+	if c.OIDC.JWKSURL != "" {
+		if u, err := url.Parse(c.OIDC.JWKSURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			problem("oidc.jwksURL", "'%s' is not an HTTP(S) URL", c.OIDC.JWKSURL)
+		}
+	}
+
+	if c.Limits.RatePerSecond < 0 {
+		problem("limits.ratePerSecond", "can't be negative")
+	}
+	if c.Limits.Burst < 0 {
+		problem("limits.burst", "can't be negative")
+	}
+	contentTypes := make([]string, 0, len(c.Limits.MaxBodySize))
+	for contentType := range c.Limits.MaxBodySize {
+		contentTypes = append(contentTypes, contentType)
+	}
+	sort.Strings(contentTypes)
+	for _, contentType := range contentTypes {
+		if c.Limits.MaxBodySize[contentType] < 1 {
+			problem("limits.maxBodySize", "limit for '%s' must be positive", contentType)
+		}
+	}
+
+	components := make([]string, 0, len(c.Components))
+	for repository := range c.Components {
+		components = append(components, repository)
+	}
+	sort.Strings(components)
+	for _, repository := range components {
+		for _, name := range c.Components.Components(repository) {
+			for _, glob := range c.Components[repository][name] {
+				if err := CheckGlob(glob); err != nil {
+					problem("components", "%s of '%s': %v", name, repository, err)
+				}
+			}
+		}
+	}
+
+	repositories := make([]string, 0, len(c.Policy))
+	for repository := range c.Policy {
+		repositories = append(repositories, repository)
+	}
+	sort.Strings(repositories)
+	for _, repository := range repositories {
+		policy := c.Policy[repository]
+		for _, threshold := range []struct {
+			name  string
+			value *float64
+		}{
+			{"minLine", policy.MinLine},
+			{"minConditional", policy.MinConditional},
+			{"maxLineDrop", policy.MaxLineDrop},
+			{"maxConditionalDrop", policy.MaxConditionalDrop},
+		} {
+			if threshold.value != nil && (*threshold.value < 0 || *threshold.value > 100) {
+				problem("policy", "%s of '%s' must be between 0 and 100", threshold.name, repository)
+			}
+		}
+	}
+	return errs
+}
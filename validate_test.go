@@ -0,0 +1,135 @@
+// Copyright (c) 2015 Uber Technologies, Inc.
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE.
+
+package uberalls_test
+
+import (
+	"io/ioutil"
+	"os"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	. "github.com/uber/uberalls"
+)
+
+var _ = Describe("Config validation", func() {
+	var file string
+
+	writeConfig := func(content string) {
+		f, _ := ioutil.TempFile("", "uberalls-config")
+		f.WriteString(content)
+		f.Close()
+		file = f.Name()
+	}
+
+	AfterEach(func() {
+		os.Remove(file)
+	})
+
+	It("Should accept the default configuration", func() {
+		_, _, err := LoadValidated([]string{"--config", DefaultConfig})
+		Expect(err).ToNot(HaveOccurred())
+	})
+
+	It("Should reject unknown keys with their file", func() {
+		writeConfig(`{"dbType": "sqlite3", "dbLocation": "test.sqlite", "listenPort": 1,
+			"dbTpye": "mysql", "tls": {"certFlie": "x"}}`)
+		_, err := LoadConfig(&Config{}, file)
+		Expect(err).To(HaveOccurred())
+
+		errs, ok := err.(ConfigErrors)
+		Expect(ok).To(BeTrue())
+		Expect(errs).To(ConsistOf(
+			ConfigError{"file:" + file, "dbTpye", "unknown key"},
+			ConfigError{"file:" + file, "tls.certFlie", "unknown key"},
+		))
+	})
+
+	It("Should accept keys in any case, and free-form maps", func() {
+		writeConfig(`{"DBType": "sqlite3", "components": {"monorepo": {"api": ["api/**"]}},
+			"limits": {"maxBodySize": {"application/json": 1024}}}`)
+		c := &Config{}
+		_, err := LoadConfig(c, file)
+		Expect(err).ToNot(HaveOccurred())
+		Expect(c.DBType).To(Equal("sqlite3"))
+		Expect(c.Limits.MaxBodySize).To(HaveKeyWithValue("application/json", BeEquivalentTo(1024)))
+	})
+
+	It("Should report every problem at once", func() {
+		writeConfig(`{"dbType": "postgres", "dbLocation": "", "listenPort": "80",
+			"tls": {"certFile": "/missing/server.pem", "minVersion": "1.4"},
+			"log": {"level": "loud"}}`)
+		os.Setenv("UBERALLS_LIMITS_BURST", "-1")
+		defer os.Unsetenv("UBERALLS_LIMITS_BURST")
+
+		config, _, err := LoadValidated([]string{"--config", file, "--listen-address", "0.0.0.0"})
+		Expect(config).ToNot(BeNil())
+		Expect(err).To(HaveOccurred())
+
+		keys := map[string]string{}
+		for _, e := range err.(ConfigErrors) {
+			keys[e.Key] = e.Source
+		}
+		source := "file:" + file
+		Expect(keys).To(HaveKeyWithValue("listenPort", source))
+		Expect(keys).To(HaveKeyWithValue("dbType", source))
+		Expect(keys).To(HaveKeyWithValue("tls.certFile", source))
+		Expect(keys).To(HaveKeyWithValue("tls.keyFile", LayerDefault))
+		Expect(keys).To(HaveKeyWithValue("tls.minVersion", source))
+		Expect(keys).To(HaveKeyWithValue("log.level", source))
+		Expect(keys).To(HaveKeyWithValue("limits.burst", "env:UBERALLS_LIMITS_BURST"))
+		Expect(err.Error()).To(ContainSubstring("listenPort: must be int, not string"))
+	})
+
+	It("Should check port ranges", func() {
+		c := &Config{DBType: "sqlite3", DBLocation: "test.sqlite", ListenPort: 70000}
+		errs := Validate(c, Provenance{"listenPort": "flag:--listen-port"})
+		Expect(errs).To(HaveLen(1))
+		Expect(errs[0].Error()).To(Equal("flag:--listen-port: listenPort: 70000 is not between 1 and 65535"))
+	})
+
+	It("Should check OIDC key sources", func() {
+		c := &Config{DBType: "sqlite3", DBLocation: "test.sqlite", ListenPort: 80}
+		c.OIDC = OIDCConfig{JWKSFile: "/missing/jwks.json", JWKSURL: "ftp://example.com/jwks"}
+		keys := []string{}
+		for _, e := range Validate(c, Provenance{}) {
+			keys = append(keys, e.Key)
+		}
+		Expect(keys).To(ConsistOf("oidc.issuer", "oidc.audience", "oidc.jwksURL", "oidc.jwksFile", "oidc.jwksURL"))
+	})
+
+	It("Should check component and OIDC ref globs", func() {
+		c := &Config{DBType: "sqlite3", DBLocation: "test.sqlite", ListenPort: 80}
+		c.Components = ComponentConfig{"monorepo": {"api": {"api/**", "/web/**"}}}
+		c.OIDC.Refs = []string{""}
+		errs := Validate(c, Provenance{"components": "file:uberalls.json"})
+		Expect(errs).To(HaveLen(2))
+		Expect(errs[0].Error()).To(Equal("default: oidc.refs: glob is empty"))
+		Expect(errs[1].Error()).To(Equal("file:uberalls.json: components: api of 'monorepo': glob '/web/**' can't match relative paths"))
+	})
+
+	It("Should check coverage policies", func() {
+		writeConfig(`{"dbType": "sqlite3", "dbLocation": "test.sqlite", "listenPort": 80,
+			"policy": {"*": {"minLine": 60}, "monorepo": {"maxLineDrop": 150}}}`)
+		_, _, err := LoadValidated([]string{"--config", file})
+		Expect(err).To(HaveOccurred())
+		Expect(err.Error()).To(ContainSubstring("policy: maxLineDrop of 'monorepo' must be between 0 and 100"))
+	})
+})